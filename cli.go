@@ -1,23 +1,31 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/gwaycc/mdoc/client"
 	"github.com/gwaycc/mdoc/route"
 	"github.com/gwaycc/mdoc/tools/auth"
 	"github.com/gwaycc/mdoc/tools/repo"
 
+	httpauth "github.com/abbot/go-http-auth"
 	"github.com/gwaylib/errors"
 	"github.com/gwaylib/eweb"
 	"github.com/gwaylib/log"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 // resgister daemon
@@ -36,6 +44,173 @@ func init() {
 					Value: true,
 					Usage: "run the authentication mode",
 				},
+				&cli.StringFlag{
+					Name:  "login-mode",
+					Value: "digest",
+					Usage: "login mode of the authentication, 'digest', 'session' or 'oidc'. the digest login is always available for the 'user' command",
+				},
+				&cli.StringFlag{
+					Name:  "realm",
+					Value: "",
+					Usage: "realm of the authentication, it is kept in the db, empty to use the kept one or 'mdoc'. the users need to reset the password after changed, except who login by the form",
+				},
+				&cli.BoolFlag{
+					Name:  "digest",
+					Value: true,
+					Usage: "enable the digest authentication, set false to keep only the kdf hash of passwords, it needs the session mode",
+				},
+				&cli.StringFlag{
+					Name:  "digest-algorithms",
+					Value: "SHA-256,SHA-512-256,MD5",
+					Usage: "algorithms of the digest challenges in order, the client uses the first one it supports. SHA-256 is first since some clients compute SHA-512-256 wrongly",
+				},
+				&cli.DurationFlag{
+					Name:  "session-expires",
+					Value: 7 * 24 * time.Hour,
+					Usage: "expiration of the login session, only for the session and oidc mode",
+				},
+				&cli.StringFlag{
+					Name:  "oidc-issuer",
+					Value: "",
+					Usage: "issuer url of the OpenID Connect identity provider, only for the oidc mode",
+				},
+				&cli.StringFlag{
+					Name:  "oidc-client-id",
+					Value: "",
+					Usage: "client id registered in the identity provider",
+				},
+				&cli.StringFlag{
+					Name:    "oidc-client-secret",
+					Value:   "",
+					Usage:   "client secret registered in the identity provider, empty for the public client",
+					EnvVars: []string{"MDOC_OIDC_CLIENT_SECRET"},
+				},
+				&cli.StringFlag{
+					Name:  "oidc-redirect-url",
+					Value: "",
+					Usage: "callback url registered in the identity provider, e.g. 'https://doc.example.com/login/oidc/callback'",
+				},
+				&cli.StringFlag{
+					Name:  "oidc-scopes",
+					Value: "openid,profile,email",
+					Usage: "comma separated scopes to request",
+				},
+				&cli.StringFlag{
+					Name:  "oidc-username-claim",
+					Value: "preferred_username",
+					Usage: "claim of the id token used as the username",
+				},
+				&cli.StringFlag{
+					Name:  "oidc-groups-claim",
+					Value: "groups",
+					Usage: "claim of the id token that lists the groups of user",
+				},
+				&cli.StringFlag{
+					Name:  "oidc-group-roles",
+					Value: "",
+					Usage: "groups of the identity provider to sync with the role, e.g. 'idp-admins=admin,writers=editor'",
+				},
+				&cli.BoolFlag{
+					Name:  "oidc-auto-provision",
+					Value: false,
+					Usage: "add the user who is not found at the first login",
+				},
+				&cli.StringFlag{
+					Name:  "ldap-url",
+					Value: "",
+					Usage: "url of the LDAP directory to authenticate the users by bind, e.g. 'ldaps://ldap.example.com:636', it needs the session mode",
+				},
+				&cli.BoolFlag{
+					Name:  "ldap-starttls",
+					Value: false,
+					Usage: "upgrade the ldap:// connection with StartTLS",
+				},
+				&cli.StringFlag{
+					Name:  "ldap-bind-dn",
+					Value: "",
+					Usage: "dn of the account to search the users, empty for the anonymous search",
+				},
+				&cli.StringFlag{
+					Name:    "ldap-bind-passwd",
+					Value:   "",
+					Usage:   "password of the ldap-bind-dn",
+					EnvVars: []string{"MDOC_LDAP_BIND_PASSWD"},
+				},
+				&cli.StringFlag{
+					Name:  "ldap-base-dn",
+					Value: "",
+					Usage: "base dn to search the users, e.g. 'ou=people,dc=example,dc=com'",
+				},
+				&cli.StringFlag{
+					Name:  "ldap-user-filter",
+					Value: "(uid=%s)",
+					Usage: "filter to search the user, %s is the username",
+				},
+				&cli.StringFlag{
+					Name:  "ldap-group-base-dn",
+					Value: "",
+					Usage: "base dn to search the groups, default is the ldap-base-dn",
+				},
+				&cli.StringFlag{
+					Name:  "ldap-group-filter",
+					Value: "(member=%s)",
+					Usage: "filter to search the groups of user, %s is the dn of user",
+				},
+				&cli.StringFlag{
+					Name:  "ldap-group-roles",
+					Value: "",
+					Usage: "groups of the directory to sync with the role, e.g. 'ldap-admins=admin,writers=editor'",
+				},
+				&cli.BoolFlag{
+					Name:  "ldap-auto-provision",
+					Value: false,
+					Usage: "add the user who is not found at the first login",
+				},
+				&cli.DurationFlag{
+					Name:  "ldap-cache-expires",
+					Value: 5 * time.Minute,
+					Usage: "how long the success of bind is cached, 0 to bind at each login",
+				},
+				&cli.IntFlag{
+					Name:  "passwd-min-len",
+					Value: 6,
+					Usage: "minimum length of the password changed by the user self",
+				},
+				&cli.IntFlag{
+					Name:  "passwd-history",
+					Value: 3,
+					Usage: "number of the recent passwords that can not be reused, 0 to disable",
+				},
+				&cli.DurationFlag{
+					Name:  "audit-retention",
+					Value: 90 * 24 * time.Hour,
+					Usage: "how long the audit records are kept, 0 to keep forever",
+				},
+				&cli.StringFlag{
+					Name:  "trusted-proxies",
+					Value: "",
+					Usage: "comma separated CIDRs of the reverse proxies, the client ip is read from the Forwarded or X-Forwarded-For header sent by them",
+				},
+				&cli.IntFlag{
+					Name:  "limit-times",
+					Value: 4,
+					Usage: "login failures allowed before locking the user from the ip",
+				},
+				&cli.DurationFlag{
+					Name:  "limit-backoff",
+					Value: 30 * time.Minute,
+					Usage: "lock time of each failure over the limit-times, and the time to forget the failures",
+				},
+				&cli.IntFlag{
+					Name:  "limit-captcha",
+					Value: 2,
+					Usage: "login failures before the login form requires a captcha, 0 to disable. only for the session and oidc mode",
+				},
+				&cli.StringFlag{
+					Name:  "public-url",
+					Value: "",
+					Usage: "external url of the server for the invite links, e.g. 'https://doc.example.com', empty for the origin of oidc-redirect-url",
+				},
 				&cli.StringFlag{
 					Name:  "listen",
 					Value: ":8080",
@@ -47,31 +222,145 @@ func init() {
 				_ = ctx
 
 				authMode := cctx.Bool("auth-mode")
+				loginMode := cctx.String("login-mode")
+				switch loginMode {
+				case "digest", "session", "oidc":
+				default:
+					return errors.New("unknown login mode").As(loginMode)
+				}
+				digestMode := cctx.Bool("digest")
+				if !digestMode && loginMode == "digest" {
+					return errors.New("disable digest need the session or oidc login mode")
+				}
 				listenAddr := cctx.String("listen")
 				repoDir := repo.ExpandPath(cctx.String("repo"))
 
 				// digest auth
 				auth.InitDB(filepath.Join(repoDir, "data", "mdoc.db"))
-				authPasswd := func(user, realm string) string {
-					pwd, ok := auth.GetAuthCache(user)
-					if ok {
-						return pwd
+				auth.DisableDigest(!digestMode)
+				auth.SetPasswdPolicy(auth.PasswdPolicy{
+					MinLen:  cctx.Int("passwd-min-len"),
+					History: cctx.Int("passwd-history"),
+				})
+				if cctx.Int("limit-times") < 0 || cctx.Duration("limit-backoff") <= 0 {
+					return errors.New("invalid limit-times or limit-backoff")
+				}
+				if cctx.Int("limit-captcha") < 0 || cctx.Int("limit-captcha") > cctx.Int("limit-times") {
+					return errors.New("limit-captcha need between 0 and limit-times")
+				}
+				auth.SetLimitPolicy(auth.LimitPolicy{
+					Times:   cctx.Int("limit-times"),
+					Backoff: cctx.Duration("limit-backoff"),
+					Captcha: cctx.Int("limit-captcha"),
+				})
+				auth.SetAuditRetention(cctx.Duration("audit-retention"))
+				if err := auth.InitRealm(cctx.String("realm")); err != nil {
+					return errors.As(err)
+				}
+				if !digestMode {
+					users, err := auth.ClearDigest()
+					if err != nil {
+						return errors.As(err)
 					}
-
-					uInfo, err := auth.GetUser(user)
+					if len(users) > 0 {
+						log.Warnf("%d users have no argon2id hash, their HA1 is kept until they login: %s", len(users), strings.Join(users, ","))
+					}
+				}
+				if stale, err := auth.CountStaleUsers(); err != nil {
+					return errors.As(err)
+				} else if stale > 0 {
+					log.Warnf("%d users have the password of other realm, they need to reset the password for the realm '%s'", stale, auth.GetRealm())
+				}
+				if err := auth.SetTrustedProxies(strings.Split(cctx.String("trusted-proxies"), ",")); err != nil {
+					return errors.As(err)
+				}
+				publicURL := cctx.String("public-url")
+				if redirectURL := cctx.String("oidc-redirect-url"); len(publicURL) == 0 && len(redirectURL) > 0 {
+					u, err := url.Parse(redirectURL)
 					if err != nil {
-						if errors.ErrNoData.Equal(err) {
-							return ""
-						}
-						log.Warn(errors.As(err, user, realm))
-						return ""
+						return errors.As(err, redirectURL)
+					}
+					publicURL = u.Scheme + "://" + u.Host
+				}
+				if err := route.SetPublicURL(publicURL); err != nil {
+					return errors.As(err)
+				}
+				if ldapURL := cctx.String("ldap-url"); len(ldapURL) > 0 {
+					if loginMode != "session" {
+						return errors.New("ldap need the session login mode")
 					}
-					auth.UpdateAuthCache(user, uInfo.Passwd)
-					return uInfo.Passwd
+					groupRoles, err := auth.ParseGroupRoles(cctx.String("ldap-group-roles"))
+					if err != nil {
+						return errors.As(err)
+					}
+					lp, err := auth.NewLdapProvider(auth.LdapConfig{
+						URL:           ldapURL,
+						StartTLS:      cctx.Bool("ldap-starttls"),
+						BindDN:        cctx.String("ldap-bind-dn"),
+						BindPasswd:    cctx.String("ldap-bind-passwd"),
+						BaseDN:        cctx.String("ldap-base-dn"),
+						UserFilter:    cctx.String("ldap-user-filter"),
+						GroupBaseDN:   cctx.String("ldap-group-base-dn"),
+						GroupFilter:   cctx.String("ldap-group-filter"),
+						GroupRoles:    groupRoles,
+						AutoProvision: cctx.Bool("ldap-auto-provision"),
+						CacheExpires:  cctx.Duration("ldap-cache-expires"),
+					})
+					if err != nil {
+						return errors.As(err)
+					}
+					// the local users are checked first.
+					auth.SetAuthProviders(auth.LocalProvider{}, lp)
+				}
+				// keep the nonces in db, so the clients need not login again after restarted.
+				digestLogin := auth.NewDigestAuth(auth.GetRealm(), false, auth.AuthSecret, auth.NewDBNonceStore())
+				digestLogin.AlgSecrets = auth.AuthAlgSecret
+				if algorithms, err := auth.ParseDigestAlgorithms(cctx.String("digest-algorithms")); err != nil {
+					return errors.As(err)
+				} else if err := digestLogin.SetAlgorithms(algorithms...); err != nil {
+					return errors.As(err)
 				}
-				digestLogin := auth.NewDigestAuth(auth.REALM, false, authPasswd)
 				ignore, _ := ioutil.ReadFile(filepath.Join(repoDir, ".authignore"))
 				ignAuth := auth.ParseIgnoreAuth(ignore)
+				acl, _ := ioutil.ReadFile(filepath.Join(repoDir, ".authacl"))
+				authAcl := auth.ParseAuthAcl(acl)
+				// the ip policy is reloaded when the file changed.
+				ipPolicy, err := auth.NewIpPolicyFile(filepath.Join(repoDir, ".authip"))
+				if err != nil {
+					return errors.As(err)
+				}
+
+				// session auth
+				var sessionLogin *auth.SessionAuth
+				if loginMode != "digest" {
+					sa, err := auth.NewSessionAuth(auth.GetRealm(), cctx.Duration("session-expires"))
+					if err != nil {
+						return errors.As(err)
+					}
+					sessionLogin = sa
+					route.RegisterLogin(sessionLogin)
+				}
+				if loginMode == "oidc" {
+					groupRoles, err := auth.ParseGroupRoles(cctx.String("oidc-group-roles"))
+					if err != nil {
+						return errors.As(err)
+					}
+					oa, err := auth.NewOidcAuth(auth.OidcConfig{
+						Issuer:        cctx.String("oidc-issuer"),
+						ClientID:      cctx.String("oidc-client-id"),
+						ClientSecret:  cctx.String("oidc-client-secret"),
+						RedirectURL:   cctx.String("oidc-redirect-url"),
+						Scopes:        strings.Split(cctx.String("oidc-scopes"), ","),
+						UsernameClaim: cctx.String("oidc-username-claim"),
+						GroupsClaim:   cctx.String("oidc-groups-claim"),
+						GroupRoles:    groupRoles,
+						AutoProvision: cctx.Bool("oidc-auto-provision"),
+					}, nil)
+					if err != nil {
+						return errors.As(err)
+					}
+					route.RegisterOidc(oa)
+				}
 
 				// web server
 				var e = eweb.Default()
@@ -86,44 +375,128 @@ func init() {
 				e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
 					return func(c echo.Context) error {
 						req := c.Request()
-						uri := req.URL.Path
+						// the rules are matched by the path that the static handler serves.
+						uri := auth.CleanPath(req.URL.Path)
+						clientIp := auth.ClientIp(req)
+						route.SetClientIp(c, clientIp)
 						if dump {
 							route.DumpReq(req)
 						}
 
+						if uri != "/check" && !ipPolicy.Allow(uri, clientIp) {
+							log.Infof("ip denied: %s %s %s", clientIp, req.Method, uri)
+							return c.String(403, "Forbidden")
+						}
+
 						switch uri {
 						case "/check": // alive check
 							return c.String(200, "1")
 						case "/favicon.ico", "", "/":
 							// continue
+						case route.INVITE_URI:
+							// the invitee has no account yet, the token of invite is checked by the page.
+						case "/login", "/logout", "/login/oidc", "/login/oidc/callback":
+							if sessionLogin != nil {
+								break
+							}
+							fallthrough
 						default:
+							if sessionLogin != nil && strings.HasPrefix(uri, route.CAPTCHA_URI_PREFIX) {
+								// the captcha of the login form.
+								break
+							}
 							if authMode && !ignAuth.Match(uri) {
 								// login check
-								username, err := digestLogin.CheckAuth(req)
-								switch {
-								case auth.ErrNeedLogin.Equal(err):
-									digestLogin.RequireAuth(c.Response().Writer, req)
-									return nil
-								case auth.ErrNeedPwd.Equal(err):
-									log.Info(errors.As(err))
-									digestLogin.RequireAuth(c.Response().Writer, req)
-									return nil
-								case auth.ErrReject.Equal(err):
-									return c.String(403, auth.ErrReject.Code())
-								default:
-									if err != nil {
+								username := ""
+								if bearer := auth.BearerToken(req); len(bearer) > 0 {
+									token, err := auth.CheckToken(bearer)
+									switch {
+									case auth.ErrTokenInvalid.Equal(err):
+										log.Info(errors.As(err, clientIp))
+										return c.String(401, auth.ErrTokenInvalid.Code())
+									case err != nil:
+										log.Warn(errors.As(err))
+										return c.String(500, "unknow error")
+									}
+									if token.Perm()&auth.AclPerm(req.Method) == 0 {
+										return c.String(403, "Token scope rejected")
+									}
+									route.SetLoginToken(c, token)
+									username = token.UserID
+								} else if sessionLogin != nil && (!digestMode || httpauth.DigestAuthParams(req.Header.Get("Authorization")) == nil) {
+									name, err := sessionLogin.CheckAuth(req)
+									switch {
+									case auth.ErrNeedLogin.Equal(err):
+										if req.Method == "GET" && !strings.HasPrefix(uri, route.API_URI_PREFIX) {
+											return c.Redirect(302, "/login?redirect="+url.QueryEscape(req.URL.RequestURI()))
+										}
+										if digestMode {
+											// offer the digest for the api clients.
+											digestLogin.RequireAuth(c.Response().Writer, req)
+											return nil
+										}
+										return c.String(401, auth.ErrNeedLogin.Code())
+									case err != nil:
 										log.Warn(errors.As(err))
 										return c.String(500, "unknow error")
 									}
-
 									// login success
+									username = name
+								} else {
+									name, err := digestLogin.CheckAuth(req)
+									switch {
+									case auth.ErrNeedLogin.Equal(err):
+										digestLogin.RequireAuth(c.Response().Writer, req)
+										return nil
+									case auth.ErrNeedPwd.Equal(err):
+										log.Info(errors.As(err, clientIp))
+										digestLogin.RequireAuth(c.Response().Writer, req)
+										return nil
+									case auth.ErrReject.Equal(err):
+										log.Info(errors.As(err, clientIp))
+										return c.String(403, auth.ErrReject.Code())
+									case auth.ErrNeedOtp.Equal(err):
+										log.Info(errors.As(err, clientIp))
+										return c.String(403, auth.ErrNeedOtp.Code())
+									default:
+										if err != nil {
+											log.Warn(errors.As(err))
+											return c.String(500, "unknow error")
+										}
+
+										// login success
+									}
+									username = name
+								}
+								route.SetLoginUser(c, username)
+
+								// access control
+								perm := auth.AclPerm(req.Method)
+								groups, err := auth.UserGroups(username, perm)
+								if err != nil {
+									log.Warn(errors.As(err))
+									return c.String(500, "unknow error")
+								}
+								if !authAcl.Allow(uri, username, groups, perm) {
+									log.Infof("acl rejected: %s %s %s %s", username, clientIp, req.Method, uri)
+									return c.String(403, "Forbidden")
 								}
-								_ = username
 							}
 						}
 
 						// next route
-						return next(c)
+						err := next(c)
+
+						// record the page read by the login user
+						if username := route.GetLoginUser(c); len(username) > 0 && err == nil &&
+							req.Method == "GET" && auth.IsDocPage(uri) {
+							if status := c.Response().Status; status == 200 || status == 304 {
+								if err := auth.AddDocRead(username, uri, time.Now()); err != nil {
+									log.Warn(errors.As(err))
+								}
+							}
+						}
+						return err
 					}
 				})
 
@@ -151,24 +524,8 @@ func init() {
 func init() {
 	app.Register("user",
 		&cli.Command{
-			Name: "user",
-			Flags: []cli.Flag{
-				&cli.StringFlag{
-					Name:  "url",
-					Value: "http://127.0.0.1:8080",
-					Usage: "server url",
-				},
-				&cli.StringFlag{
-					Name:  "admin-user",
-					Value: "admin",
-					Usage: "input the admin user",
-				},
-				&cli.StringFlag{
-					Name:  "admin-pwd",
-					Value: "",
-					Usage: "input the admin's password",
-				},
-			},
+			Name:  "user",
+			Flags: adminFlags(),
 			Subcommands: []*cli.Command{
 				&cli.Command{
 					Name:  "add",
@@ -197,16 +554,7 @@ func init() {
 						username := cctx.String("username")
 						passwd := cctx.String("passwd")
 						nickName := cctx.String("nickname")
-
-						params := url.Values{
-							"username": {username},
-							"passwd":   {auth.HashPasswd(username, auth.REALM, passwd)},
-							"nickname": {nickName},
-						}
-						if err := auth.AuthReq(
-							cctx.String("url"), "/user/add",
-							cctx.String("admin-user"), cctx.String("admin-pwd"),
-							params); err != nil {
+						if err := adminClient(cctx).AddUser(username, passwd, nickName); err != nil {
 							return errors.As(err)
 						}
 						fmt.Println("add user success")
@@ -214,6 +562,87 @@ func init() {
 
 					},
 				},
+				&cli.Command{
+					Name:  "init-admin",
+					Usage: "create the first admin user in the local db, the daemon is not required",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "username",
+							Value: "admin",
+							Usage: "input the username",
+						},
+						&cli.StringFlag{
+							Name:  "passwd",
+							Value: "",
+							Usage: "input the password, prompt it when empty",
+						},
+						&cli.StringFlag{
+							Name:  "nickname",
+							Value: "admin",
+							Usage: "input the nickname",
+						},
+						&cli.BoolFlag{
+							Name:  "force",
+							Value: false,
+							Usage: "create or reset the admin even if an admin already exists",
+						},
+					},
+					Action: func(cctx *cli.Context) error {
+						ctx := cctx.Context
+						_ = ctx
+
+						repoDir := repo.ExpandPath(cctx.String("repo"))
+						username := cctx.String("username")
+						if len(username) == 0 {
+							return errors.New("need username")
+						}
+
+						auth.InitDB(filepath.Join(repoDir, "data", "mdoc.db"))
+						if err := auth.InitRealm(""); err != nil {
+							return errors.As(err)
+						}
+						count, err := auth.CountAdmin()
+						if err != nil {
+							return errors.As(err)
+						}
+						if count > 0 && !cctx.Bool("force") {
+							return errors.New("admin already exist, using --force to continue")
+						}
+
+						passwd := cctx.String("passwd")
+						if len(passwd) == 0 {
+							passwd, err = promptPasswd()
+							if err != nil {
+								return errors.As(err)
+							}
+						}
+						if _, err := auth.GetUser(username); err != nil {
+							if !errors.ErrNoData.Equal(err) {
+								return errors.As(err)
+							}
+							uInfo := &auth.UserInfo{
+								ID:       username,
+								NickName: cctx.String("nickname"),
+								Kind:     auth.USER_KIND_ADMIN,
+							}
+							if err := uInfo.SetPlainPasswd(auth.GetRealm(), passwd); err != nil {
+								return errors.As(err)
+							}
+							if err := auth.AddUser(uInfo); err != nil {
+								return errors.As(err)
+							}
+						} else {
+							if err := auth.SetPasswd(username, auth.GetRealm(), passwd); err != nil {
+								return errors.As(err)
+							}
+							if err := auth.UpdateUserKind(username, auth.USER_KIND_ADMIN); err != nil {
+								return errors.As(err)
+							}
+						}
+						fmt.Printf("init admin '%s' success\n", username)
+						return nil
+					},
+				},
 				&cli.Command{
 					Name:  "reset",
 					Usage: "reset the user's password",
@@ -235,21 +664,318 @@ func init() {
 
 						username := cctx.String("username")
 						passwd := cctx.String("passwd")
-						params := url.Values{
-							"username": {username},
-							"passwd":   {auth.HashPasswd(username, auth.REALM, passwd)},
+						if err := adminClient(cctx).ResetPasswd(username, passwd); err != nil {
+							return errors.As(err)
 						}
-						if err := auth.AuthReq(
-							cctx.String("url"), "/user/pwd/reset",
-							cctx.String("admin-user"), cctx.String("admin-pwd"),
-							params); err != nil {
+						fmt.Println("change password success")
+						return nil
+					},
+				},
+				&cli.Command{
+					Name:  "passwd",
+					Usage: "change the password of the user self, the admin flags are not used",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "username",
+							Value: "",
+							Usage: "input the username",
+						},
+						&cli.StringFlag{
+							Name:  "old-passwd",
+							Value: "",
+							Usage: "input the old password, prompt it when empty",
+						},
+						&cli.StringFlag{
+							Name:  "new-passwd",
+							Value: "",
+							Usage: "input the new password, prompt it when empty",
+						},
+						&cli.StringFlag{
+							Name:  "otp",
+							Value: "",
+							Usage: "input the two-factor code if it is enabled",
+						},
+					},
+					Action: func(cctx *cli.Context) error {
+						username := cctx.String("username")
+						if len(username) == 0 {
+							return errors.New("need username")
+						}
+						var err error
+						oldPasswd := cctx.String("old-passwd")
+						if len(oldPasswd) == 0 {
+							oldPasswd, err = readPasswd("Old password: ")
+							if err != nil {
+								return errors.As(err)
+							}
+						}
+						newPasswd := cctx.String("new-passwd")
+						if len(newPasswd) == 0 {
+							newPasswd, err = promptPasswd()
+							if err != nil {
+								return errors.As(err)
+							}
+						}
+
+						c := client.New(cctx.String("url"), username, oldPasswd)
+						c.SetOtp(cctx.String("otp"))
+						if err := c.ChangePasswd(newPasswd); err != nil {
 							return errors.As(err)
 						}
 						fmt.Println("change password success")
 						return nil
 					},
 				},
+				totpCommand(),
+				userImportCommand(),
+				userExportCommand(),
+				&cli.Command{
+					Name:  "list",
+					Usage: "list the users",
+					Action: func(cctx *cli.Context) error {
+						data, err := adminReq(cctx, "/user/list", url.Values{})
+						if err != nil {
+							return errors.As(err)
+						}
+						users := []auth.UserItem{}
+						if err := json.Unmarshal(data, &users); err != nil {
+							return errors.As(err)
+						}
+						w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+						fmt.Fprintln(w, "USERNAME\tNICKNAME\tKIND\tSTATUS\tCREATED\tUPDATED\tMEMO")
+						for _, u := range users {
+							kind := "common"
+							if u.Kind == auth.USER_KIND_ADMIN {
+								kind = "admin"
+							}
+							status := "enabled"
+							if u.Disabled {
+								status = "disabled"
+							}
+							if u.PasswdStale {
+								status += ",need reset"
+							}
+							fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+								u.ID, u.NickName, kind, status,
+								u.CreatedAt.Format("2006-01-02 15:04:05"), u.UpdatedAt.Format("2006-01-02 15:04:05"),
+								u.Memo,
+							)
+						}
+						return w.Flush()
+					},
+				},
+				&cli.Command{
+					Name:  "del",
+					Usage: "delete the user",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "username",
+							Value: "",
+							Usage: "input the username",
+						},
+					},
+					Action: func(cctx *cli.Context) error {
+						params := url.Values{
+							"username": {cctx.String("username")},
+						}
+						if _, err := adminReq(cctx, "/user/del", params); err != nil {
+							return errors.As(err)
+						}
+						fmt.Println("delete user success")
+						return nil
+					},
+				},
+				&cli.Command{
+					Name:  "disable",
+					Usage: "disable the user without deleting",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "username",
+							Value: "",
+							Usage: "input the username",
+						},
+					},
+					Action: func(cctx *cli.Context) error {
+						params := url.Values{
+							"username": {cctx.String("username")},
+						}
+						if _, err := adminReq(cctx, "/user/disable", params); err != nil {
+							return errors.As(err)
+						}
+						fmt.Println("disable user success")
+						return nil
+					},
+				},
+				&cli.Command{
+					Name:  "enable",
+					Usage: "enable the disabled user",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "username",
+							Value: "",
+							Usage: "input the username",
+						},
+					},
+					Action: func(cctx *cli.Context) error {
+						params := url.Values{
+							"username": {cctx.String("username")},
+						}
+						if _, err := adminReq(cctx, "/user/enable", params); err != nil {
+							return errors.As(err)
+						}
+						fmt.Println("enable user success")
+						return nil
+					},
+				},
+				&cli.Command{
+					Name:  "update",
+					Usage: "update the nickname or memo of user, only the set flags will be updated",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "username",
+							Value: "",
+							Usage: "input the username",
+						},
+						&cli.StringFlag{
+							Name:  "nickname",
+							Value: "",
+							Usage: "input the nickname",
+						},
+						&cli.StringFlag{
+							Name:  "memo",
+							Value: "",
+							Usage: "input the memo",
+						},
+					},
+					Action: func(cctx *cli.Context) error {
+						params := url.Values{
+							"username": {cctx.String("username")},
+						}
+						for _, key := range []string{"nickname", "memo"} {
+							if cctx.IsSet(key) {
+								params.Set(key, cctx.String(key))
+							}
+						}
+						if _, err := adminReq(cctx, "/user/info/update", params); err != nil {
+							return errors.As(err)
+						}
+						fmt.Println("update user success")
+						return nil
+					},
+				},
+				&cli.Command{
+					Name:  "promote",
+					Usage: "promote the user to admin",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "username",
+							Value: "",
+							Usage: "input the username",
+						},
+					},
+					Action: func(cctx *cli.Context) error {
+						params := url.Values{
+							"username": {cctx.String("username")},
+							"kind":     {strconv.Itoa(auth.USER_KIND_ADMIN)},
+						}
+						if _, err := adminReq(cctx, "/user/kind/update", params); err != nil {
+							return errors.As(err)
+						}
+						fmt.Println("promote user success")
+						return nil
+					},
+				},
+				&cli.Command{
+					Name:  "demote",
+					Usage: "demote the admin to common user",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "username",
+							Value: "",
+							Usage: "input the username",
+						},
+					},
+					Action: func(cctx *cli.Context) error {
+						params := url.Values{
+							"username": {cctx.String("username")},
+							"kind":     {strconv.Itoa(auth.USER_KIND_COMMON)},
+						}
+						if _, err := adminReq(cctx, "/user/kind/update", params); err != nil {
+							return errors.As(err)
+						}
+						fmt.Println("demote user success")
+						return nil
+					},
+				},
 			},
 		},
 	)
 }
+
+// flags of the admin commands which request the server.
+func adminFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "url",
+			Value: "http://127.0.0.1:8080",
+			Usage: "server url",
+		},
+		&cli.StringFlag{
+			Name:  "admin-user",
+			Value: "admin",
+			Usage: "input the admin user",
+		},
+		&cli.StringFlag{
+			Name:  "admin-pwd",
+			Value: "",
+			Usage: "input the admin's password",
+		},
+		&cli.StringFlag{
+			Name:  "admin-otp",
+			Value: "",
+			Usage: "input the admin's two-factor code if it is enabled",
+		},
+	}
+}
+
+// the client of server with the adminFlags.
+func adminClient(cctx *cli.Context) *client.Client {
+	c := client.New(cctx.String("url"), cctx.String("admin-user"), cctx.String("admin-pwd"))
+	c.SetOtp(cctx.String("admin-otp"))
+	return c
+}
+
+// request the admin api of server with the adminFlags.
+func adminReq(cctx *cli.Context, uri string, params url.Values) ([]byte, error) {
+	return adminClient(cctx).Post(uri, params)
+}
+
+// read the password from terminal without echo.
+func readPasswd(prompt string) (string, error) {
+	fmt.Print(prompt)
+	passwd, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", errors.As(err)
+	}
+	if len(passwd) == 0 {
+		return "", errors.New("need password")
+	}
+	return string(passwd), nil
+}
+
+// read the password from terminal without echo, and confirm it again.
+func promptPasswd() (string, error) {
+	passwd, err := readPasswd("Password: ")
+	if err != nil {
+		return "", errors.As(err)
+	}
+	confirm, err := readPasswd("Confirm password: ")
+	if err != nil {
+		return "", errors.As(err)
+	}
+	if passwd != confirm {
+		return "", errors.New("password not match")
+	}
+	return passwd, nil
+}
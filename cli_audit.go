@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// parse the local time of "2006-01-02 15:04:05" or "2006-01-02" to unix seconds, zero for empty.
+func parseLocalTime(str string) (int64, error) {
+	if len(str) == 0 {
+		return 0, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, str, time.Local); err == nil {
+			return t.Unix(), nil
+		}
+	}
+	return 0, errors.New("invalid time, need '2006-01-02 15:04:05' or '2006-01-02'").As(str)
+}
+
+// resgister audit tool
+func init() {
+	app.Register("audit",
+		&cli.Command{
+			Name:  "audit",
+			Usage: "query the audit records of the authentication and the user management",
+			Flags: append(adminFlags(),
+				&cli.StringFlag{
+					Name:  "username",
+					Value: "",
+					Usage: "filter by the operator or the target user",
+				},
+				&cli.StringFlag{
+					Name:  "action",
+					Value: "",
+					Usage: "filter by the action, e.g. 'login', 'user_add', 'pwd_reset'",
+				},
+				&cli.StringFlag{
+					Name:  "since",
+					Value: "",
+					Usage: "filter the records since the local time of '2006-01-02 15:04:05' or '2006-01-02'",
+				},
+				&cli.StringFlag{
+					Name:  "until",
+					Value: "",
+					Usage: "filter the records before the local time of '2006-01-02 15:04:05' or '2006-01-02'",
+				},
+				&cli.IntFlag{
+					Name:  "limit",
+					Value: 100,
+					Usage: "the max number of records, 0 for no limit",
+				},
+				&cli.IntFlag{
+					Name:  "offset",
+					Value: 0,
+					Usage: "skip the newest records",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "output the json",
+				},
+			),
+			Action: func(cctx *cli.Context) error {
+				since, err := parseLocalTime(cctx.String("since"))
+				if err != nil {
+					return errors.As(err)
+				}
+				until, err := parseLocalTime(cctx.String("until"))
+				if err != nil {
+					return errors.As(err)
+				}
+				params := url.Values{
+					"username": {cctx.String("username")},
+					"action":   {cctx.String("action")},
+					"limit":    {strconv.Itoa(cctx.Int("limit"))},
+					"offset":   {strconv.Itoa(cctx.Int("offset"))},
+				}
+				if since > 0 {
+					params.Set("since", strconv.FormatInt(since, 10))
+				}
+				if until > 0 {
+					params.Set("until", strconv.FormatInt(until, 10))
+				}
+				data, err := adminReq(cctx, "/auth/audit/list", params)
+				if err != nil {
+					return errors.As(err)
+				}
+				if cctx.Bool("json") {
+					fmt.Println(string(data))
+					return nil
+				}
+				audits := []auth.Audit{}
+				if err := json.Unmarshal(data, &audits); err != nil {
+					return errors.As(err)
+				}
+				w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "TIME\tUSER\tIP\tACTION\tRESULT\tTARGET\tMEMO")
+				for _, a := range audits {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+						fmtUnix(a.CreatedAt), a.UserID, a.Ip, a.Action, a.Result, a.Target, a.Memo,
+					)
+				}
+				return w.Flush()
+			},
+		},
+	)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"text/tabwriter"
+
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// resgister reads tool
+func init() {
+	app.Register("reads",
+		&cli.Command{
+			Name:  "reads",
+			Usage: "report the readers of a page or the pages read by a user",
+			Flags: append(adminFlags(),
+				&cli.StringFlag{
+					Name:  "username",
+					Value: "",
+					Usage: "report the pages read by the user",
+				},
+				&cli.StringFlag{
+					Name:  "path",
+					Value: "",
+					Usage: "report the readers of the page like '/markdown/README.md', or the pages in the directory ends with '/'",
+				},
+				&cli.StringFlag{
+					Name:  "since",
+					Value: "",
+					Usage: "the first day of '2006-01-02'",
+				},
+				&cli.StringFlag{
+					Name:  "until",
+					Value: "",
+					Usage: "the last day of '2006-01-02'",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "output the json",
+				},
+			),
+			Action: func(cctx *cli.Context) error {
+				data, err := adminReq(cctx, "/doc/read/list", url.Values{
+					"username": {cctx.String("username")},
+					"path":     {cctx.String("path")},
+					"since":    {cctx.String("since")},
+					"until":    {cctx.String("until")},
+				})
+				if err != nil {
+					return errors.As(err)
+				}
+				if cctx.Bool("json") {
+					fmt.Println(string(data))
+					return nil
+				}
+				reads := []auth.DocRead{}
+				if err := json.Unmarshal(data, &reads); err != nil {
+					return errors.As(err)
+				}
+				w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "DAY\tUSER\tPAGE\tTIMES\tFIRST\tLAST")
+				for _, r := range reads {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n",
+						r.Day, r.UserID, r.Path, r.Times, fmtUnix(r.FirstAt), fmtUnix(r.LastAt),
+					)
+				}
+				return w.Flush()
+			},
+		},
+	)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// resgister group tool
+func init() {
+	groupFlag := &cli.StringFlag{
+		Name:  "group",
+		Value: "",
+		Usage: "input the group name",
+	}
+	usernameFlag := &cli.StringFlag{
+		Name:  "username",
+		Value: "",
+		Usage: "input the username",
+	}
+	roleFlag := &cli.StringFlag{
+		Name:  "role",
+		Value: "viewer",
+		Usage: "role of the group members, 'viewer', 'editor' or 'admin'",
+	}
+
+	app.Register("group",
+		&cli.Command{
+			Name:  "group",
+			Usage: "manage the user groups, the group can be referenced as '@group' in the .authacl",
+			Flags: adminFlags(),
+			Subcommands: []*cli.Command{
+				&cli.Command{
+					Name:  "list",
+					Usage: "list the groups and members",
+					Action: func(cctx *cli.Context) error {
+						data, err := adminReq(cctx, "/group/list", url.Values{})
+						if err != nil {
+							return errors.As(err)
+						}
+						groups := []auth.GroupItem{}
+						if err := json.Unmarshal(data, &groups); err != nil {
+							return errors.As(err)
+						}
+						w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+						fmt.Fprintln(w, "GROUP\tROLE\tMEMBERS\tCREATED\tMEMO")
+						for _, g := range groups {
+							fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+								g.ID, auth.RoleName(g.Role), strings.Join(g.Members, ","),
+								g.CreatedAt.Format("2006-01-02 15:04:05"), g.Memo,
+							)
+						}
+						return w.Flush()
+					},
+				},
+				&cli.Command{
+					Name:  "add",
+					Usage: "add a new group",
+					Flags: []cli.Flag{
+						groupFlag,
+						roleFlag,
+						&cli.StringFlag{
+							Name:  "memo",
+							Value: "",
+							Usage: "input the memo",
+						},
+					},
+					Action: func(cctx *cli.Context) error {
+						params := url.Values{
+							"group": {cctx.String("group")},
+							"role":  {cctx.String("role")},
+							"memo":  {cctx.String("memo")},
+						}
+						if _, err := adminReq(cctx, "/group/add", params); err != nil {
+							return errors.As(err)
+						}
+						fmt.Println("add group success")
+						return nil
+					},
+				},
+				&cli.Command{
+					Name:  "del",
+					Usage: "delete the group",
+					Flags: []cli.Flag{
+						groupFlag,
+					},
+					Action: func(cctx *cli.Context) error {
+						params := url.Values{
+							"group": {cctx.String("group")},
+						}
+						if _, err := adminReq(cctx, "/group/del", params); err != nil {
+							return errors.As(err)
+						}
+						fmt.Println("delete group success")
+						return nil
+					},
+				},
+				&cli.Command{
+					Name:  "role",
+					Usage: "change the role of the group",
+					Flags: []cli.Flag{
+						groupFlag,
+						roleFlag,
+					},
+					Action: func(cctx *cli.Context) error {
+						params := url.Values{
+							"group": {cctx.String("group")},
+							"role":  {cctx.String("role")},
+						}
+						if _, err := adminReq(cctx, "/group/role/update", params); err != nil {
+							return errors.As(err)
+						}
+						fmt.Println("change role success")
+						return nil
+					},
+				},
+				&cli.Command{
+					Name:  "join",
+					Usage: "add the user to the group",
+					Flags: []cli.Flag{
+						groupFlag,
+						usernameFlag,
+					},
+					Action: func(cctx *cli.Context) error {
+						params := url.Values{
+							"group":    {cctx.String("group")},
+							"username": {cctx.String("username")},
+						}
+						if _, err := adminReq(cctx, "/group/member/add", params); err != nil {
+							return errors.As(err)
+						}
+						fmt.Println("add member success")
+						return nil
+					},
+				},
+				&cli.Command{
+					Name:  "leave",
+					Usage: "remove the user from the group",
+					Flags: []cli.Flag{
+						groupFlag,
+						usernameFlag,
+					},
+					Action: func(cctx *cli.Context) error {
+						params := url.Values{
+							"group":    {cctx.String("group")},
+							"username": {cctx.String("username")},
+						}
+						if _, err := adminReq(cctx, "/group/member/del", params); err != nil {
+							return errors.As(err)
+						}
+						fmt.Println("remove member success")
+						return nil
+					},
+				},
+			},
+		},
+	)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gwaycc/mdoc/route"
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// resgister invite tool
+func init() {
+	app.Register("invite",
+		&cli.Command{
+			Name:  "invite",
+			Usage: "manage the single use links for the new users to set their own passwords",
+			Flags: adminFlags(),
+			Subcommands: []*cli.Command{
+				&cli.Command{
+					Name:  "create",
+					Usage: "create an invite link bound to the username or the email",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "username",
+							Value: "",
+							Usage: "input the username of the new user, empty for choosing by the invitee",
+						},
+						&cli.StringFlag{
+							Name:  "email",
+							Value: "",
+							Usage: "input the email of the invitee",
+						},
+						&cli.DurationFlag{
+							Name:  "expires",
+							Value: route.INVITE_DEFAULT_EXPIRES,
+							Usage: "expires of the link",
+						},
+					},
+					Action: func(cctx *cli.Context) error {
+						resp, err := adminClient(cctx).CreateInvite(cctx.String("username"), cctx.String("email"), cctx.Duration("expires"))
+						if err != nil {
+							return errors.As(err)
+						}
+						fmt.Printf("id: %s\n", resp.Info.ID)
+						fmt.Printf("expires: %s\n", fmtUnix(resp.Info.ExpiredAt))
+						link := resp.URL
+						if len(link) == 0 {
+							link = route.InviteURL(cctx.String("url"), resp.Token)
+						}
+						fmt.Printf("link: %s\n", link)
+						fmt.Println("the link can not be shown again, please send it to the invitee")
+						return nil
+					},
+				},
+				&cli.Command{
+					Name:  "list",
+					Usage: "list the pending invites",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "all",
+							Usage: "list the used, revoked and expired invites too",
+						},
+					},
+					Action: func(cctx *cli.Context) error {
+						invites, err := adminClient(cctx).ListInvites(cctx.Bool("all"))
+						if err != nil {
+							return errors.As(err)
+						}
+						now := time.Now()
+						w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+						fmt.Fprintln(w, "ID\tUSER\tEMAIL\tCREATED BY\tEXPIRES\tSTATUS")
+						for _, item := range invites {
+							iv := auth.UserInvite(item)
+							fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+								iv.ID, iv.UserID, iv.Email, iv.CreatedBy, fmtUnix(iv.ExpiredAt), iv.Status(now),
+							)
+						}
+						return w.Flush()
+					},
+				},
+				&cli.Command{
+					Name:  "revoke",
+					Usage: "revoke the unused invite",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "id",
+							Value: "",
+							Usage: "input the id of invite",
+						},
+					},
+					Action: func(cctx *cli.Context) error {
+						if err := adminClient(cctx).RevokeInvite(cctx.String("id")); err != nil {
+							return errors.As(err)
+						}
+						fmt.Println("revoke success")
+						return nil
+					},
+				},
+			},
+		},
+	)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"text/tabwriter"
+
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// resgister lockout tool
+func init() {
+	app.Register("lockout",
+		&cli.Command{
+			Name:  "lockout",
+			Usage: "manage the users and ips locked by the login failures",
+			Flags: adminFlags(),
+			Subcommands: []*cli.Command{
+				&cli.Command{
+					Name:  "list",
+					Usage: "list the locked users and ips",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "all",
+							Usage: "list the failures not locked yet too",
+						},
+					},
+					Action: func(cctx *cli.Context) error {
+						params := url.Values{}
+						if cctx.Bool("all") {
+							params.Set("all", "1")
+						}
+						data, err := adminReq(cctx, "/auth/limit/list", params)
+						if err != nil {
+							return errors.As(err)
+						}
+						limits := []auth.AuthLimit{}
+						if err := json.Unmarshal(data, &limits); err != nil {
+							return errors.As(err)
+						}
+						w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+						fmt.Fprintln(w, "USER\tIP\tFAILURES\tLAST FAILED\tEXPIRES")
+						for _, l := range limits {
+							fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n",
+								l.UserID, l.Ip, l.Times, fmtUnix(l.UpdatedAt), fmtUnix(l.ExpiredAt),
+							)
+						}
+						return w.Flush()
+					},
+				},
+				&cli.Command{
+					Name:  "unlock",
+					Usage: "clean the login failures of the user or ip, both are matched when they are set",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "username",
+							Value: "",
+							Usage: "input the username",
+						},
+						&cli.StringFlag{
+							Name:  "ip",
+							Value: "",
+							Usage: "input the ip",
+						},
+					},
+					Action: func(cctx *cli.Context) error {
+						if _, err := adminReq(cctx, "/auth/limit/unlock", url.Values{
+							"username": {cctx.String("username")},
+							"ip":       {cctx.String("ip")},
+						}); err != nil {
+							return errors.As(err)
+						}
+						fmt.Println("unlock success")
+						return nil
+					},
+				},
+			},
+		},
+	)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gwaycc/mdoc/route"
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/urfave/cli/v2"
+)
+
+func fmtUnix(sec int64) string {
+	if sec == 0 {
+		return "-"
+	}
+	return time.Unix(sec, 0).Format("2006-01-02 15:04:05")
+}
+
+// resgister token tool
+func init() {
+	app.Register("token",
+		&cli.Command{
+			Name:  "token",
+			Usage: "manage the personal api tokens, use it with the header 'Authorization: Bearer <token>'",
+			Flags: append(selfFlags(),
+				&cli.StringFlag{
+					Name:  "url",
+					Value: "http://127.0.0.1:8080",
+					Usage: "server url",
+				},
+			),
+			Subcommands: []*cli.Command{
+				&cli.Command{
+					Name:  "create",
+					Usage: "create a new token, the token only show once",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "name",
+							Value: "",
+							Usage: "input the token name",
+						},
+						&cli.StringFlag{
+							Name:  "scope",
+							Value: auth.TOKEN_SCOPE_READ,
+							Usage: "comma separated scopes, 'read' for reading documents, 'write' for writing documents, 'admin' for managing users",
+						},
+						&cli.DurationFlag{
+							Name:  "expires",
+							Value: 30 * 24 * time.Hour,
+							Usage: "the token expires after the duration, 0 for never expired",
+						},
+					},
+					Action: func(cctx *cli.Context) error {
+						data, err := selfReq(cctx, "/user/token/create", url.Values{
+							"name":    {cctx.String("name")},
+							"scope":   {cctx.String("scope")},
+							"expires": {cctx.Duration("expires").String()},
+						})
+						if err != nil {
+							return errors.As(err)
+						}
+						resp := &route.TokenCreateResp{}
+						if err := json.Unmarshal(data, resp); err != nil {
+							return errors.As(err)
+						}
+						fmt.Printf("id: %s\nexpires: %s\ntoken: %s\n", resp.Info.ID, fmtUnix(resp.Info.ExpiredAt), resp.Token)
+						fmt.Println("keep the token in a safe place, it can not be shown again")
+						return nil
+					},
+				},
+				&cli.Command{
+					Name:  "list",
+					Usage: "list the tokens of the user",
+					Action: func(cctx *cli.Context) error {
+						data, err := selfReq(cctx, "/user/token/list", url.Values{})
+						if err != nil {
+							return errors.As(err)
+						}
+						tokens := []auth.UserToken{}
+						if err := json.Unmarshal(data, &tokens); err != nil {
+							return errors.As(err)
+						}
+						w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+						fmt.Fprintln(w, "ID\tNAME\tSCOPE\tCREATED\tEXPIRES\tLAST USED\tREVOKED")
+						for _, t := range tokens {
+							fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%t\n",
+								t.ID, t.Name, t.Scope,
+								fmtUnix(t.CreatedAt), fmtUnix(t.ExpiredAt), fmtUnix(t.LastUsedAt), t.Revoked,
+							)
+						}
+						return w.Flush()
+					},
+				},
+				&cli.Command{
+					Name:  "revoke",
+					Usage: "revoke the token",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "id",
+							Value: "",
+							Usage: "input the token id",
+						},
+					},
+					Action: func(cctx *cli.Context) error {
+						if _, err := selfReq(cctx, "/user/token/revoke", url.Values{
+							"id": {cctx.String("id")},
+						}); err != nil {
+							return errors.As(err)
+						}
+						fmt.Println("revoke token success")
+						return nil
+					},
+				},
+			},
+		},
+	)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gwaycc/mdoc/client"
+	"github.com/gwaycc/mdoc/route"
+
+	"github.com/gwaylib/errors"
+	"github.com/urfave/cli/v2"
+	"rsc.io/qr"
+)
+
+// print the qr code to terminal with the unicode blocks, two rows a line.
+func printQR(text string) error {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return errors.As(err)
+	}
+	const quiet = 2
+	b := strings.Builder{}
+	for y := -quiet; y < code.Size+quiet; y += 2 {
+		for x := -quiet; x < code.Size+quiet; x++ {
+			top, bottom := code.Black(x, y), code.Black(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString(" ")
+			case top:
+				b.WriteString("▄")
+			case bottom:
+				b.WriteString("▀")
+			default:
+				b.WriteString("█")
+			}
+		}
+		b.WriteString("\n")
+	}
+	fmt.Print(b.String())
+	return nil
+}
+
+// the login flags of the user self.
+func selfFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "username",
+			Value: "",
+			Usage: "input the username",
+		},
+		&cli.StringFlag{
+			Name:  "passwd",
+			Value: "",
+			Usage: "input the password, prompt it when empty",
+		},
+		&cli.StringFlag{
+			Name:  "otp",
+			Value: "",
+			Usage: "input the two-factor code if it is enabled",
+		},
+	}
+}
+
+// the user self need login with the password to request the server.
+func selfReq(cctx *cli.Context, uri string, params url.Values) ([]byte, error) {
+	username := cctx.String("username")
+	if len(username) == 0 {
+		return nil, errors.New("need username")
+	}
+	passwd := cctx.String("passwd")
+	if len(passwd) == 0 {
+		var err error
+		passwd, err = readPasswd("Password: ")
+		if err != nil {
+			return nil, errors.As(err)
+		}
+	}
+	c := client.New(cctx.String("url"), username, passwd)
+	c.SetOtp(cctx.String("otp"))
+	return c.Post(uri, params)
+}
+
+// the two-factor tool of 'user' command
+func totpCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "totp",
+		Usage: "manage the two-factor authentication(TOTP)",
+		Subcommands: []*cli.Command{
+			&cli.Command{
+				Name:  "enroll",
+				Usage: "make a new two-factor secret for the user self, confirm it with the code of authenticator app",
+				Flags: selfFlags(),
+				Action: func(cctx *cli.Context) error {
+					data, err := selfReq(cctx, "/user/totp/enroll", url.Values{})
+					if err != nil {
+						return errors.As(err)
+					}
+					resp := &route.TotpEnrollResp{}
+					if err := json.Unmarshal(data, resp); err != nil {
+						return errors.As(err)
+					}
+					if err := printQR(resp.URI); err != nil {
+						return errors.As(err)
+					}
+					fmt.Printf("secret: %s\nuri: %s\n", resp.Secret, resp.URI)
+					fmt.Println("scan the qr code with the authenticator app, then run 'totp confirm --code=<code>'")
+					return nil
+				},
+			},
+			&cli.Command{
+				Name:  "confirm",
+				Usage: "enable the two-factor of the user self with the code of authenticator app",
+				Flags: append(selfFlags(),
+					&cli.StringFlag{
+						Name:  "code",
+						Value: "",
+						Usage: "input the code of authenticator app",
+					},
+				),
+				Action: func(cctx *cli.Context) error {
+					data, err := selfReq(cctx, "/user/totp/confirm", url.Values{
+						"code": {cctx.String("code")},
+					})
+					if err != nil {
+						return errors.As(err)
+					}
+					resp := &route.TotpConfirmResp{}
+					if err := json.Unmarshal(data, resp); err != nil {
+						return errors.As(err)
+					}
+					fmt.Println("two-factor enabled, keep the recovery codes in a safe place, each code can be used once:")
+					for _, code := range resp.RecoveryCodes {
+						fmt.Println(code)
+					}
+					return nil
+				},
+			},
+			&cli.Command{
+				Name:  "reset",
+				Usage: "remove the two-factor of the user by admin",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "username",
+						Value: "",
+						Usage: "input the username",
+					},
+				},
+				Action: func(cctx *cli.Context) error {
+					params := url.Values{
+						"username": {cctx.String("username")},
+					}
+					if _, err := adminReq(cctx, "/user/totp/reset", params); err != nil {
+						return errors.As(err)
+					}
+					fmt.Println("reset two-factor success")
+					return nil
+				},
+			},
+		},
+	}
+}
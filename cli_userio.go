@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gwaycc/mdoc/client"
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// import a user with the admin client, the memo, kind and disabled are set after the user added.
+func importUser(c *client.Client, u *auth.ImportUser) error {
+	if len(u.PlainPasswd) > 0 {
+		if err := c.AddUser(u.ID, u.PlainPasswd, u.NickName); err != nil {
+			return errors.As(err)
+		}
+	} else if err := c.AddUserHA1(u.ID, u.Passwd, u.Realm, u.NickName); err != nil {
+		// the server rejects the HA1 of other realms.
+		return errors.As(err)
+	}
+	if len(u.Memo) > 0 {
+		if err := c.UpdateUserInfo(u.ID, nil, &u.Memo); err != nil {
+			return errors.As(err)
+		}
+	}
+	if u.Kind == auth.USER_KIND_ADMIN {
+		if err := c.UpdateUserKind(u.ID, u.Kind); err != nil {
+			return errors.As(err)
+		}
+	}
+	if u.Disabled {
+		if err := c.DisableUser(u.ID); err != nil {
+			return errors.As(err)
+		}
+	}
+	return nil
+}
+
+func userImportCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "import",
+		Usage: "import the users of the htdigest or csv file, the exist users are skipped",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "file",
+				Value: "",
+				Usage: "input the file path",
+			},
+			&cli.StringFlag{
+				Name:  "format",
+				Value: "",
+				Usage: "'htdigest' or 'csv', default is 'csv' for the .csv file, others are 'htdigest'",
+			},
+			&cli.StringFlag{
+				Name:  "realm",
+				Value: "",
+				Usage: "only import the lines of the realm in htdigest file, the HA1 not in the realm of server is rejected by the server",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Value: false,
+				Usage: "only check the file and list the users to import",
+			},
+		},
+		Action: func(cctx *cli.Context) error {
+			fileName := cctx.String("file")
+			if len(fileName) == 0 {
+				return errors.New("need file")
+			}
+			format := cctx.String("format")
+			if len(format) == 0 {
+				format = "htdigest"
+				if strings.ToLower(filepath.Ext(fileName)) == ".csv" {
+					format = "csv"
+				}
+			}
+			file, err := os.Open(fileName)
+			if err != nil {
+				return errors.As(err)
+			}
+			defer file.Close()
+
+			var users []auth.ImportUser
+			switch format {
+			case "htdigest":
+				users, err = auth.ParseHtdigest(file, cctx.String("realm"))
+			case "csv":
+				users, err = auth.ParseUserCSV(file)
+			default:
+				return errors.New("unknow format").As(format)
+			}
+			if err != nil {
+				return errors.As(err, fileName)
+			}
+
+			c := adminClient(cctx)
+			exists, err := c.ListUsers()
+			if err != nil {
+				return errors.As(err)
+			}
+			existNames := map[string]bool{}
+			for _, u := range exists {
+				existNames[u.ID] = true
+			}
+
+			added, skipped, failed := 0, 0, 0
+			for i := range users {
+				u := &users[i]
+				switch {
+				case existNames[u.ID]:
+					skipped++
+					fmt.Printf("skip %s: already exist\n", u.ID)
+				case cctx.Bool("dry-run"):
+					added++
+					fmt.Printf("add %s\n", u.ID)
+				default:
+					if err := importUser(c, u); err != nil {
+						failed++
+						fmt.Printf("fail %s: %s\n", u.ID, err.Error())
+						continue
+					}
+					added++
+					fmt.Printf("add %s\n", u.ID)
+				}
+			}
+			fmt.Printf("import done, added: %d, skipped: %d, failed: %d\n", added, skipped, failed)
+			if failed > 0 {
+				return errors.New("import failed").As(failed)
+			}
+			return nil
+		},
+	}
+}
+
+func userExportCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "export",
+		Usage: "export the users to csv, json or htdigest, the passwords are not exported by default",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "format",
+				Value: "csv",
+				Usage: "'csv', 'json' or 'htdigest', the htdigest needs --secrets",
+			},
+			&cli.BoolFlag{
+				Name:  "secrets",
+				Value: false,
+				Usage: "export the HA1 of digest, the users without HA1 are not exported to htdigest",
+			},
+			&cli.StringFlag{
+				Name:  "output",
+				Value: "",
+				Usage: "output file path, default is the stdout",
+			},
+		},
+		Action: func(cctx *cli.Context) error {
+			format := cctx.String("format")
+			secrets := cctx.Bool("secrets")
+			switch format {
+			case "csv", "json":
+			case "htdigest":
+				if !secrets {
+					return errors.New("htdigest needs --secrets")
+				}
+			default:
+				return errors.New("unknow format").As(format)
+			}
+
+			users, err := adminClient(cctx).ExportUsers(secrets)
+			if err != nil {
+				return errors.As(err)
+			}
+
+			var out io.Writer = os.Stdout
+			if output := cctx.String("output"); len(output) > 0 {
+				// the secrets are only readable by the owner.
+				file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+				if err != nil {
+					return errors.As(err)
+				}
+				defer file.Close()
+				out = file
+			}
+			exports := make([]auth.UserExport, len(users))
+			for i, u := range users {
+				exports[i] = auth.UserExport(u)
+			}
+			switch format {
+			case "csv":
+				return auth.WriteUserCSV(out, exports, secrets)
+			case "json":
+				encoder := json.NewEncoder(out)
+				encoder.SetIndent("", "  ")
+				return errors.As(encoder.Encode(users))
+			default:
+				skipped, err := auth.WriteHtdigest(out, exports, auth.REALM)
+				if err != nil {
+					return errors.As(err)
+				}
+				if len(skipped) > 0 {
+					fmt.Fprintf(os.Stderr, "skip the users without HA1: %s\n", strings.Join(skipped, ","))
+				}
+				return nil
+			}
+		},
+	}
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/gwaylib/errors"
+)
+
+// the admin api of the groups, lockouts and audits
+
+func (c *Client) ListGroups() ([]GroupItem, error) {
+	groups := []GroupItem{}
+	if err := c.DoJSON("POST", "/group/list", url.Values{}, &groups); err != nil {
+		return nil, errors.As(err)
+	}
+	return groups, nil
+}
+
+// AddGroup adds the group with the role name, "viewer", "editor" or "admin".
+func (c *Client) AddGroup(group, role, memo string) error {
+	if _, err := c.Post("/group/add", url.Values{
+		"group": {group},
+		"role":  {role},
+		"memo":  {memo},
+	}); err != nil {
+		return errors.As(err, group)
+	}
+	return nil
+}
+
+func (c *Client) DelGroup(group string) error {
+	if _, err := c.Post("/group/del", url.Values{"group": {group}}); err != nil {
+		return errors.As(err, group)
+	}
+	return nil
+}
+
+// UpdateGroupRole sets the role name of group, "viewer", "editor" or "admin".
+func (c *Client) UpdateGroupRole(group, role string) error {
+	if _, err := c.Post("/group/role/update", url.Values{
+		"group": {group},
+		"role":  {role},
+	}); err != nil {
+		return errors.As(err, group, role)
+	}
+	return nil
+}
+
+func (c *Client) AddGroupMember(group, username string) error {
+	if _, err := c.Post("/group/member/add", url.Values{
+		"group":    {group},
+		"username": {username},
+	}); err != nil {
+		return errors.As(err, group, username)
+	}
+	return nil
+}
+
+func (c *Client) DelGroupMember(group, username string) error {
+	if _, err := c.Post("/group/member/del", url.Values{
+		"group":    {group},
+		"username": {username},
+	}); err != nil {
+		return errors.As(err, group, username)
+	}
+	return nil
+}
+
+// ListAuthLimits returns the locked users and ips, the failures not locked yet are included when all is true.
+func (c *Client) ListAuthLimits(all bool) ([]AuthLimit, error) {
+	params := url.Values{}
+	if all {
+		params.Set("all", "1")
+	}
+	limits := []AuthLimit{}
+	if err := c.DoJSON("POST", "/auth/limit/list", params, &limits); err != nil {
+		return nil, errors.As(err)
+	}
+	return limits, nil
+}
+
+// UnlockAuth cleans the login failures of the user or ip, the empty one is not limited.
+func (c *Client) UnlockAuth(username, ip string) error {
+	if _, err := c.Post("/auth/limit/unlock", url.Values{
+		"username": {username},
+		"ip":       {ip},
+	}); err != nil {
+		return errors.As(err, username, ip)
+	}
+	return nil
+}
+
+// ListAudits returns the audit records of the query, the newest is the first.
+func (c *Client) ListAudits(q *AuditQuery) ([]Audit, error) {
+	params := url.Values{
+		"username": {q.UserID},
+		"action":   {q.Action},
+		"limit":    {strconv.Itoa(q.Limit)},
+		"offset":   {strconv.Itoa(q.Offset)},
+	}
+	if q.Since > 0 {
+		params.Set("since", strconv.FormatInt(q.Since, 10))
+	}
+	if q.Until > 0 {
+		params.Set("until", strconv.FormatInt(q.Until, 10))
+	}
+	audits := []Audit{}
+	if err := c.DoJSON("POST", "/auth/audit/list", params, &audits); err != nil {
+		return nil, errors.As(err)
+	}
+	return audits, nil
+}
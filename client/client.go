@@ -0,0 +1,263 @@
+// Package client is the Go client of the mdoc server.
+//
+// The Client logins with the digest authentication, and reuses the challenge for the following requests,
+// the login form is used when the digest is disabled by the server, and the api token is used when it is set.
+//
+//	c := client.New("http://localhost:8080", "admin", "<passwd>")
+//	users, err := c.ListUsers()
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gwaylib/errors"
+)
+
+const (
+	_FORM_CONTENT_TYPE = "application/x-www-form-urlencoded"
+	_JSON_CONTENT_TYPE = "application/json"
+)
+
+// Client requests the mdoc server with the authentication of a user, it is safe for the concurrent use,
+// but the requests are sent one by one to keep the nonce count increasing.
+type Client struct {
+	server   string
+	username string
+	passwd   string
+	token    string
+	otp      string
+
+	httpClient *http.Client
+	challenge  *DigestChallenge // the cached challenge of digest
+	session    bool             // login with the form, the cookie is kept by the httpClient
+	mutex      sync.Mutex
+}
+
+// New returns a client with the password of user, the server is the url like "http://localhost:8080".
+func New(server, username, passwd string) *Client {
+	jar, _ := cookiejar.New(nil) // never returns error without options
+	return &Client{
+		server:   strings.TrimRight(server, "/"),
+		username: username,
+		passwd:   passwd,
+		httpClient: &http.Client{
+			Jar: jar,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// NewWithToken returns a client with the api token, see "mdoc token create".
+func NewWithToken(server, token string) *Client {
+	c := New(server, "", "")
+	c.token = token
+	return c
+}
+
+// SetOtp sets the two-factor code of the user, it is sent by each request until changed.
+func (c *Client) SetOtp(otp string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.otp = otp
+}
+
+// Username returns the user of the client.
+func (c *Client) Username() string {
+	return c.username
+}
+
+// Get requests the uri with the params in query.
+func (c *Client) Get(uri string, params url.Values) ([]byte, error) {
+	return c.Do("GET", uri, params)
+}
+
+// Post requests the uri with the params in form.
+func (c *Client) Post(uri string, params url.Values) ([]byte, error) {
+	return c.Do("POST", uri, params)
+}
+
+// Put requests the uri with the params in form.
+func (c *Client) Put(uri string, params url.Values) ([]byte, error) {
+	return c.Do("PUT", uri, params)
+}
+
+// Delete requests the uri with the params in query.
+func (c *Client) Delete(uri string, params url.Values) ([]byte, error) {
+	return c.Do("DELETE", uri, params)
+}
+
+// Do requests the uri and returns the body when the status is 2xx, the params are sent in form
+// for POST and PUT, and in query for others.
+//
+// If the status is not 2xx, the error code is the status, and the body is in the error.
+func (c *Client) Do(method, uri string, params url.Values) ([]byte, error) {
+	if method == "POST" || method == "PUT" {
+		return c.DoBody(method, uri, _FORM_CONTENT_TYPE, []byte(params.Encode()))
+	}
+	if len(params) > 0 {
+		sep := "?"
+		if strings.Contains(uri, "?") {
+			sep = "&"
+		}
+		uri += sep + params.Encode()
+	}
+	return c.DoBody(method, uri, "", nil)
+}
+
+// DoJSON is same as Do, and decodes the body to the result.
+func (c *Client) DoJSON(method, uri string, params url.Values, result interface{}) error {
+	data, err := c.Do(method, uri, params)
+	if err != nil {
+		return errors.As(err)
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return errors.As(err, uri)
+	}
+	return nil
+}
+
+// SendJSON sends the value in json, and decodes the body to the result if it is not nil.
+func (c *Client) SendJSON(method, uri string, value, result interface{}) error {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return errors.As(err)
+	}
+	data, err := c.DoBody(method, uri, _JSON_CONTENT_TYPE, body)
+	if err != nil {
+		return errors.As(err)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return errors.As(err, uri)
+	}
+	return nil
+}
+
+// DoBody requests the uri with the body, and returns the body of response when the status is 2xx.
+//
+// The request is sent again when the server offered a new challenge for the stale nonce or the algorithms of user,
+// or the login form is needed.
+func (c *Client) DoBody(method, uri, contentType string, body []byte) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for retry := 0; ; retry++ {
+		used, resp, err := c.send(method, uri, contentType, body)
+		if err != nil {
+			return nil, errors.As(err, method, uri)
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			if err != nil {
+				return nil, errors.As(err, method, uri)
+			}
+			return data, nil
+		}
+		// the body of failure is only for the message, the gzip body of 401 may be truncated by the server.
+		if retry < 2 {
+			again, err := c.renew(resp, used)
+			if err != nil {
+				return nil, errors.As(err, method, uri)
+			}
+			if again {
+				continue
+			}
+		}
+		return nil, errors.New(fmt.Sprintf("%d", resp.StatusCode)).As(string(data), method, uri)
+	}
+}
+
+// send the request with the cached authorization, and return the challenge used by the request.
+func (c *Client) send(method, uri, contentType string, body []byte) (*DigestChallenge, *http.Response, error) {
+	req, err := http.NewRequest(method, c.server+uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, errors.As(err)
+	}
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if len(c.otp) > 0 {
+		req.Header.Set(OTP_HEADER, c.otp)
+	}
+	var used *DigestChallenge
+	switch {
+	case len(c.token) > 0:
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.session:
+		// the cookie is sent by the jar
+	case c.challenge != nil:
+		authorization, err := c.challenge.Authorization(method, uri, c.username, c.passwd)
+		if err != nil {
+			return nil, nil, errors.As(err)
+		}
+		req.Header.Set("Authorization", authorization)
+		used = c.challenge
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, errors.As(err)
+	}
+	return used, resp, nil
+}
+
+// renew the authorization by the failed response, true will be returned if the request should be sent again.
+func (c *Client) renew(resp *http.Response, used *DigestChallenge) (bool, error) {
+	if len(c.token) > 0 {
+		return false, nil
+	}
+	switch resp.StatusCode {
+	case 401:
+		next := ParseDigestChallenge(resp.Header)
+		if next == nil {
+			// the digest is disabled by the server, or the session is expired.
+			return true, c.login()
+		}
+		// a new challenge of the same algorithm is offered for the wrong password.
+		if used != nil && !next.Stale && next.Algorithm == used.Algorithm {
+			return false, nil
+		}
+		c.challenge = next
+		c.session = false
+		return true, nil
+	case 302, 303:
+		// the page needs the login form in the session mode.
+		location, err := resp.Location()
+		if err != nil || location.Path != "/login" {
+			return false, nil
+		}
+		return true, c.login()
+	}
+	return false, nil
+}
+
+// login with the form of the session mode, the session cookie is kept by the jar.
+func (c *Client) login() error {
+	resp, err := c.httpClient.PostForm(c.server+"/login", url.Values{
+		"username": {c.username},
+		"passwd":   {c.passwd},
+		"code":     {c.otp},
+	})
+	if err != nil {
+		return errors.As(err)
+	}
+	defer resp.Body.Close()
+	// the login page is rendered again with the status of failure.
+	if resp.StatusCode != 302 {
+		return errors.New(fmt.Sprintf("%d", resp.StatusCode)).As("login failed", c.username)
+	}
+	c.session = true
+	return nil
+}
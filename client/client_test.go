@@ -0,0 +1,293 @@
+package client_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gwaycc/mdoc/client"
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	httpauth "github.com/abbot/go-http-auth"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "mdoc-client-test")
+	if err != nil {
+		panic(err)
+	}
+	auth.InitDB(filepath.Join(dir, "mdoc.db"))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// rotateNonceStore keeps the opaque and drops the nonces and the secret when rotated,
+// so the nonces in use become unknown to the server like a restart without the persistent store.
+type rotateNonceStore struct {
+	inner  auth.NonceStore
+	opaque string
+	mutex  sync.Mutex
+}
+
+func newRotateNonceStore() *rotateNonceStore {
+	store := auth.NewMemNonceStore()
+	opaque, _ := store.Opaque()
+	return &rotateNonceStore{inner: store, opaque: opaque}
+}
+
+func (r *rotateNonceStore) Opaque() (string, error) {
+	return r.opaque, nil
+}
+
+func (r *rotateNonceStore) rotate() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.inner = auth.NewMemNonceStore()
+}
+
+func (r *rotateNonceStore) store() auth.NonceStore {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.inner
+}
+
+func (r *rotateNonceStore) Secret() (string, error) {
+	return r.store().Secret()
+}
+
+func (r *rotateNonceStore) PutNonce(nonce string, nc uint64, lastSeen int64) error {
+	return r.store().PutNonce(nonce, nc, lastSeen)
+}
+
+func (r *rotateNonceStore) GetNonce(nonce string) (uint64, error) {
+	return r.store().GetNonce(nonce)
+}
+
+func (r *rotateNonceStore) Purge(before int64) error {
+	return r.store().Purge(before)
+}
+
+// digestServer records the challenges and the algorithms used by the authorized requests.
+type digestServer struct {
+	*httptest.Server
+	da *auth.DigestAuth
+
+	mutex      sync.Mutex
+	challenges []http.Header
+	algorithms []string
+}
+
+func newDigestServer(t *testing.T, secret func(user, realm, algorithm string) string, store auth.NonceStore) *digestServer {
+	t.Helper()
+	s := &digestServer{da: auth.NewDigestAuth(auth.REALM, false, nil, store)}
+	s.da.AlgSecrets = secret
+	if err := s.da.SetAlgorithms(auth.DigestAlgorithms()...); err != nil {
+		t.Fatal(err)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, err := s.da.CheckAuth(r)
+		switch {
+		case auth.ErrNeedLogin.Equal(err), auth.ErrNeedPwd.Equal(err):
+			rec := httptest.NewRecorder()
+			s.da.RequireAuth(rec, r)
+			s.mutex.Lock()
+			s.challenges = append(s.challenges, rec.Header())
+			s.mutex.Unlock()
+			for key, values := range rec.Header() {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(rec.Code)
+			w.Write(rec.Body.Bytes())
+			return
+		case err != nil:
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		s.mutex.Lock()
+		s.algorithms = append(s.algorithms, httpauth.DigestAuthParams(r.Header.Get("Authorization"))["algorithm"])
+		s.mutex.Unlock()
+		w.Write([]byte(name))
+	}))
+	return s
+}
+
+// return the challenges and the algorithms recorded since the last call.
+func (s *digestServer) reset() ([]http.Header, []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	challenges, algorithms := s.challenges, s.algorithms
+	s.challenges, s.algorithms = nil, nil
+	return challenges, algorithms
+}
+
+func passwdSecret(passwd string) func(user, realm, algorithm string) string {
+	return func(user, realm, algorithm string) string {
+		return auth.HashPasswdAlg(algorithm, user, realm, passwd)
+	}
+}
+
+func expectUser(t *testing.T, c *client.Client, username string) {
+	t.Helper()
+	data, err := c.Get("/user/list", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != username {
+		t.Fatalf("expect %s, but: %s", username, string(data))
+	}
+}
+
+func TestClientChallenge(t *testing.T) {
+	server := newDigestServer(t, passwdSecret("hello"), nil)
+	defer server.Close()
+
+	username := fmt.Sprintf("client_challenge_%d", time.Now().UnixNano())
+	c := client.New(server.URL+"/", username, "hello")
+	for i := 0; i < 3; i++ {
+		expectUser(t, c, username)
+	}
+	// the challenge is asked once, and reused with the increasing nonce count.
+	challenges, algorithms := server.reset()
+	if len(challenges) != 1 {
+		t.Fatalf("expect 1 challenge, but: %d", len(challenges))
+	}
+	if len(algorithms) != 3 || algorithms[0] != auth.DIGEST_SHA512_256 {
+		t.Fatalf("expect 3 requests by SHA-512-256, but: %v", algorithms)
+	}
+	for _, method := range []string{"POST", "PUT", "DELETE"} {
+		if _, err := c.Do(method, "/user/list", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if challenges, _ := server.reset(); len(challenges) != 0 {
+		t.Fatalf("expect the challenge reused, but: %d", len(challenges))
+	}
+
+	// the wrong password is not retried with the new challenge of the same algorithm.
+	wrong := client.New(server.URL, username, "wrong")
+	if _, err := wrong.Get("/user/list", nil); err == nil {
+		t.Fatal("expect failed")
+	}
+	if challenges, _ := server.reset(); len(challenges) != 2 {
+		t.Fatalf("expect 2 challenges for the wrong password, but: %d", len(challenges))
+	}
+}
+
+func TestClientStaleNonce(t *testing.T) {
+	store := newRotateNonceStore()
+	server := newDigestServer(t, passwdSecret("hello"), store)
+	defer server.Close()
+
+	username := fmt.Sprintf("client_stale_%d", time.Now().UnixNano())
+	c := client.New(server.URL, username, "hello")
+	expectUser(t, c, username)
+	server.reset()
+
+	// the nonce is lost by the server, the client retries with the stale challenge without the password failure.
+	store.rotate()
+	expectUser(t, c, username)
+	challenges, algorithms := server.reset()
+	if len(challenges) != 1 || client.ParseDigestChallenge(challenges[0]) == nil || !client.ParseDigestChallenge(challenges[0]).Stale {
+		t.Fatalf("expect 1 stale challenge, but: %v", challenges)
+	}
+	if len(algorithms) != 1 {
+		t.Fatalf("expect 1 authorized request, but: %v", algorithms)
+	}
+	expectUser(t, c, username)
+	if challenges, _ := server.reset(); len(challenges) != 0 {
+		t.Fatalf("expect the new challenge reused, but: %d", len(challenges))
+	}
+}
+
+func TestClientAlgorithm(t *testing.T) {
+	// the user made before the algorithms added has only the HA1 of MD5.
+	server := newDigestServer(t, func(user, realm, algorithm string) string {
+		if algorithm != auth.DIGEST_MD5 {
+			return ""
+		}
+		return auth.HashPasswd(user, realm, "hello")
+	}, nil)
+	defer server.Close()
+
+	username := fmt.Sprintf("client_algorithm_%d", time.Now().UnixNano())
+	c := client.New(server.URL, username, "hello")
+	expectUser(t, c, username)
+	expectUser(t, c, username)
+	challenges, algorithms := server.reset()
+	// the first challenge offers all, and the second one is narrowed for the user.
+	if len(challenges) != 2 {
+		t.Fatalf("expect 2 challenges, but: %d", len(challenges))
+	}
+	if next := client.ParseDigestChallenge(challenges[1]); next == nil || next.Algorithm != auth.DIGEST_MD5 {
+		t.Fatalf("expect the MD5 challenge, but: %v", challenges[1])
+	}
+	if len(algorithms) != 2 || algorithms[0] != auth.DIGEST_MD5 || algorithms[1] != auth.DIGEST_MD5 {
+		t.Fatalf("expect 2 requests by MD5, but: %v", algorithms)
+	}
+}
+
+func TestClientSession(t *testing.T) {
+	const cookieName = "test_session"
+	var logins int
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			if r.Method != "POST" || r.FormValue("username") != "carl" || r.FormValue("passwd") != "hello" {
+				w.WriteHeader(401)
+				return
+			}
+			mutex.Lock()
+			logins++
+			mutex.Unlock()
+			http.SetCookie(w, &http.Cookie{Name: cookieName, Value: r.FormValue("username"), Path: "/"})
+			http.Redirect(w, r, "/", 302)
+			return
+		}
+		cookie, err := r.Cookie(cookieName)
+		switch {
+		case err == nil:
+			w.Write([]byte(cookie.Value))
+		case r.URL.Path == "/user/list":
+			// the api answers the request without the session by 401 without the digest challenge.
+			w.WriteHeader(401)
+		default:
+			// the page redirects to the login form.
+			http.Redirect(w, r, "/login", 302)
+		}
+	}))
+	defer server.Close()
+
+	for _, uri := range []string{"/user/list", "/markdown/README.md"} {
+		mutex.Lock()
+		logins = 0
+		mutex.Unlock()
+
+		c := client.New(server.URL, "carl", "hello")
+		for i := 0; i < 2; i++ {
+			data, err := c.Get(uri, nil)
+			if err != nil {
+				t.Fatal(uri, err)
+			}
+			if string(data) != "carl" {
+				t.Fatalf("expect carl of %s, but: %s", uri, string(data))
+			}
+		}
+		mutex.Lock()
+		if logins != 1 {
+			t.Fatalf("expect 1 login of %s, but: %d", uri, logins)
+		}
+		mutex.Unlock()
+	}
+
+	wrong := client.New(server.URL, "carl", "wrong")
+	if _, err := wrong.Get("/user/list", nil); err == nil {
+		t.Fatal("expect failed")
+	}
+}
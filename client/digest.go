@@ -0,0 +1,102 @@
+package client
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	httpauth "github.com/abbot/go-http-auth"
+	"github.com/gwaylib/errors"
+)
+
+// the digest algorithms of RFC 7616, same as the server.
+const (
+	DIGEST_MD5        = "MD5"
+	DIGEST_SHA256     = "SHA-256"
+	DIGEST_SHA512_256 = "SHA-512-256"
+)
+
+// the supported digest algorithms, the strongest is first.
+var digestAlgorithms = []string{DIGEST_SHA512_256, DIGEST_SHA256, DIGEST_MD5}
+
+// the H function of the algorithm, the algorithm should be one of digestAlgorithms.
+func digestH(algorithm, data string) string {
+	switch algorithm {
+	case DIGEST_SHA256:
+		sum := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(sum[:])
+	case DIGEST_SHA512_256:
+		sum := sha512.Sum512_256([]byte(data))
+		return hex.EncodeToString(sum[:])
+	}
+	sum := md5.Sum([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// DigestChallenge is the digest challenge of server for the client,
+// the nonce count is increased by each authorization of the challenge.
+type DigestChallenge struct {
+	Realm     string
+	Nonce     string
+	Opaque    string
+	Qop       string
+	Algorithm string
+	Stale     bool // the last nonce is unknown by the server, retry with this one without asking the password
+
+	Nc uint64 // the nonce count of the last authorization
+}
+
+// ParseDigestChallenge returns the challenge of the strongest algorithm supported by the client,
+// nil will be returned if there is no digest challenge of the supported algorithms.
+func ParseDigestChallenge(header http.Header) *DigestChallenge {
+	var result *DigestChallenge
+	rank := len(digestAlgorithms)
+	for _, value := range header.Values(httpauth.NormalHeaders.Authenticate) {
+		params := httpauth.DigestAuthParams(value)
+		if params == nil {
+			continue
+		}
+		algorithm, ok := params["algorithm"]
+		if !ok {
+			algorithm = DIGEST_MD5
+		}
+		for i, supported := range digestAlgorithms {
+			if strings.EqualFold(algorithm, supported) && i < rank {
+				rank = i
+				result = &DigestChallenge{
+					Realm:     params["realm"],
+					Nonce:     params["nonce"],
+					Opaque:    params["opaque"],
+					Qop:       params["qop"],
+					Algorithm: supported,
+					Stale:     strings.EqualFold(params["stale"], "true"),
+				}
+			}
+		}
+	}
+	return result
+}
+
+// Authorization returns the value of the Authorization header with the next nonce count.
+func (ch *DigestChallenge) Authorization(method, uri, username, passwd string) (string, error) {
+	cnonceBytes := make([]byte, 8)
+	if _, err := rand.Read(cnonceBytes); err != nil {
+		return "", errors.As(err)
+	}
+	cnonce := hex.EncodeToString(cnonceBytes)
+	ch.Nc++
+	nc := fmt.Sprintf("%08x", ch.Nc)
+	response := digestH(ch.Algorithm, strings.Join([]string{
+		digestH(ch.Algorithm, username+":"+ch.Realm+":"+passwd),
+		ch.Nonce, nc, cnonce, ch.Qop,
+		digestH(ch.Algorithm, method+":"+uri),
+	}, ":"))
+	return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=%s, response="%s", opaque="%s", qop=%s, nc=%s, cnonce="%s"`,
+		username, ch.Realm, ch.Nonce, uri, ch.Algorithm, response, ch.Opaque, ch.Qop, nc, cnonce,
+	), nil
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/gwaylib/errors"
+)
+
+// GetDoc returns the document of the path in the markdown directory, e.g. "README.md" or "/markdown/README.md".
+func (c *Client) GetDoc(path string) ([]byte, error) {
+	uri := path
+	if !strings.HasPrefix(uri, DOC_URI_PREFIX) {
+		uri = DOC_URI_PREFIX + strings.TrimLeft(path, "/")
+	}
+	// escape the path like "a b.md".
+	data, err := c.Get((&url.URL{Path: uri}).EscapedPath(), nil)
+	if err != nil {
+		return nil, errors.As(err, path)
+	}
+	return data, nil
+}
+
+// ListDocReads returns the reads of the pages by admin, the newest day is the first.
+func (c *Client) ListDocReads(q *DocReadQuery) ([]DocRead, error) {
+	reads := []DocRead{}
+	if err := c.DoJSON("POST", "/doc/read/list", url.Values{
+		"username": {q.UserID},
+		"path":     {q.Path},
+		"since":    {q.Since},
+		"until":    {q.Until},
+	}, &reads); err != nil {
+		return nil, errors.As(err)
+	}
+	return reads, nil
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"time"
+)
+
+// The types of the server replies, the fields are same as the server,
+// so the client can be built without the server packages.
+
+const (
+	// the default realm of server.
+	REALM = "mdoc"
+
+	// the header of the two-factor code for the digest requests.
+	OTP_HEADER = "X-Mdoc-Otp"
+
+	DOC_URI_PREFIX = "/markdown/"
+
+	USER_KIND_ADMIN  = 1
+	USER_KIND_COMMON = 2
+)
+
+type UserItem struct {
+	ID        string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	NickName  string
+	Kind      int
+	Memo      string
+	Disabled  bool
+	// the HA1 was made in other realm, the user needs to reset the password.
+	PasswdStale bool
+}
+
+type UserExport struct {
+	ID          string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	NickName    string
+	Kind        int
+	Memo        string
+	Disabled    bool
+	Passwd      string `json:",omitempty"` // HA1 of digest
+	PasswdRealm string `json:",omitempty"` // the realm of HA1
+}
+
+type UserToken struct {
+	ID         string
+	UserID     string
+	Name       string
+	Scope      string // comma separated scopes
+	CreatedAt  int64
+	ExpiredAt  int64 // unix seconds, zero for never expired
+	LastUsedAt int64
+	Revoked    bool
+}
+
+type UserInvite struct {
+	ID        string
+	UserID    string // the bound username, empty for choosing by the invitee
+	Email     string
+	CreatedBy string
+	CreatedAt int64
+	ExpiredAt int64 // unix seconds
+	UsedAt    int64 // unix seconds, zero for unused
+	UsedBy    string
+	Revoked   bool
+}
+
+type GroupItem struct {
+	ID        string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Role      int
+	Memo      string
+	Members   []string
+}
+
+// AuthLimit is the login failures of a user from the ip.
+type AuthLimit struct {
+	ID        string
+	UserID    string
+	Ip        string
+	Times     int
+	UpdatedAt int64 // unix seconds
+	ExpiredAt int64 // unix seconds, the failures will be forgot after it
+}
+
+type Audit struct {
+	ID        int64
+	CreatedAt int64  // unix seconds
+	UserID    string // the operator
+	Ip        string
+	Action    string
+	Result    string
+	Target    string // the target user or group of the action
+	Memo      string
+}
+
+type AuditQuery struct {
+	UserID string // the operator or the target
+	Action string
+	Since  int64 // unix seconds, zero for no limit
+	Until  int64 // unix seconds, zero for no limit
+	Limit  int   // zero for no limit
+	Offset int
+}
+
+type DocRead struct {
+	UserID  string
+	Path    string
+	Day     string // local day of "2006-01-02"
+	FirstAt int64  // unix seconds
+	LastAt  int64  // unix seconds
+	Times   int
+}
+
+type DocReadQuery struct {
+	UserID string
+	Path   string // the page, or the directory ends with "/"
+	Since  string // the first day of "2006-01-02", empty for no limit
+	Until  string // the last day of "2006-01-02", empty for no limit
+}
+
+type TotpEnrollResp struct {
+	Secret string
+	URI    string
+}
+
+type TotpConfirmResp struct {
+	RecoveryCodes []string
+}
+
+type TokenCreateResp struct {
+	Token string
+	Info  *UserToken
+}
+
+type InviteCreateResp struct {
+	Token string
+	Info  *UserInvite
+	URL   string // the link of the public url, empty if the public url is not set by the server
+}
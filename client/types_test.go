@@ -0,0 +1,42 @@
+package client_test
+
+import (
+	"testing"
+
+	"github.com/gwaycc/mdoc/client"
+	"github.com/gwaycc/mdoc/route"
+	"github.com/gwaycc/mdoc/tools/auth"
+)
+
+// the conversions fail to build when the types of client are different with the server.
+var (
+	_ = auth.UserItem(client.UserItem{})
+	_ = auth.UserExport(client.UserExport{})
+	_ = auth.UserToken(client.UserToken{})
+	_ = auth.UserInvite(client.UserInvite{})
+	_ = auth.GroupItem(client.GroupItem{})
+	_ = auth.AuthLimit(client.AuthLimit{})
+	_ = auth.Audit(client.Audit{})
+	_ = auth.AuditQuery(client.AuditQuery{})
+	_ = auth.DocRead(client.DocRead{})
+	_ = auth.DocReadQuery(client.DocReadQuery{})
+	_ = route.TotpEnrollResp(client.TotpEnrollResp{})
+	_ = route.TotpConfirmResp(client.TotpConfirmResp{})
+)
+
+func TestConstants(t *testing.T) {
+	for _, test := range [][2]interface{}{
+		{client.REALM, auth.REALM},
+		{client.OTP_HEADER, auth.OTP_HEADER},
+		{client.DOC_URI_PREFIX, auth.DOC_URI_PREFIX},
+		{client.USER_KIND_ADMIN, auth.USER_KIND_ADMIN},
+		{client.USER_KIND_COMMON, auth.USER_KIND_COMMON},
+		{client.DIGEST_MD5, auth.DIGEST_MD5},
+		{client.DIGEST_SHA256, auth.DIGEST_SHA256},
+		{client.DIGEST_SHA512_256, auth.DIGEST_SHA512_256},
+	} {
+		if test[0] != test[1] {
+			t.Fatalf("expect %v of server, but: %v", test[1], test[0])
+		}
+	}
+}
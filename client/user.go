@@ -0,0 +1,230 @@
+package client
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gwaylib/errors"
+)
+
+// the admin api of the users
+
+func (c *Client) ListUsers() ([]UserItem, error) {
+	users := []UserItem{}
+	if err := c.DoJSON("POST", "/user/list", url.Values{}, &users); err != nil {
+		return nil, errors.As(err)
+	}
+	return users, nil
+}
+
+// ExportUsers returns the users for exporting, the HA1 is included when secrets is true.
+func (c *Client) ExportUsers(secrets bool) ([]UserExport, error) {
+	params := url.Values{}
+	if secrets {
+		params.Set("secrets", "1")
+	}
+	users := []UserExport{}
+	if err := c.DoJSON("POST", "/user/export", params, &users); err != nil {
+		return nil, errors.As(err)
+	}
+	return users, nil
+}
+
+// AddUser adds a common user with the plain password, the hashes are made by the server.
+func (c *Client) AddUser(username, passwd, nickName string) error {
+	if _, err := c.Post("/user/add", url.Values{
+		"username":     {username},
+		"plain_passwd": {passwd},
+		"nickname":     {nickName},
+	}); err != nil {
+		return errors.As(err, username)
+	}
+	return nil
+}
+
+// AddUserHA1 adds a common user with the HA1 of digest, the realm of HA1 should be the realm of server,
+// and the empty realm is the default REALM.
+func (c *Client) AddUserHA1(username, ha1, realm, nickName string) error {
+	params := url.Values{
+		"username": {username},
+		"passwd":   {ha1},
+		"nickname": {nickName},
+	}
+	if len(realm) > 0 {
+		params.Set("realm", realm)
+	}
+	if _, err := c.Post("/user/add", params); err != nil {
+		return errors.As(err, username)
+	}
+	return nil
+}
+
+// ResetPasswd changes the password of user by admin.
+func (c *Client) ResetPasswd(username, passwd string) error {
+	if _, err := c.Post("/user/pwd/reset", url.Values{
+		"username":     {username},
+		"plain_passwd": {passwd},
+	}); err != nil {
+		return errors.As(err, username)
+	}
+	return nil
+}
+
+func (c *Client) DelUser(username string) error {
+	if _, err := c.Post("/user/del", url.Values{"username": {username}}); err != nil {
+		return errors.As(err, username)
+	}
+	return nil
+}
+
+func (c *Client) DisableUser(username string) error {
+	if _, err := c.Post("/user/disable", url.Values{"username": {username}}); err != nil {
+		return errors.As(err, username)
+	}
+	return nil
+}
+
+func (c *Client) EnableUser(username string) error {
+	if _, err := c.Post("/user/enable", url.Values{"username": {username}}); err != nil {
+		return errors.As(err, username)
+	}
+	return nil
+}
+
+// UpdateUserInfo updates the nickname and memo of user, the nil one is not updated.
+func (c *Client) UpdateUserInfo(username string, nickName, memo *string) error {
+	params := url.Values{"username": {username}}
+	if nickName != nil {
+		params.Set("nickname", *nickName)
+	}
+	if memo != nil {
+		params.Set("memo", *memo)
+	}
+	if _, err := c.Post("/user/info/update", params); err != nil {
+		return errors.As(err, username)
+	}
+	return nil
+}
+
+// UpdateUserKind sets the kind of user, see USER_KIND_ADMIN and USER_KIND_COMMON.
+func (c *Client) UpdateUserKind(username string, kind int) error {
+	if _, err := c.Post("/user/kind/update", url.Values{
+		"username": {username},
+		"kind":     {strconv.Itoa(kind)},
+	}); err != nil {
+		return errors.As(err, username, kind)
+	}
+	return nil
+}
+
+// ResetTotp removes the two-factor of user by admin.
+func (c *Client) ResetTotp(username string) error {
+	if _, err := c.Post("/user/totp/reset", url.Values{"username": {username}}); err != nil {
+		return errors.As(err, username)
+	}
+	return nil
+}
+
+// the api of the user self
+
+// ChangePasswd changes the password of the client user, the client uses the new password after success.
+func (c *Client) ChangePasswd(passwd string) error {
+	if _, err := c.Post("/user/pwd/change", url.Values{
+		"old_passwd": {c.passwd},
+		"passwd":     {passwd},
+	}); err != nil {
+		return errors.As(err, c.username)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.passwd = passwd
+	return nil
+}
+
+// EnrollTotp makes a new two-factor secret of the client user, confirm it by ConfirmTotp.
+func (c *Client) EnrollTotp() (*TotpEnrollResp, error) {
+	resp := &TotpEnrollResp{}
+	if err := c.DoJSON("POST", "/user/totp/enroll", url.Values{}, resp); err != nil {
+		return nil, errors.As(err)
+	}
+	return resp, nil
+}
+
+// ConfirmTotp enables the two-factor with the code of authenticator app, and returns the recovery codes.
+func (c *Client) ConfirmTotp(code string) ([]string, error) {
+	resp := &TotpConfirmResp{}
+	if err := c.DoJSON("POST", "/user/totp/confirm", url.Values{"code": {code}}, resp); err != nil {
+		return nil, errors.As(err)
+	}
+	return resp.RecoveryCodes, nil
+}
+
+// CreateToken makes a new api token of the client user, the scope is comma separated,
+// and zero expires is never expired.
+func (c *Client) CreateToken(name, scope string, expires time.Duration) (*TokenCreateResp, error) {
+	params := url.Values{
+		"name":  {name},
+		"scope": {scope},
+	}
+	if expires > 0 {
+		params.Set("expires", expires.String())
+	}
+	resp := &TokenCreateResp{}
+	if err := c.DoJSON("POST", "/user/token/create", params, resp); err != nil {
+		return nil, errors.As(err, name)
+	}
+	return resp, nil
+}
+
+func (c *Client) ListTokens() ([]UserToken, error) {
+	tokens := []UserToken{}
+	if err := c.DoJSON("POST", "/user/token/list", url.Values{}, &tokens); err != nil {
+		return nil, errors.As(err)
+	}
+	return tokens, nil
+}
+
+func (c *Client) RevokeToken(id string) error {
+	if _, err := c.Post("/user/token/revoke", url.Values{"id": {id}}); err != nil {
+		return errors.As(err, id)
+	}
+	return nil
+}
+
+// CreateInvite makes a single use invite bound to the username or the email by admin,
+// zero expires is the default of server, the link is the URL of reply, or "<server>/invite?token=<token>" if it is empty.
+func (c *Client) CreateInvite(username, email string, expires time.Duration) (*InviteCreateResp, error) {
+	params := url.Values{
+		"username": {username},
+		"email":    {email},
+	}
+	if expires > 0 {
+		params.Set("expires", expires.String())
+	}
+	resp := &InviteCreateResp{}
+	if err := c.DoJSON("POST", "/user/invite/create", params, resp); err != nil {
+		return nil, errors.As(err, username, email)
+	}
+	return resp, nil
+}
+
+// ListInvites returns the pending invites, and the used, revoked or expired when all is true.
+func (c *Client) ListInvites(all bool) ([]UserInvite, error) {
+	params := url.Values{}
+	if all {
+		params.Set("all", "1")
+	}
+	invites := []UserInvite{}
+	if err := c.DoJSON("POST", "/user/invite/list", params, &invites); err != nil {
+		return nil, errors.As(err)
+	}
+	return invites, nil
+}
+
+func (c *Client) RevokeInvite(id string) error {
+	if _, err := c.Post("/user/invite/revoke", url.Values{"id": {id}}); err != nil {
+		return errors.As(err, id)
+	}
+	return nil
+}
@@ -5,14 +5,18 @@ go 1.16
 require (
 	github.com/abbot/go-http-auth v0.4.0
 	github.com/dchest/captcha v0.0.0-20200903113550-03f5f0333e1f
+	github.com/go-asn1-ber/asn1-ber v1.5.1
+	github.com/go-ldap/ldap/v3 v3.4.1
 	github.com/google/uuid v1.3.0
-	github.com/gwaylib/database v0.0.0-20191004162319-8535ba649f9c // indirect
+	github.com/gwaylib/database v0.0.0-20191004162319-8535ba649f9c
 	github.com/gwaylib/errors v0.0.0-20190905023356-162e59439c92
 	github.com/gwaylib/eweb v1.0.1
 	github.com/gwaylib/log v0.0.0-20210507100943-24bc495476d8
 	github.com/labstack/echo v3.3.10+incompatible
-	github.com/mattn/go-sqlite3 v1.14.8 // indirect
-	github.com/mitchellh/go-homedir v1.1.0 // indirect
-	github.com/sirupsen/logrus v1.8.1 // indirect
-	github.com/urfave/cli/v2 v2.3.0 // indirect
+	github.com/mattn/go-sqlite3 v1.14.8
+	github.com/mitchellh/go-homedir v1.1.0
+	github.com/sirupsen/logrus v1.8.1
+	github.com/urfave/cli/v2 v2.3.0
+	golang.org/x/crypto v0.0.0-20200604202706-70a84ac30bf9
+	rsc.io/qr v0.2.0
 )
@@ -9,7 +9,7 @@ import (
 )
 
 var app = &cmd.App{
-	&cli.App{
+	App: &cli.App{
 		Name:    "Markdown Document",
 		Version: cmd.Version(),
 		Usage:   "Run mdoc server",
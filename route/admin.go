@@ -0,0 +1,647 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/gwaylib/eweb"
+	"github.com/gwaylib/log"
+	"github.com/labstack/echo"
+)
+
+const (
+	_ADMIN_TIME_FORMAT = "2006-01-02 15:04:05"
+	_ADMIN_AUDIT_LIMIT = 50
+)
+
+// the admin console, the pages are rendered by the "admin.html" in the public directory,
+// and the forms are posted with the csrf token, then redirected to the page with the result.
+func init() {
+	e := eweb.Default()
+	e.GET("/admin", func(c echo.Context) error {
+		return c.Redirect(http.StatusFound, "/admin/users")
+	})
+	e.GET("/admin/users", AdminUsersPage)
+	e.POST("/admin/users/add", adminAction("/admin/users", AdminUserAdd))
+	e.POST("/admin/users/disable", adminAction("/admin/users", AdminUserDisable))
+	e.POST("/admin/users/enable", adminAction("/admin/users", AdminUserEnable))
+	e.POST("/admin/users/pwd/reset", adminAction("/admin/users", AdminUserPwdReset))
+	e.POST("/admin/users/del", adminAction("/admin/users", AdminUserDel))
+	e.GET("/admin/groups", AdminGroupsPage)
+	e.POST("/admin/groups/add", adminAction("/admin/groups", AdminGroupAdd))
+	e.POST("/admin/groups/del", adminAction("/admin/groups", AdminGroupDel))
+	e.POST("/admin/groups/role", adminAction("/admin/groups", AdminGroupRole))
+	e.POST("/admin/groups/member/add", adminAction("/admin/groups", AdminGroupMemberAdd))
+	e.POST("/admin/groups/member/del", adminAction("/admin/groups", AdminGroupMemberDel))
+	e.GET("/admin/sessions", AdminSessionsPage)
+	e.POST("/admin/sessions/revoke", adminAction("/admin/sessions", AdminSessionRevoke))
+	e.GET("/admin/lockouts", AdminLockoutsPage)
+	e.POST("/admin/lockouts/unlock", adminAction("/admin/lockouts", AdminLockoutUnlock))
+	e.GET("/admin/audits", AdminAuditsPage)
+	e.GET("/admin/invites", AdminInvitesPage)
+	e.POST("/admin/invites/create", AdminInviteCreate)
+	e.POST("/admin/invites/revoke", adminAction("/admin/invites", AdminInviteRevoke))
+}
+
+// consoleError is the message of failure showed to the admin, other errors are logged and showed as the system error.
+type consoleError string
+
+func (e consoleError) Error() string {
+	return string(e)
+}
+
+func formatUnix(unix int64) string {
+	return time.Unix(unix, 0).Format(_ADMIN_TIME_FORMAT)
+}
+
+// render the page of console with the data, the result of the last action is in the query.
+func renderAdmin(c echo.Context, page string, data eweb.H) error {
+	username := GetLoginUser(c)
+	csrf, err := auth.NewCsrfToken(username, time.Now())
+	if err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	data["Page"] = page
+	data["User"] = username
+	data["Csrf"] = csrf
+	data["Msg"] = c.QueryParam("msg")
+	data["Error"] = c.QueryParam("err")
+	// the console can not be framed by other sites.
+	header := c.Response().Header()
+	header.Set("X-Frame-Options", "DENY")
+	header.Set("Content-Security-Policy", "frame-ancestors 'none'")
+	header.Set("Cache-Control", "no-store")
+	return c.Render(200, "admin.html", data)
+}
+
+// check the admin and the csrf token of the form, and reply the error if failed.
+func adminForm(c echo.Context) (bool, error) {
+	if !isAdminLogin(c) {
+		return false, c.String(403, "you don't have admin auth")
+	}
+	if err := auth.CheckCsrfToken(GetLoginUser(c), FormValue(c, auth.CSRF_FORM_KEY), time.Now()); err != nil {
+		if !auth.ErrCsrfToken.Equal(err) {
+			log.Warn(errors.As(err))
+			return false, c.String(500, "System interval error")
+		}
+		log.Info(errors.As(err, GetClientIp(c)))
+		return false, c.String(403, auth.ErrCsrfToken.Code())
+	}
+	return true, nil
+}
+
+// adminAction checks the admin and the csrf token of the form, then does the action and redirects to the page,
+// the action returns the message of success, or a consoleError of failure.
+func adminAction(page string, action func(c echo.Context) (string, error)) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if ok, err := adminForm(c); !ok {
+			return err
+		}
+
+		msg, err := action(c)
+		key := "msg"
+		if err != nil {
+			key = "err"
+			if cErr, ok := err.(consoleError); ok {
+				msg = string(cErr)
+			} else {
+				log.Warn(errors.As(err))
+				msg = "System interval error"
+			}
+		}
+		return c.Redirect(http.StatusSeeOther, page+"?"+url.Values{key: {msg}}.Encode())
+	}
+}
+
+// get the user of the form for the console.
+func consoleUser(c echo.Context) (*auth.UserInfo, error) {
+	uInfo, err := auth.GetUser(FormValue(c, "username"))
+	if err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return nil, consoleError("User not found.")
+		}
+		return nil, errors.As(err)
+	}
+	return uInfo, nil
+}
+
+// get the group of the form for the console.
+func consoleGroup(c echo.Context) (*auth.GroupInfo, error) {
+	gInfo, err := auth.GetGroup(FormValue(c, "group"))
+	if err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return nil, consoleError("Group not found.")
+		}
+		return nil, errors.As(err)
+	}
+	return gInfo, nil
+}
+
+// check the new password by the policy.
+func consolePasswd(username, passwd string) error {
+	if err := auth.CheckPasswdPolicy(username, auth.GetRealm(), passwd); err != nil {
+		policy := auth.GetPasswdPolicy()
+		switch {
+		case auth.ErrPasswdTooShort.Equal(err):
+			return consoleError(fmt.Sprintf("Password need at least %d characters.", policy.MinLen))
+		case auth.ErrPasswdReused.Equal(err):
+			return consoleError(fmt.Sprintf("Password can not be the same as the last %d passwords.", policy.History))
+		}
+		return errors.As(err)
+	}
+	return nil
+}
+
+type adminUserRow struct {
+	ID          string
+	NickName    string
+	Kind        string
+	Memo        string
+	Disabled    bool
+	PasswdStale bool
+	CreatedAt   string
+}
+
+func AdminUsersPage(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	users, err := auth.ListUsers()
+	if err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	rows := []adminUserRow{}
+	for _, u := range users {
+		rows = append(rows, adminUserRow{
+			ID:          u.ID,
+			NickName:    u.NickName,
+			Kind:        auth.KindName(u.Kind),
+			Memo:        u.Memo,
+			Disabled:    u.Disabled,
+			PasswdStale: u.PasswdStale,
+			CreatedAt:   u.CreatedAt.Format(_ADMIN_TIME_FORMAT),
+		})
+	}
+	return renderAdmin(c, "users", eweb.H{"Users": rows})
+}
+
+func AdminUserAdd(c echo.Context) (string, error) {
+	username := FormValue(c, "username")
+	passwd := FormValue(c, "passwd")
+	nickName := FormValue(c, "nickname")
+	if err := auth.CheckUserName(username); err != nil {
+		return "", consoleError(fmt.Sprintf("The username needs 1-%d letters, digits or '._@+-', and starts with a letter or digit.", auth.USER_NAME_MAX_LEN))
+	}
+	if err := auth.CheckNickName(nickName); err != nil {
+		return "", consoleError(fmt.Sprintf("The nickname needs at most %d characters without the control characters.", auth.NICK_NAME_MAX_LEN))
+	}
+	if _, err := auth.GetUser(username); err == nil {
+		return "", consoleError("User already exist.")
+	} else if !errors.ErrNoData.Equal(err) {
+		return "", errors.As(err)
+	}
+	if err := consolePasswd(username, passwd); err != nil {
+		return "", err
+	}
+
+	uInfo := &auth.UserInfo{ID: username, NickName: nickName}
+	if err := uInfo.SetPlainPasswd(auth.GetRealm(), passwd); err != nil {
+		return "", errors.As(err)
+	}
+	if err := auth.AddUser(uInfo); err != nil {
+		return "", errors.As(err)
+	}
+	audit(c, auth.AUDIT_USER_ADD, auth.AUDIT_RESULT_OK, username)
+	return fmt.Sprintf("User %s added.", username), nil
+}
+
+func adminUserDisable(c echo.Context, disabled bool) (string, error) {
+	uInfo, err := consoleUser(c)
+	if err != nil {
+		return "", err
+	}
+	action, done := auth.AUDIT_USER_ENABLE, "enabled"
+	if disabled {
+		action, done = auth.AUDIT_USER_DISABLE, "disabled"
+		if last, err := isLastAdmin(uInfo); err != nil {
+			return "", errors.As(err)
+		} else if last {
+			return "", consoleError("Can not disable the last admin.")
+		}
+	}
+	if err := auth.DisableUser(uInfo.ID, disabled); err != nil {
+		return "", errors.As(err)
+	}
+	if disabled {
+		if err := auth.DelUserSessions(uInfo.ID); err != nil {
+			log.Warn(errors.As(err))
+		}
+	}
+	auth.DelAuthCache(uInfo.ID)
+	audit(c, action, auth.AUDIT_RESULT_OK, uInfo.ID)
+	return fmt.Sprintf("User %s %s.", uInfo.ID, done), nil
+}
+
+func AdminUserDisable(c echo.Context) (string, error) {
+	return adminUserDisable(c, true)
+}
+
+func AdminUserEnable(c echo.Context) (string, error) {
+	return adminUserDisable(c, false)
+}
+
+func AdminUserPwdReset(c echo.Context) (string, error) {
+	uInfo, err := consoleUser(c)
+	if err != nil {
+		return "", err
+	}
+	passwd := FormValue(c, "passwd")
+	if err := consolePasswd(uInfo.ID, passwd); err != nil {
+		return "", err
+	}
+	if err := auth.SetPasswd(uInfo.ID, auth.GetRealm(), passwd); err != nil {
+		return "", errors.As(err)
+	}
+	if err := auth.DelUserSessions(uInfo.ID); err != nil {
+		log.Warn(errors.As(err))
+	}
+	auth.DelAuthCache(uInfo.ID)
+	audit(c, auth.AUDIT_PWD_RESET, auth.AUDIT_RESULT_OK, uInfo.ID)
+	return fmt.Sprintf("The password of %s reset.", uInfo.ID), nil
+}
+
+func AdminUserDel(c echo.Context) (string, error) {
+	uInfo, err := consoleUser(c)
+	if err != nil {
+		return "", err
+	}
+	if last, err := isLastAdmin(uInfo); err != nil {
+		return "", errors.As(err)
+	} else if last {
+		return "", consoleError("Can not delete the last admin.")
+	}
+	if err := auth.DelUser(uInfo.ID); err != nil {
+		return "", errors.As(err)
+	}
+	auth.DelAuthCache(uInfo.ID)
+	audit(c, auth.AUDIT_USER_DEL, auth.AUDIT_RESULT_OK, uInfo.ID)
+	return fmt.Sprintf("User %s deleted.", uInfo.ID), nil
+}
+
+type adminGroupRow struct {
+	ID      string
+	Role    string
+	Memo    string
+	Members []string
+}
+
+func AdminGroupsPage(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	groups, err := auth.ListGroups()
+	if err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	rows := []adminGroupRow{}
+	for _, g := range groups {
+		rows = append(rows, adminGroupRow{
+			ID:      g.ID,
+			Role:    auth.RoleName(g.Role),
+			Memo:    g.Memo,
+			Members: g.Members,
+		})
+	}
+	roles := []string{}
+	for _, role := range []int{auth.ROLE_VIEWER, auth.ROLE_EDITOR, auth.ROLE_ADMIN} {
+		roles = append(roles, auth.RoleName(role))
+	}
+	return renderAdmin(c, "groups", eweb.H{"Groups": rows, "Roles": roles})
+}
+
+func AdminGroupAdd(c echo.Context) (string, error) {
+	name := FormValue(c, "group")
+	role := auth.ParseRole(FormValue(c, "role"))
+	if len(name) == 0 {
+		return "", consoleError("Need group name.")
+	}
+	if role == 0 {
+		return "", consoleError("Unknow role.")
+	}
+	if _, err := auth.GetGroup(name); err == nil {
+		return "", consoleError("Group already exist.")
+	} else if !errors.ErrNoData.Equal(err) {
+		return "", errors.As(err)
+	}
+	if err := auth.AddGroup(&auth.GroupInfo{
+		ID:   name,
+		Role: role,
+		Memo: FormValue(c, "memo"),
+	}); err != nil {
+		return "", errors.As(err)
+	}
+	audit(c, auth.AUDIT_GROUP_ADD, auth.AUDIT_RESULT_OK, name)
+	return fmt.Sprintf("Group %s added.", name), nil
+}
+
+func AdminGroupDel(c echo.Context) (string, error) {
+	gInfo, err := consoleGroup(c)
+	if err != nil {
+		return "", err
+	}
+	if err := auth.DelGroup(gInfo.ID); err != nil {
+		return "", errors.As(err)
+	}
+	audit(c, auth.AUDIT_GROUP_DEL, auth.AUDIT_RESULT_OK, gInfo.ID)
+	return fmt.Sprintf("Group %s deleted.", gInfo.ID), nil
+}
+
+func AdminGroupRole(c echo.Context) (string, error) {
+	gInfo, err := consoleGroup(c)
+	if err != nil {
+		return "", err
+	}
+	role := auth.ParseRole(FormValue(c, "role"))
+	if role == 0 {
+		return "", consoleError("Unknow role.")
+	}
+	if err := auth.UpdateGroupRole(gInfo.ID, role); err != nil {
+		return "", errors.As(err)
+	}
+	auditMemo(c, auth.AUDIT_GROUP_ROLE, auth.AUDIT_RESULT_OK, gInfo.ID, auth.RoleName(role))
+	return fmt.Sprintf("The role of %s is %s.", gInfo.ID, auth.RoleName(role)), nil
+}
+
+func AdminGroupMemberAdd(c echo.Context) (string, error) {
+	gInfo, err := consoleGroup(c)
+	if err != nil {
+		return "", err
+	}
+	uInfo, err := consoleUser(c)
+	if err != nil {
+		return "", err
+	}
+	if err := auth.AddGroupMember(gInfo.ID, uInfo.ID); err != nil {
+		return "", errors.As(err)
+	}
+	auditMemo(c, auth.AUDIT_GROUP_JOIN, auth.AUDIT_RESULT_OK, uInfo.ID, gInfo.ID)
+	return fmt.Sprintf("User %s joined %s.", uInfo.ID, gInfo.ID), nil
+}
+
+func AdminGroupMemberDel(c echo.Context) (string, error) {
+	gInfo, err := consoleGroup(c)
+	if err != nil {
+		return "", err
+	}
+	username := FormValue(c, "username")
+	if err := auth.DelGroupMember(gInfo.ID, username); err != nil {
+		return "", errors.As(err)
+	}
+	auditMemo(c, auth.AUDIT_GROUP_LEAVE, auth.AUDIT_RESULT_OK, username, gInfo.ID)
+	return fmt.Sprintf("User %s left %s.", username, gInfo.ID), nil
+}
+
+type adminSessionRow struct {
+	UserID    string
+	Ip        string
+	ExpiredAt string
+}
+
+func AdminSessionsPage(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	sessions, err := auth.ListSessions(time.Now())
+	if err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	rows := []adminSessionRow{}
+	for _, s := range sessions {
+		rows = append(rows, adminSessionRow{
+			UserID:    s.UserID,
+			Ip:        s.Ip,
+			ExpiredAt: formatUnix(s.ExpiredAt),
+		})
+	}
+	return renderAdmin(c, "sessions", eweb.H{"Sessions": rows})
+}
+
+// AdminSessionRevoke removes all the sessions of the user, the user needs login again.
+func AdminSessionRevoke(c echo.Context) (string, error) {
+	username := FormValue(c, "username")
+	if len(username) == 0 {
+		return "", consoleError("Need username.")
+	}
+	if err := auth.DelUserSessions(username); err != nil {
+		return "", errors.As(err)
+	}
+	auth.DelAuthCache(username)
+	auditMemo(c, auth.AUDIT_LOGOUT, auth.AUDIT_RESULT_OK, username, "revoked")
+	return fmt.Sprintf("The sessions of %s revoked.", username), nil
+}
+
+type adminLockoutRow struct {
+	UserID    string
+	Ip        string
+	Times     int
+	Locked    bool
+	UpdatedAt string
+	ExpiredAt string
+}
+
+func AdminLockoutsPage(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	limits, err := auth.ListAuthLimits(false)
+	if err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	rows := []adminLockoutRow{}
+	for i := range limits {
+		l := &limits[i]
+		rows = append(rows, adminLockoutRow{
+			UserID:    l.UserID,
+			Ip:        l.Ip,
+			Times:     l.Times,
+			Locked:    l.Locked(),
+			UpdatedAt: formatUnix(l.UpdatedAt),
+			ExpiredAt: formatUnix(l.ExpiredAt),
+		})
+	}
+	return renderAdmin(c, "lockouts", eweb.H{"Lockouts": rows, "LimitTimes": auth.GetLimitPolicy().Times})
+}
+
+func AdminLockoutUnlock(c echo.Context) (string, error) {
+	username := FormValue(c, "username")
+	ip := FormValue(c, "ip")
+	if len(username) == 0 && len(ip) == 0 {
+		return "", consoleError("Need username or ip.")
+	}
+	n, err := auth.UnlockAuth(username, ip)
+	if err != nil {
+		return "", errors.As(err)
+	}
+	if n == 0 {
+		return "", consoleError("Lock not found.")
+	}
+	log.Infof("auth unlocked by %s(%s): user=%s ip=%s", GetLoginUser(c), GetClientIp(c), username, ip)
+	auditMemo(c, auth.AUDIT_UNLOCK, auth.AUDIT_RESULT_OK, username, ip)
+	return fmt.Sprintf("Unlocked %s %s.", username, ip), nil
+}
+
+type adminAuditRow struct {
+	CreatedAt string
+	UserID    string
+	Ip        string
+	Action    string
+	Result    string
+	Target    string
+	Memo      string
+}
+
+// AdminAuditsPage shows the audit records, the query are "username", "action" and "page" from 1.
+func AdminAuditsPage(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	q := &auth.AuditQuery{
+		UserID: c.QueryParam("username"),
+		Action: c.QueryParam("action"),
+		// one more for the next page
+		Limit:  _ADMIN_AUDIT_LIMIT + 1,
+		Offset: (page - 1) * _ADMIN_AUDIT_LIMIT,
+	}
+	audits, err := auth.ListAudits(q)
+	if err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	hasNext := len(audits) > _ADMIN_AUDIT_LIMIT
+	if hasNext {
+		audits = audits[:_ADMIN_AUDIT_LIMIT]
+	}
+	rows := []adminAuditRow{}
+	for _, a := range audits {
+		rows = append(rows, adminAuditRow{
+			CreatedAt: formatUnix(a.CreatedAt),
+			UserID:    a.UserID,
+			Ip:        a.Ip,
+			Action:    a.Action,
+			Result:    a.Result,
+			Target:    a.Target,
+			Memo:      a.Memo,
+		})
+	}
+	pageURI := func(n int) string {
+		return "/admin/audits?" + url.Values{
+			"username": {q.UserID},
+			"action":   {q.Action},
+			"page":     {strconv.Itoa(n)},
+		}.Encode()
+	}
+	data := eweb.H{
+		"Audits":   rows,
+		"Username": q.UserID,
+		"Action":   q.Action,
+		"PageNum":  page,
+	}
+	if page > 1 {
+		data["PrevURI"] = pageURI(page - 1)
+	}
+	if hasNext {
+		data["NextURI"] = pageURI(page + 1)
+	}
+	return renderAdmin(c, "audits", data)
+}
+
+type adminInviteRow struct {
+	ID        string
+	UserID    string
+	Email     string
+	CreatedBy string
+	ExpiredAt string
+	Status    string
+	Pending   bool
+}
+
+// render the invites page with the data of the created invite.
+func adminInvitesPage(c echo.Context, data eweb.H) error {
+	invites, err := auth.ListInvites(false)
+	if err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	now := time.Now()
+	rows := []adminInviteRow{}
+	for i := range invites {
+		iv := &invites[i]
+		rows = append(rows, adminInviteRow{
+			ID:        iv.ID,
+			UserID:    iv.UserID,
+			Email:     iv.Email,
+			CreatedBy: iv.CreatedBy,
+			ExpiredAt: formatUnix(iv.ExpiredAt),
+			Status:    iv.Status(now),
+			Pending:   iv.Pending(now),
+		})
+	}
+	data["Invites"] = rows
+	return renderAdmin(c, "invites", data)
+}
+
+func AdminInvitesPage(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	return adminInvitesPage(c, eweb.H{})
+}
+
+// AdminInviteCreate makes the invite and shows the link of the public url in the page,
+// it is not redirected since the token should not be in the url of the page.
+func AdminInviteCreate(c echo.Context) error {
+	if ok, err := adminForm(c); !ok {
+		return err
+	}
+	if len(publicURL) == 0 {
+		return adminInvitesPage(c, eweb.H{"CreateError": "Need the --public-url of daemon for the link."})
+	}
+	token, _, err := createInvite(c)
+	if err != nil {
+		msg := "System interval error"
+		if cErr, ok := err.(consoleError); ok {
+			msg = string(cErr)
+		} else {
+			log.Warn(errors.As(err))
+		}
+		return adminInvitesPage(c, eweb.H{"CreateError": msg})
+	}
+	return adminInvitesPage(c, eweb.H{"InviteURL": InviteURL(publicURL, token)})
+}
+
+func AdminInviteRevoke(c echo.Context) (string, error) {
+	id := FormValue(c, "id")
+	if err := auth.RevokeInvite(id); err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return "", consoleError("Invite not found or used.")
+		}
+		return "", errors.As(err)
+	}
+	auditMemo(c, auth.AUDIT_INVITE_REVOKE, auth.AUDIT_RESULT_OK, "", id)
+	return "Invite revoked.", nil
+}
@@ -0,0 +1,441 @@
+package route
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/gwaylib/eweb"
+	"github.com/gwaylib/log"
+	"github.com/labstack/echo"
+)
+
+const (
+	API_URI_PREFIX = "/api/"
+
+	_API_USERS_URI = "/api/v1/users"
+
+	// the max size of the json body.
+	_API_BODY_MAX_SIZE = 1 << 20
+
+	API_USERS_DEFAULT_LIMIT = 100
+	API_USERS_MAX_LIMIT     = 1000
+)
+
+// the code of ApiError
+const (
+	API_ERR_FORBIDDEN        = "forbidden"
+	API_ERR_INVALID_REQUEST  = "invalid_request"
+	API_ERR_UNSUPPORTED_TYPE = "unsupported_media_type"
+	API_ERR_INVALID_USERNAME = "invalid_username"
+	API_ERR_INVALID_NICKNAME = "invalid_nickname"
+	API_ERR_INVALID_KIND     = "invalid_kind"
+	API_ERR_INVALID_PASSWORD = "invalid_password"
+	API_ERR_PASSWORD_REUSED  = "password_reused"
+	API_ERR_USER_NOT_FOUND   = "user_not_found"
+	API_ERR_USER_EXISTS      = "user_exists"
+	API_ERR_LAST_ADMIN       = "last_admin"
+	API_ERR_INTERNAL         = "internal_error"
+)
+
+func init() {
+	e := eweb.Default()
+	e.GET(_API_USERS_URI, ApiUserList)
+	e.POST(_API_USERS_URI, ApiUserCreate)
+	e.GET(_API_USERS_URI+"/:username", ApiUserGet)
+	e.PATCH(_API_USERS_URI+"/:username", ApiUserUpdate)
+	e.DELETE(_API_USERS_URI+"/:username", ApiUserDelete)
+	e.PUT(_API_USERS_URI+"/:username/password", ApiUserPasswd)
+}
+
+// ApiError is the error of the json api, it is replied as {"error": {"code": "...", "message": "..."}}.
+type ApiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type apiErrorResp struct {
+	Error ApiError `json:"error"`
+}
+
+func apiError(c echo.Context, status int, code, message string) error {
+	return c.JSON(status, &apiErrorResp{Error: ApiError{Code: code, Message: message}})
+}
+
+// log the error and reply the internal error.
+func apiInternalError(c echo.Context, err error) error {
+	log.Warn(errors.As(err))
+	return apiError(c, 500, API_ERR_INTERNAL, "System interval error")
+}
+
+// decode the json body to the value, the unknow fields are rejected, and reply the error if failed.
+//
+// The body should be "application/json", others are replied with 415,
+// so the form of a cross-site page can not be sent as the json.
+func apiBind(c echo.Context, value interface{}) (bool, error) {
+	contentType := c.Request().Header.Get(echo.HeaderContentType)
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != echo.MIMEApplicationJSON {
+		return false, apiError(c, 415, API_ERR_UNSUPPORTED_TYPE, "Need the json body, but: "+contentType)
+	}
+	decoder := json.NewDecoder(io.LimitReader(c.Request().Body, _API_BODY_MAX_SIZE))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil {
+		return false, apiError(c, 400, API_ERR_INVALID_REQUEST, "Invalid json body: "+err.Error())
+	}
+	return true, nil
+}
+
+func apiUserURI(username string) string {
+	return _API_USERS_URI + "/" + url.PathEscape(username)
+}
+
+// ApiUser is the user of the json api, the passwords are never included.
+type ApiUser struct {
+	Username      string    `json:"username"`
+	Nickname      string    `json:"nickname"`
+	Kind          string    `json:"kind"` // "admin" or "common"
+	Memo          string    `json:"memo"`
+	Disabled      bool      `json:"disabled"`
+	PasswordStale bool      `json:"password_stale"` // the HA1 was made in other realm
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func newApiUser(item *auth.UserItem) *ApiUser {
+	return &ApiUser{
+		Username:      item.ID,
+		Nickname:      item.NickName,
+		Kind:          auth.KindName(item.Kind),
+		Memo:          item.Memo,
+		Disabled:      item.Disabled,
+		PasswordStale: item.PasswdStale,
+		CreatedAt:     item.CreatedAt,
+		UpdatedAt:     item.UpdatedAt,
+	}
+}
+
+type ApiUserListResp struct {
+	Total  int        `json:"total"`
+	Limit  int        `json:"limit"`
+	Offset int        `json:"offset"`
+	Users  []*ApiUser `json:"users"`
+}
+
+// ApiUserCreateReq creates the user with the plain "password", or the "ha1" of digest MD5 in the "realm".
+type ApiUserCreateReq struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	HA1      string `json:"ha1"`
+	Realm    string `json:"realm"` // the realm of ha1, default is the realm of server
+	Nickname string `json:"nickname"`
+	Memo     string `json:"memo"`
+	Kind     string `json:"kind"` // "admin" or "common", default is "common"
+	Disabled bool   `json:"disabled"`
+}
+
+// ApiUserUpdateReq updates the fields which are not null.
+type ApiUserUpdateReq struct {
+	Nickname *string `json:"nickname"`
+	Memo     *string `json:"memo"`
+	Kind     *string `json:"kind"`
+	Disabled *bool   `json:"disabled"`
+}
+
+// ApiUserPasswdReq resets the password with the plain "password", or the "ha1" of digest MD5 in the "realm".
+type ApiUserPasswdReq struct {
+	Password string `json:"password"`
+	HA1      string `json:"ha1"`
+	Realm    string `json:"realm"`
+}
+
+// check the plain password or the HA1 of the request, reply the error if failed.
+func apiCheckPasswd(c echo.Context, username, passwd, ha1, realm string) (bool, error) {
+	switch {
+	case len(passwd) > 0 && len(ha1) > 0:
+		return false, apiError(c, 400, API_ERR_INVALID_REQUEST, "Only one of the password and ha1 is allowed.")
+	case len(passwd) > 0:
+		if err := auth.CheckPasswdPolicy(username, auth.GetRealm(), passwd); err != nil {
+			policy := auth.GetPasswdPolicy()
+			switch {
+			case auth.ErrPasswdTooShort.Equal(err):
+				return false, apiError(c, 400, API_ERR_INVALID_PASSWORD, fmt.Sprintf("Password need at least %d characters.", policy.MinLen))
+			case auth.ErrPasswdReused.Equal(err):
+				return false, apiError(c, 400, API_ERR_PASSWORD_REUSED, fmt.Sprintf("Password can not be the same as the last %d passwords.", policy.History))
+			}
+			return false, apiInternalError(c, err)
+		}
+	case len(ha1) > 0:
+		if _, err := hex.DecodeString(ha1); err != nil || len(ha1) != 32 {
+			return false, apiError(c, 400, API_ERR_INVALID_PASSWORD, "The ha1 needs 32 hex characters of the digest MD5.")
+		}
+		if auth.DigestDisabled() {
+			return false, apiError(c, 400, API_ERR_INVALID_PASSWORD, "Need plain password when the digest is disabled.")
+		}
+		if len(realm) > 0 && realm != auth.GetRealm() {
+			return false, apiError(c, 400, API_ERR_INVALID_PASSWORD, fmt.Sprintf("The realm of HA1 not match, the realm is '%s'.", auth.GetRealm()))
+		}
+	default:
+		return false, apiError(c, 400, API_ERR_INVALID_PASSWORD, "Need the password or ha1.")
+	}
+	return true, nil
+}
+
+// get the user of the path, and reply the error if failed.
+func apiPathUser(c echo.Context) (*auth.UserInfo, error) {
+	// the param is not unescaped when the path has the escaped characters.
+	username, err := url.PathUnescape(c.Param("username"))
+	if err != nil {
+		return nil, apiError(c, 404, API_ERR_USER_NOT_FOUND, "User not found.")
+	}
+	uInfo, err := auth.GetUser(username)
+	if err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return nil, apiError(c, 404, API_ERR_USER_NOT_FOUND, "User not found.")
+		}
+		return nil, apiInternalError(c, err)
+	}
+	return uInfo, nil
+}
+
+// reply the user of listing with the status.
+func apiReplyUser(c echo.Context, status int, username string) error {
+	item, err := auth.GetUserItem(username)
+	if err != nil {
+		return apiInternalError(c, err)
+	}
+	return c.JSON(status, newApiUser(item))
+}
+
+// ApiUserList lists the users by admin, the query are "limit" and "offset".
+func ApiUserList(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return apiError(c, 403, API_ERR_FORBIDDEN, "you don't have admin auth")
+	}
+	resp := &ApiUserListResp{Limit: API_USERS_DEFAULT_LIMIT, Users: []*ApiUser{}}
+	for key, val := range map[string]*int{"limit": &resp.Limit, "offset": &resp.Offset} {
+		if str := c.QueryParam(key); len(str) > 0 {
+			n, err := strconv.Atoi(str)
+			if err != nil || n < 0 || (key == "limit" && (n == 0 || n > API_USERS_MAX_LIMIT)) {
+				return apiError(c, 400, API_ERR_INVALID_REQUEST, "Invalid "+key)
+			}
+			*val = n
+		}
+	}
+	total, err := auth.CountUsers()
+	if err != nil {
+		return apiInternalError(c, err)
+	}
+	users, err := auth.ListUsersPage(resp.Limit, resp.Offset)
+	if err != nil {
+		return apiInternalError(c, err)
+	}
+	resp.Total = total
+	for i := range users {
+		resp.Users = append(resp.Users, newApiUser(&users[i]))
+	}
+	return c.JSON(200, resp)
+}
+
+func ApiUserGet(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return apiError(c, 403, API_ERR_FORBIDDEN, "you don't have admin auth")
+	}
+	uInfo, err := apiPathUser(c)
+	if uInfo == nil {
+		return err
+	}
+	return apiReplyUser(c, 200, uInfo.ID)
+}
+
+// ApiUserCreate creates the user by admin, 201 is replied with the user and the location of it.
+func ApiUserCreate(c echo.Context) error {
+	if !isAdminLogin(c) {
+		audit(c, auth.AUDIT_USER_ADD, auth.AUDIT_RESULT_REJECTED, "")
+		return apiError(c, 403, API_ERR_FORBIDDEN, "you don't have admin auth")
+	}
+	req := &ApiUserCreateReq{}
+	if ok, err := apiBind(c, req); !ok {
+		return err
+	}
+	if err := auth.CheckUserName(req.Username); err != nil {
+		return apiError(c, 400, API_ERR_INVALID_USERNAME,
+			fmt.Sprintf("The username needs 1-%d letters, digits or '._@+-', and starts with a letter or digit.", auth.USER_NAME_MAX_LEN))
+	}
+	if err := auth.CheckNickName(req.Nickname); err != nil {
+		return apiError(c, 400, API_ERR_INVALID_NICKNAME,
+			fmt.Sprintf("The nickname needs at most %d characters without the control characters.", auth.NICK_NAME_MAX_LEN))
+	}
+	kind, ok := auth.ParseKind(req.Kind)
+	if !ok {
+		return apiError(c, 400, API_ERR_INVALID_KIND, "The kind needs 'admin' or 'common'.")
+	}
+	if _, err := auth.GetUser(req.Username); err == nil {
+		return apiError(c, 409, API_ERR_USER_EXISTS, "User already exist.")
+	} else if !errors.ErrNoData.Equal(err) {
+		return apiInternalError(c, err)
+	}
+	req.HA1 = strings.ToLower(req.HA1) // the HA1 is compared with the lower hex
+	if ok, err := apiCheckPasswd(c, req.Username, req.Password, req.HA1, req.Realm); !ok {
+		return err
+	}
+
+	uInfo := &auth.UserInfo{
+		ID:       req.Username,
+		Passwd:   req.HA1,
+		NickName: req.Nickname,
+		Kind:     kind,
+		Memo:     req.Memo,
+		Disabled: req.Disabled,
+	}
+	if len(req.Password) > 0 {
+		if err := uInfo.SetPlainPasswd(auth.GetRealm(), req.Password); err != nil {
+			return apiInternalError(c, err)
+		}
+	}
+	if err := auth.AddUser(uInfo); err != nil {
+		return apiInternalError(c, err)
+	}
+	audit(c, auth.AUDIT_USER_ADD, auth.AUDIT_RESULT_OK, uInfo.ID)
+
+	c.Response().Header().Set("Location", apiUserURI(uInfo.ID))
+	return apiReplyUser(c, 201, uInfo.ID)
+}
+
+// ApiUserUpdate updates the nickname, memo, kind or disabled of the user by admin, and replies the user.
+func ApiUserUpdate(c echo.Context) error {
+	if !isAdminLogin(c) {
+		audit(c, auth.AUDIT_USER_UPDATE, auth.AUDIT_RESULT_REJECTED, c.Param("username"))
+		return apiError(c, 403, API_ERR_FORBIDDEN, "you don't have admin auth")
+	}
+	uInfo, err := apiPathUser(c)
+	if uInfo == nil {
+		return err
+	}
+	req := &ApiUserUpdateReq{}
+	if ok, err := apiBind(c, req); !ok {
+		return err
+	}
+
+	nickName, memo, kind, disabled := uInfo.NickName, uInfo.Memo, uInfo.Kind, uInfo.Disabled
+	if req.Nickname != nil {
+		if err := auth.CheckNickName(*req.Nickname); err != nil {
+			return apiError(c, 400, API_ERR_INVALID_NICKNAME,
+				fmt.Sprintf("The nickname needs at most %d characters without the control characters.", auth.NICK_NAME_MAX_LEN))
+		}
+		nickName = *req.Nickname
+	}
+	if req.Memo != nil {
+		memo = *req.Memo
+	}
+	if req.Kind != nil {
+		k, ok := auth.ParseKind(*req.Kind)
+		if !ok || len(*req.Kind) == 0 {
+			return apiError(c, 400, API_ERR_INVALID_KIND, "The kind needs 'admin' or 'common'.")
+		}
+		kind = k
+	}
+	if req.Disabled != nil {
+		disabled = *req.Disabled
+	}
+	if kind != auth.USER_KIND_ADMIN || disabled {
+		if last, err := isLastAdmin(uInfo); err != nil {
+			return apiInternalError(c, err)
+		} else if last {
+			return apiError(c, 409, API_ERR_LAST_ADMIN, "Can not demote or disable the last admin.")
+		}
+	}
+
+	if nickName != uInfo.NickName || memo != uInfo.Memo {
+		if err := auth.UpdateUserInfo(uInfo.ID, nickName, memo); err != nil {
+			return apiInternalError(c, err)
+		}
+		audit(c, auth.AUDIT_USER_UPDATE, auth.AUDIT_RESULT_OK, uInfo.ID)
+	}
+	if kind != uInfo.Kind {
+		if err := auth.UpdateUserKind(uInfo.ID, kind); err != nil {
+			return apiInternalError(c, err)
+		}
+		audit(c, auth.AUDIT_USER_KIND, auth.AUDIT_RESULT_OK, uInfo.ID)
+	}
+	if disabled != uInfo.Disabled {
+		if err := auth.DisableUser(uInfo.ID, disabled); err != nil {
+			return apiInternalError(c, err)
+		}
+		action := auth.AUDIT_USER_ENABLE
+		if disabled {
+			action = auth.AUDIT_USER_DISABLE
+			if err := auth.DelUserSessions(uInfo.ID); err != nil {
+				log.Warn(errors.As(err))
+			}
+		}
+		auth.DelAuthCache(uInfo.ID)
+		audit(c, action, auth.AUDIT_RESULT_OK, uInfo.ID)
+	}
+	return apiReplyUser(c, 200, uInfo.ID)
+}
+
+// ApiUserPasswd resets the password of the user by admin, 204 is replied.
+func ApiUserPasswd(c echo.Context) error {
+	if !isAdminLogin(c) {
+		audit(c, auth.AUDIT_PWD_RESET, auth.AUDIT_RESULT_REJECTED, c.Param("username"))
+		return apiError(c, 403, API_ERR_FORBIDDEN, "you don't have admin auth")
+	}
+	uInfo, err := apiPathUser(c)
+	if uInfo == nil {
+		return err
+	}
+	req := &ApiUserPasswdReq{}
+	if ok, err := apiBind(c, req); !ok {
+		return err
+	}
+	req.HA1 = strings.ToLower(req.HA1) // the HA1 is compared with the lower hex
+	if ok, err := apiCheckPasswd(c, uInfo.ID, req.Password, req.HA1, req.Realm); !ok {
+		return err
+	}
+	if len(req.Password) > 0 {
+		if err := auth.SetPasswd(uInfo.ID, auth.GetRealm(), req.Password); err != nil {
+			return apiInternalError(c, err)
+		}
+	} else if err := auth.ResetPwd(uInfo.ID, req.HA1); err != nil {
+		return apiInternalError(c, err)
+	}
+	if err := auth.DelUserSessions(uInfo.ID); err != nil {
+		log.Warn(errors.As(err))
+	}
+	auth.DelAuthCache(uInfo.ID)
+	audit(c, auth.AUDIT_PWD_RESET, auth.AUDIT_RESULT_OK, uInfo.ID)
+	return c.NoContent(204)
+}
+
+// ApiUserDelete deletes the user by admin, 204 is replied.
+func ApiUserDelete(c echo.Context) error {
+	if !isAdminLogin(c) {
+		audit(c, auth.AUDIT_USER_DEL, auth.AUDIT_RESULT_REJECTED, c.Param("username"))
+		return apiError(c, 403, API_ERR_FORBIDDEN, "you don't have admin auth")
+	}
+	uInfo, err := apiPathUser(c)
+	if uInfo == nil {
+		return err
+	}
+	if last, err := isLastAdmin(uInfo); err != nil {
+		return apiInternalError(c, err)
+	} else if last {
+		return apiError(c, 409, API_ERR_LAST_ADMIN, "Can not delete the last admin.")
+	}
+
+	if err := auth.DelUser(uInfo.ID); err != nil {
+		return apiInternalError(c, err)
+	}
+	auth.DelAuthCache(uInfo.ID)
+	audit(c, auth.AUDIT_USER_DEL, auth.AUDIT_RESULT_OK, uInfo.ID)
+	return c.NoContent(204)
+}
@@ -0,0 +1,215 @@
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/labstack/echo"
+)
+
+func TestApiBind(t *testing.T) {
+	e := echo.New()
+	for _, test := range []struct {
+		contentType string
+		body        string
+		status      int
+		code        string
+	}{
+		{"application/json", `{"password":"hello"}`, 200, ""},
+		{"application/json; charset=utf-8", `{"password":"hello"}`, 200, ""},
+		{"Application/JSON", `{"password":"hello"}`, 200, ""},
+		{"", `{"password":"hello"}`, 415, API_ERR_UNSUPPORTED_TYPE},
+		{"text/plain", `{"password":"hello"}`, 415, API_ERR_UNSUPPORTED_TYPE},
+		{"application/x-www-form-urlencoded", `{"password":"hello"}`, 415, API_ERR_UNSUPPORTED_TYPE},
+		{"multipart/form-data; boundary=x", `{"password":"hello"}`, 415, API_ERR_UNSUPPORTED_TYPE},
+		{"application/json", `{"password":"hello","unknown":1}`, 400, API_ERR_INVALID_REQUEST},
+	} {
+		req := httptest.NewRequest("PUT", _API_USERS_URI+"/carl/password", strings.NewReader(test.body))
+		if len(test.contentType) > 0 {
+			req.Header.Set(echo.HeaderContentType, test.contentType)
+		}
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		value := &ApiUserPasswdReq{}
+		ok, err := apiBind(c, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.status == 200 {
+			if !ok || value.Password != "hello" {
+				t.Fatalf("expect bound of %q, but: %v %+v", test.contentType, ok, value)
+			}
+			continue
+		}
+		if ok || rec.Code != test.status {
+			t.Fatalf("expect %d of %q, but: %v %d", test.status, test.contentType, ok, rec.Code)
+		}
+		resp := &apiErrorResp{}
+		if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Error.Code != test.code {
+			t.Fatalf("expect %s of %q, but: %s", test.code, test.contentType, resp.Error.Code)
+		}
+	}
+}
+
+// call the handler of api by the login user, the username is the param of path if it is not empty.
+func apiCall(t *testing.T, handler echo.HandlerFunc, login, method, uri, username, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, uri, strings.NewReader(body))
+	if len(body) > 0 {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	SetLoginUser(c, login)
+	if len(username) > 0 {
+		c.SetParamNames("username")
+		c.SetParamValues(username)
+	}
+	if err := handler(c); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func expectApiError(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	resp := &apiErrorResp{}
+	if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+		t.Fatal(err, rec.Body.String())
+	}
+	if rec.Code != status || resp.Error.Code != code {
+		t.Fatalf("expect %d %s, but: %d %s", status, code, rec.Code, rec.Body.String())
+	}
+}
+
+func TestApiUser(t *testing.T) {
+	admin := fmt.Sprintf("api_admin_%d", time.Now().UnixNano())
+	adminInfo := &auth.UserInfo{ID: admin, Kind: auth.USER_KIND_ADMIN}
+	if err := adminInfo.SetPlainPasswd(auth.GetRealm(), "hello-admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.AddUser(adminInfo); err != nil {
+		t.Fatal(err)
+	}
+	defer auth.DelUser(admin)
+	username := fmt.Sprintf("api_carl_%d", time.Now().UnixNano())
+	defer auth.DelUser(username)
+
+	rec := apiCall(t, ApiUserCreate, admin, "POST", _API_USERS_URI, "",
+		fmt.Sprintf(`{"username":%q,"password":"hello-carl","nickname":"Carl"}`, username))
+	if rec.Code != 201 || rec.Header().Get("Location") != _API_USERS_URI+"/"+username {
+		t.Fatalf("expect 201 with the location, but: %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	user := &ApiUser{}
+	if err := json.Unmarshal(rec.Body.Bytes(), user); err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != username || user.Nickname != "Carl" || user.Kind != "common" {
+		t.Fatalf("unexpected user: %+v", user)
+	}
+	expectApiError(t, apiCall(t, ApiUserCreate, admin, "POST", _API_USERS_URI, "",
+		fmt.Sprintf(`{"username":%q,"password":"hello-carl"}`, username)), 409, API_ERR_USER_EXISTS)
+	expectApiError(t, apiCall(t, ApiUserCreate, username, "POST", _API_USERS_URI, "",
+		`{"username":"api_other","password":"hello-other"}`), 403, API_ERR_FORBIDDEN)
+
+	// the validation of username, nickname and ha1.
+	for _, test := range []struct {
+		body string
+		code string
+	}{
+		{`{"username":"-carl","password":"hello-carl"}`, API_ERR_INVALID_USERNAME},
+		{`{"username":"carl/..","password":"hello-carl"}`, API_ERR_INVALID_USERNAME},
+		{`{"username":"","password":"hello-carl"}`, API_ERR_INVALID_USERNAME},
+		{fmt.Sprintf(`{"username":%q,"password":"hello-carl"}`, strings.Repeat("a", auth.USER_NAME_MAX_LEN+1)), API_ERR_INVALID_USERNAME},
+		{`{"username":"api_nick","password":"hello-carl","nickname":"Carl\u0007"}`, API_ERR_INVALID_NICKNAME},
+		{fmt.Sprintf(`{"username":"api_nick","password":"hello-carl","nickname":%q}`, strings.Repeat("a", auth.NICK_NAME_MAX_LEN+1)), API_ERR_INVALID_NICKNAME},
+		{`{"username":"api_ha1","ha1":"0123456789abcdef"}`, API_ERR_INVALID_PASSWORD},
+		{`{"username":"api_ha1","ha1":"0123456789abcdef0123456789abcdeg"}`, API_ERR_INVALID_PASSWORD},
+		{`{"username":"api_ha1","ha1":"0123456789abcdef0123456789abcdef0"}`, API_ERR_INVALID_PASSWORD},
+	} {
+		expectApiError(t, apiCall(t, ApiUserCreate, admin, "POST", _API_USERS_URI, "", test.body), 400, test.code)
+	}
+	expectApiError(t, apiCall(t, ApiUserPasswd, admin, "PUT", apiUserURI(username)+"/password", username,
+		`{"ha1":"not a hash"}`), 400, API_ERR_INVALID_PASSWORD)
+	if rec := apiCall(t, ApiUserPasswd, admin, "PUT", apiUserURI(username)+"/password", username,
+		`{"ha1":"0123456789ABCDEF0123456789ABCDEF"}`); rec.Code != 204 {
+		t.Fatalf("expect 204, but: %d %s", rec.Code, rec.Body.String())
+	}
+	if uInfo, err := auth.GetUser(username); err != nil || uInfo.Passwd != "0123456789abcdef0123456789abcdef" {
+		t.Fatalf("expect the lower ha1, but: %v %v", uInfo, err)
+	}
+
+	// the user not found.
+	nobody := "api_nobody"
+	expectApiError(t, apiCall(t, ApiUserGet, admin, "GET", apiUserURI(nobody), nobody, ""), 404, API_ERR_USER_NOT_FOUND)
+	expectApiError(t, apiCall(t, ApiUserUpdate, admin, "PATCH", apiUserURI(nobody), nobody, `{"memo":"hi"}`), 404, API_ERR_USER_NOT_FOUND)
+	expectApiError(t, apiCall(t, ApiUserPasswd, admin, "PUT", apiUserURI(nobody)+"/password", nobody, `{"password":"hello-nobody"}`), 404, API_ERR_USER_NOT_FOUND)
+	expectApiError(t, apiCall(t, ApiUserDelete, admin, "DELETE", apiUserURI(nobody), nobody, ""), 404, API_ERR_USER_NOT_FOUND)
+
+	// the last admin can not be demoted, disabled or deleted.
+	expectApiError(t, apiCall(t, ApiUserUpdate, admin, "PATCH", apiUserURI(admin), admin, `{"kind":"common"}`), 409, API_ERR_LAST_ADMIN)
+	expectApiError(t, apiCall(t, ApiUserUpdate, admin, "PATCH", apiUserURI(admin), admin, `{"disabled":true}`), 409, API_ERR_LAST_ADMIN)
+	expectApiError(t, apiCall(t, ApiUserDelete, admin, "DELETE", apiUserURI(admin), admin, ""), 409, API_ERR_LAST_ADMIN)
+
+	if rec := apiCall(t, ApiUserDelete, admin, "DELETE", apiUserURI(username), username, ""); rec.Code != 204 {
+		t.Fatalf("expect 204, but: %d %s", rec.Code, rec.Body.String())
+	}
+	expectApiError(t, apiCall(t, ApiUserGet, admin, "GET", apiUserURI(username), username, ""), 404, API_ERR_USER_NOT_FOUND)
+}
+
+func TestApiUserList(t *testing.T) {
+	admin := fmt.Sprintf("api_list_%d", time.Now().UnixNano())
+	if err := auth.AddUser(&auth.UserInfo{ID: admin, Kind: auth.USER_KIND_ADMIN}); err != nil {
+		t.Fatal(err)
+	}
+	defer auth.DelUser(admin)
+	for i := 0; i < 3; i++ {
+		username := fmt.Sprintf("%s_%d", admin, i)
+		if err := auth.AddUser(&auth.UserInfo{ID: username}); err != nil {
+			t.Fatal(err)
+		}
+		defer auth.DelUser(username)
+	}
+	total, err := auth.CountUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, query := range []string{
+		"limit=0", "limit=-1", fmt.Sprintf("limit=%d", API_USERS_MAX_LIMIT+1), "limit=abc", "offset=-1", "offset=abc",
+	} {
+		expectApiError(t, apiCall(t, ApiUserList, admin, "GET", _API_USERS_URI+"?"+query, "", ""), 400, API_ERR_INVALID_REQUEST)
+	}
+	for _, test := range []struct {
+		query  string
+		limit  int
+		offset int
+		count  int
+	}{
+		{"", API_USERS_DEFAULT_LIMIT, 0, total},
+		{fmt.Sprintf("limit=%d", API_USERS_MAX_LIMIT), API_USERS_MAX_LIMIT, 0, total},
+		{"limit=2", 2, 0, 2},
+		{fmt.Sprintf("limit=2&offset=%d", total-1), 2, total - 1, 1},
+		{fmt.Sprintf("offset=%d", total), API_USERS_DEFAULT_LIMIT, total, 0},
+	} {
+		rec := apiCall(t, ApiUserList, admin, "GET", _API_USERS_URI+"?"+test.query, "", "")
+		resp := &ApiUserListResp{}
+		if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != 200 || resp.Total != total || resp.Limit != test.limit || resp.Offset != test.offset || len(resp.Users) != test.count {
+			t.Fatalf("unexpected page of %q: %d %+v", test.query, rec.Code, resp)
+		}
+	}
+	expectApiError(t, apiCall(t, ApiUserList, admin+"_0", "GET", _API_USERS_URI, "", ""), 403, API_ERR_FORBIDDEN)
+}
@@ -0,0 +1,71 @@
+package route
+
+import (
+	"strconv"
+
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/gwaylib/eweb"
+	"github.com/gwaylib/log"
+	"github.com/labstack/echo"
+)
+
+func init() {
+	e := eweb.Default()
+	e.POST("/auth/audit/list", AuditList)
+}
+
+// audit the action of the login user, the error is only logged.
+func audit(c echo.Context, action, result, target string) {
+	auditMemo(c, action, result, target, "")
+}
+
+func auditMemo(c echo.Context, action, result, target, memo string) {
+	if err := auth.AddAudit(&auth.Audit{
+		UserID: GetLoginUser(c),
+		Ip:     GetClientIp(c),
+		Action: action,
+		Result: result,
+		Target: target,
+		Memo:   memo,
+	}); err != nil {
+		log.Warn(errors.As(err))
+	}
+}
+
+// AuditList queries the audit records by admin,
+// the form are "username", "action", "since" and "until" of unix seconds, "limit" and "offset".
+func AuditList(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	q := &auth.AuditQuery{
+		UserID: FormValue(c, "username"),
+		Action: FormValue(c, "action"),
+	}
+	for key, val := range map[string]*int64{"since": &q.Since, "until": &q.Until} {
+		if str := FormValue(c, key); len(str) > 0 {
+			n, err := strconv.ParseInt(str, 10, 64)
+			if err != nil {
+				return c.String(400, "Invalid "+key)
+			}
+			*val = n
+		}
+	}
+	for key, val := range map[string]*int{"limit": &q.Limit, "offset": &q.Offset} {
+		if str := FormValue(c, key); len(str) > 0 {
+			n, err := strconv.Atoi(str)
+			if err != nil || n < 0 {
+				return c.String(400, "Invalid "+key)
+			}
+			*val = n
+		}
+	}
+	audits, err := auth.ListAudits(q)
+	if err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	return c.JSON(200, audits)
+}
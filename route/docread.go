@@ -0,0 +1,41 @@
+package route
+
+import (
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/gwaylib/eweb"
+	"github.com/gwaylib/log"
+	"github.com/labstack/echo"
+)
+
+func init() {
+	e := eweb.Default()
+	e.POST("/doc/read/list", DocReadList)
+}
+
+// DocReadList reports the readers of a page or the pages read by a user,
+// the form are "username", "path", "since" and "until" of "2006-01-02".
+func DocReadList(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	q := &auth.DocReadQuery{
+		UserID: FormValue(c, "username"),
+		Path:   FormValue(c, "path"),
+		Since:  FormValue(c, "since"),
+		Until:  FormValue(c, "until"),
+	}
+	if len(q.UserID) == 0 && len(q.Path) == 0 {
+		return c.String(400, "Need username or path")
+	}
+	reads, err := auth.ListDocReads(q)
+	if err != nil {
+		if auth.ErrInvalidDay.Equal(err) {
+			return c.String(400, "Invalid day, need '2006-01-02'")
+		}
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	return c.JSON(200, reads)
+}
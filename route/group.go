@@ -0,0 +1,155 @@
+package route
+
+import (
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/gwaylib/eweb"
+	"github.com/gwaylib/log"
+	"github.com/labstack/echo"
+)
+
+func init() {
+	e := eweb.Default()
+	e.POST("/group/list", GroupList)
+	e.POST("/group/add", GroupAdd)
+	e.POST("/group/del", GroupDel)
+	e.POST("/group/role/update", GroupRoleUpdate)
+	e.POST("/group/member/add", GroupMemberAdd)
+	e.POST("/group/member/del", GroupMemberDel)
+}
+
+// get the group of the form, and reply the error if failed.
+func formGroup(c echo.Context) (*auth.GroupInfo, error) {
+	name := FormValue(c, "group")
+	gInfo, err := auth.GetGroup(name)
+	if err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return nil, c.String(404, "Group not found.")
+		}
+		log.Warn(errors.As(err))
+		return nil, c.String(500, "System interval error")
+	}
+	return gInfo, nil
+}
+
+func GroupList(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	groups, err := auth.ListGroups()
+	if err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	return c.JSON(200, groups)
+}
+
+func GroupAdd(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+
+	name := FormValue(c, "group")
+	role := auth.ParseRole(FormValue(c, "role"))
+	memo := FormValue(c, "memo")
+	if len(name) == 0 {
+		return c.String(400, "Need group name.")
+	}
+	if role == 0 {
+		return c.String(400, "Unknow role.")
+	}
+
+	if _, err := auth.GetGroup(name); err != nil {
+		if !errors.ErrNoData.Equal(err) {
+			log.Warn(errors.As(err))
+			return c.String(500, "System interval error")
+		}
+		// pass
+	} else {
+		return c.String(403, "Group already exist.")
+	}
+
+	if err := auth.AddGroup(&auth.GroupInfo{
+		ID:   name,
+		Role: role,
+		Memo: memo,
+	}); err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	audit(c, auth.AUDIT_GROUP_ADD, auth.AUDIT_RESULT_OK, name)
+	return c.String(200, "OK")
+}
+
+func GroupDel(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	gInfo, err := formGroup(c)
+	if gInfo == nil {
+		return err
+	}
+	if err := auth.DelGroup(gInfo.ID); err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	audit(c, auth.AUDIT_GROUP_DEL, auth.AUDIT_RESULT_OK, gInfo.ID)
+	return c.String(200, "OK")
+}
+
+func GroupRoleUpdate(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	gInfo, err := formGroup(c)
+	if gInfo == nil {
+		return err
+	}
+	role := auth.ParseRole(FormValue(c, "role"))
+	if role == 0 {
+		return c.String(400, "Unknow role.")
+	}
+	if err := auth.UpdateGroupRole(gInfo.ID, role); err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	auditMemo(c, auth.AUDIT_GROUP_ROLE, auth.AUDIT_RESULT_OK, gInfo.ID, auth.RoleName(role))
+	return c.String(200, "OK")
+}
+
+func GroupMemberAdd(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	gInfo, err := formGroup(c)
+	if gInfo == nil {
+		return err
+	}
+	uInfo, err := formUser(c)
+	if uInfo == nil {
+		return err
+	}
+	if err := auth.AddGroupMember(gInfo.ID, uInfo.ID); err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	auditMemo(c, auth.AUDIT_GROUP_JOIN, auth.AUDIT_RESULT_OK, uInfo.ID, gInfo.ID)
+	return c.String(200, "OK")
+}
+
+func GroupMemberDel(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	gInfo, err := formGroup(c)
+	if gInfo == nil {
+		return err
+	}
+	if err := auth.DelGroupMember(gInfo.ID, FormValue(c, "username")); err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	auditMemo(c, auth.AUDIT_GROUP_LEAVE, auth.AUDIT_RESULT_OK, FormValue(c, "username"), gInfo.ID)
+	return c.String(200, "OK")
+}
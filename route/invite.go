@@ -0,0 +1,267 @@
+package route
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/gwaylib/eweb"
+	"github.com/gwaylib/log"
+	"github.com/labstack/echo"
+)
+
+const (
+	// the page of accepting the invite, it needs no login.
+	INVITE_URI = "/invite"
+
+	INVITE_DEFAULT_EXPIRES = 7 * 24 * time.Hour
+)
+
+func init() {
+	e := eweb.Default()
+	e.POST("/user/invite/create", UserInviteCreate)
+	e.POST("/user/invite/list", UserInviteList)
+	e.POST("/user/invite/revoke", UserInviteRevoke)
+	e.GET(INVITE_URI, InvitePage)
+	e.POST(INVITE_URI, InviteAccept)
+}
+
+// the external url of server for the links sent to the users, the Host header of request is not trusted.
+var publicURL string
+
+// SetPublicURL sets the external url of server like "https://doc.example.com", empty for not set.
+func SetPublicURL(u string) error {
+	if len(u) == 0 {
+		publicURL = ""
+		return nil
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		return errors.As(err, u)
+	}
+	if (pu.Scheme != "http" && pu.Scheme != "https") || len(pu.Host) == 0 || pu.User != nil || len(pu.RawQuery) > 0 || len(pu.Fragment) > 0 {
+		return errors.New("Invalid public url").As(u)
+	}
+	publicURL = strings.TrimRight(pu.String(), "/")
+	return nil
+}
+
+// InviteURL returns the link of invite for the server url like "http://localhost:8080".
+func InviteURL(server, token string) string {
+	return strings.TrimRight(server, "/") + INVITE_URI + "?" + url.Values{"token": {token}}.Encode()
+}
+
+type InviteCreateResp struct {
+	Token string
+	Info  *auth.UserInvite
+	URL   string // the link of the public url, empty if the public url is not set
+}
+
+// create the invite of the form by admin, the result of failure is a consoleError.
+func createInvite(c echo.Context) (string, *auth.UserInvite, error) {
+	username := FormValue(c, "username")
+	email := FormValue(c, "email")
+	if len(username) == 0 && len(email) == 0 {
+		return "", nil, consoleError("Need username or email.")
+	}
+	if len(username) > 0 {
+		if err := auth.CheckUserName(username); err != nil {
+			return "", nil, consoleError(fmt.Sprintf("The username needs 1-%d letters, digits or '._@+-', and starts with a letter or digit.", auth.USER_NAME_MAX_LEN))
+		}
+		if _, err := auth.GetUser(username); err == nil {
+			return "", nil, consoleError("User already exist.")
+		} else if !errors.ErrNoData.Equal(err) {
+			return "", nil, errors.As(err)
+		}
+	}
+	if len(email) > 0 {
+		if err := auth.CheckEmail(email); err != nil {
+			return "", nil, consoleError("Invalid email.")
+		}
+	}
+	expires := INVITE_DEFAULT_EXPIRES
+	if expiresStr := FormValue(c, "expires"); len(expiresStr) > 0 {
+		d, err := time.ParseDuration(expiresStr)
+		if err != nil || d <= 0 {
+			return "", nil, consoleError("Invalid expires.")
+		}
+		expires = d
+	}
+	token, iv, err := auth.CreateInvite(username, email, GetLoginUser(c), expires)
+	if err != nil {
+		return "", nil, errors.As(err)
+	}
+	auditMemo(c, auth.AUDIT_INVITE_CREATE, auth.AUDIT_RESULT_OK, username, iv.ID+" "+email)
+	return token, iv, nil
+}
+
+// UserInviteCreate makes a new invite by admin, the form are "username", "email" and "expires" of duration,
+// the token of invite can not be got again.
+func UserInviteCreate(c echo.Context) error {
+	if !isAdminLogin(c) {
+		audit(c, auth.AUDIT_INVITE_CREATE, auth.AUDIT_RESULT_REJECTED, FormValue(c, "username"))
+		return c.String(403, "you don't have admin auth")
+	}
+	token, iv, err := createInvite(c)
+	if err != nil {
+		if cErr, ok := err.(consoleError); ok {
+			return c.String(400, string(cErr))
+		}
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	resp := &InviteCreateResp{Token: token, Info: iv}
+	if len(publicURL) > 0 {
+		resp.URL = InviteURL(publicURL, token)
+	}
+	return c.JSON(200, resp)
+}
+
+// UserInviteList lists the pending invites, and the used, revoked or expired when "all" is set.
+func UserInviteList(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	invites, err := auth.ListInvites(len(FormValue(c, "all")) == 0)
+	if err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	return c.JSON(200, invites)
+}
+
+func UserInviteRevoke(c echo.Context) error {
+	if !isAdminLogin(c) {
+		audit(c, auth.AUDIT_INVITE_REVOKE, auth.AUDIT_RESULT_REJECTED, "")
+		return c.String(403, "you don't have admin auth")
+	}
+	id := FormValue(c, "id")
+	if err := auth.RevokeInvite(id); err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return c.String(404, "Invite not found or used.")
+		}
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	auditMemo(c, auth.AUDIT_INVITE_REVOKE, auth.AUDIT_RESULT_OK, "", id)
+	return c.String(200, "OK")
+}
+
+func renderInvite(c echo.Context, code int, token string, iv *auth.UserInvite, msg string) error {
+	data := eweb.H{
+		"Token": token,
+		"Error": msg,
+	}
+	if iv != nil {
+		data["Valid"] = true
+		data["Username"] = iv.UserID
+		data["Bound"] = len(iv.UserID) > 0
+		if len(iv.UserID) == 0 {
+			data["Username"] = FormValue(c, "username")
+		}
+		data["Email"] = iv.Email
+		data["Nickname"] = FormValue(c, "nickname")
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	// the token should not be sent to other sites by the links.
+	c.Response().Header().Set("Referrer-Policy", "no-referrer")
+	return c.Render(code, "invite.html", data)
+}
+
+// get the invite of the token, and render the page of invalid invite if failed.
+func formInvite(c echo.Context, token string) (*auth.UserInvite, error) {
+	iv, err := auth.GetInvite(token)
+	if err != nil {
+		if auth.ErrInviteInvalid.Equal(err) {
+			log.Info(errors.As(err, GetClientIp(c)))
+			return nil, renderInvite(c, 404, "", nil, "The invite is invalid, used or expired, please ask the admin for a new one.")
+		}
+		log.Warn(errors.As(err))
+		return nil, renderInvite(c, 500, "", nil, "System interval error")
+	}
+	return iv, nil
+}
+
+// InvitePage shows the form of the invite for setting the password.
+func InvitePage(c echo.Context) error {
+	token := c.QueryParam("token")
+	iv, err := formInvite(c, token)
+	if iv == nil {
+		return err
+	}
+	return renderInvite(c, 200, token, iv, "")
+}
+
+// InviteAccept adds the user by the invite with the password set by the invitee.
+func InviteAccept(c echo.Context) error {
+	token := FormValue(c, "token")
+	iv, err := formInvite(c, token)
+	if iv == nil {
+		return err
+	}
+	username := iv.UserID
+	if len(username) == 0 {
+		username = FormValue(c, "username")
+	}
+	nickName := FormValue(c, "nickname")
+	passwd := FormValue(c, "passwd")
+	if err := auth.CheckUserName(username); err != nil {
+		return renderInvite(c, 400, token, iv,
+			fmt.Sprintf("The username needs 1-%d letters, digits or '._@+-', and starts with a letter or digit.", auth.USER_NAME_MAX_LEN))
+	}
+	if err := auth.CheckNickName(nickName); err != nil {
+		return renderInvite(c, 400, token, iv,
+			fmt.Sprintf("The nickname needs at most %d characters without the control characters.", auth.NICK_NAME_MAX_LEN))
+	}
+	if passwd != FormValue(c, "passwd2") {
+		return renderInvite(c, 400, token, iv, "The passwords not match.")
+	}
+	if err := consolePasswd(username, passwd); err != nil {
+		if cErr, ok := err.(consoleError); ok {
+			return renderInvite(c, 400, token, iv, string(cErr))
+		}
+		log.Warn(errors.As(err))
+		return renderInvite(c, 500, token, iv, "System interval error")
+	}
+
+	uInfo := &auth.UserInfo{ID: username, NickName: nickName, Memo: iv.Email}
+	if err := uInfo.SetPlainPasswd(auth.GetRealm(), passwd); err != nil {
+		log.Warn(errors.As(err))
+		return renderInvite(c, 500, token, iv, "System interval error")
+	}
+	if _, err := auth.AcceptInvite(token, uInfo); err != nil {
+		switch {
+		case auth.ErrUserExist.Equal(err):
+			return renderInvite(c, 409, token, iv, "User already exist, please choose another username.")
+		case auth.ErrInviteInvalid.Equal(err):
+			log.Info(errors.As(err, GetClientIp(c)))
+			return renderInvite(c, 404, "", nil, "The invite is invalid, used or expired, please ask the admin for a new one.")
+		}
+		log.Warn(errors.As(err))
+		return renderInvite(c, 500, token, iv, "System interval error")
+	}
+	if err := auth.AddAudit(&auth.Audit{
+		UserID: username,
+		Ip:     GetClientIp(c),
+		Action: auth.AUDIT_INVITE_ACCEPT,
+		Result: auth.AUDIT_RESULT_OK,
+		Target: username,
+		Memo:   iv.ID,
+	}); err != nil {
+		log.Warn(errors.As(err))
+	}
+
+	loginURI := "/"
+	if sessionAuth != nil {
+		loginURI = "/login"
+	}
+	return c.Render(200, "invite.html", eweb.H{
+		"Done":     true,
+		"Username": username,
+		"LoginURI": loginURI,
+	})
+}
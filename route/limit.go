@@ -0,0 +1,52 @@
+package route
+
+import (
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/gwaylib/eweb"
+	"github.com/gwaylib/log"
+	"github.com/labstack/echo"
+)
+
+func init() {
+	e := eweb.Default()
+	e.POST("/auth/limit/list", AuthLimitList)
+	e.POST("/auth/limit/unlock", AuthLimitUnlock)
+}
+
+// AuthLimitList lists the locked users and ips, and the failures not locked yet when "all" is set.
+func AuthLimitList(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	limits, err := auth.ListAuthLimits(len(FormValue(c, "all")) == 0)
+	if err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	return c.JSON(200, limits)
+}
+
+// AuthLimitUnlock cleans the login failures of the user or ip.
+func AuthLimitUnlock(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	username := FormValue(c, "username")
+	ip := FormValue(c, "ip")
+	if len(username) == 0 && len(ip) == 0 {
+		return c.String(400, "Need username or ip")
+	}
+	n, err := auth.UnlockAuth(username, ip)
+	if err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	if n == 0 {
+		return c.String(404, "Lock not found")
+	}
+	log.Infof("auth unlocked by %s(%s): user=%s ip=%s", GetLoginUser(c), GetClientIp(c), username, ip)
+	auditMemo(c, auth.AUDIT_UNLOCK, auth.AUDIT_RESULT_OK, username, ip)
+	return c.String(200, "OK")
+}
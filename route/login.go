@@ -0,0 +1,115 @@
+package route
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/gwaylib/eweb"
+	"github.com/gwaylib/log"
+	"github.com/labstack/echo"
+)
+
+// the captcha of the login form, "<id>.png" for the image and "<id>.wav" for the audio.
+const CAPTCHA_URI_PREFIX = "/login/captcha/"
+
+var sessionAuth *auth.SessionAuth
+
+// RegisterLogin registers the routes of the session login mode.
+func RegisterLogin(sa *auth.SessionAuth) {
+	sessionAuth = sa
+
+	e := eweb.Default()
+	e.GET("/login", LoginPage)
+	e.POST("/login", Login)
+	e.GET(CAPTCHA_URI_PREFIX+"*", echo.WrapHandler(auth.CaptchaHandler()))
+	e.GET("/logout", Logout)
+	e.POST("/logout", Logout)
+}
+
+func loginData(redirect, msg string) eweb.H {
+	return eweb.H{
+		"Redirect": redirect,
+		"Error":    msg,
+		"Oidc":     oidcAuth != nil,
+	}
+}
+
+func renderLogin(c echo.Context, code int, redirect, msg string) error {
+	return c.Render(code, "login.html", loginData(redirect, msg))
+}
+
+// render the login form again after the failure of the user, a new captcha is shown if it is required.
+func renderLoginFailed(c echo.Context, code int, redirect, username, msg string) error {
+	needCaptcha, err := sessionAuth.NeedCaptcha(c.Request(), username)
+	if err != nil {
+		log.Warn(errors.As(err))
+		return renderLogin(c, 500, redirect, "System interval error")
+	}
+	data := loginData(redirect, msg)
+	data["Username"] = username
+	if needCaptcha {
+		data["CaptchaID"] = auth.NewCaptcha()
+		data["CaptchaURI"] = CAPTCHA_URI_PREFIX
+	}
+	return c.Render(code, "login.html", data)
+}
+
+// LoginPage shows the login form, or redirects to the identity provider in the oidc mode,
+// the form of local users is still available with "?local=1".
+func LoginPage(c echo.Context) error {
+	redirect := LocalRedirect(c.QueryParam("redirect"))
+	if oidcAuth != nil && len(c.QueryParam("local")) == 0 {
+		return c.Redirect(http.StatusFound, "/login/oidc?redirect="+url.QueryEscape(redirect))
+	}
+	return renderLogin(c, 200, redirect, "")
+}
+
+func Login(c echo.Context) error {
+	username := FormValue(c, "username")
+	passwd := FormValue(c, "passwd")
+	code := FormValue(c, "code")
+	captchaID := FormValue(c, "captcha_id")
+	redirect := LocalRedirect(FormValue(c, "redirect"))
+
+	err := sessionAuth.Login(c.Response().Writer, c.Request(), username, passwd, code, captchaID, FormValue(c, "captcha"))
+	switch {
+	case err == nil:
+		return c.Redirect(http.StatusFound, redirect)
+	case auth.ErrNeedLogin.Equal(err):
+		log.Info(errors.As(err, GetClientIp(c)))
+		return renderLogin(c, 401, redirect, "Incorrect username or password.")
+	case auth.ErrNeedPwd.Equal(err):
+		log.Info(errors.As(err, GetClientIp(c)))
+		return renderLoginFailed(c, 401, redirect, username, "Incorrect username or password.")
+	case auth.ErrNeedOtp.Equal(err):
+		log.Info(errors.As(err, GetClientIp(c)))
+		return renderLoginFailed(c, 401, redirect, username, "Incorrect two-factor code.")
+	case auth.ErrNeedCaptcha.Equal(err):
+		log.Info(errors.As(err, GetClientIp(c)))
+		if len(captchaID) == 0 {
+			return renderLoginFailed(c, 401, redirect, username, "Too many login failures, please input the captcha.")
+		}
+		return renderLoginFailed(c, 401, redirect, username, "Incorrect captcha.")
+	case auth.ErrReject.Equal(err):
+		log.Info(errors.As(err, GetClientIp(c)))
+		return renderLogin(c, 403, redirect, auth.ErrReject.Code())
+	default:
+		log.Warn(errors.As(err))
+		return renderLogin(c, 500, redirect, "System interval error")
+	}
+}
+
+func Logout(c echo.Context) error {
+	if err := sessionAuth.Logout(c.Response().Writer, c.Request()); err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	if oidcAuth != nil {
+		// not login again by the session of identity provider.
+		return c.Redirect(http.StatusFound, "/login?local=1")
+	}
+	return c.Redirect(http.StatusFound, "/login")
+}
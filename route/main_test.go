@@ -0,0 +1,21 @@
+package route
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gwaycc/mdoc/tools/auth"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "mdoc-route-test")
+	if err != nil {
+		panic(err)
+	}
+	auth.InitDB(filepath.Join(dir, "mdoc.db"))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
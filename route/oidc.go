@@ -0,0 +1,57 @@
+package route
+
+import (
+	"net/http"
+
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/gwaylib/eweb"
+	"github.com/gwaylib/log"
+	"github.com/labstack/echo"
+)
+
+var oidcAuth *auth.OidcAuth
+
+// RegisterOidc registers the routes of the oidc login mode, it needs the RegisterLogin.
+func RegisterOidc(oa *auth.OidcAuth) {
+	oidcAuth = oa
+
+	e := eweb.Default()
+	e.GET("/login/oidc", OidcLogin)
+	e.GET("/login/oidc/callback", OidcCallback)
+}
+
+// OidcLogin redirects to the identity provider.
+func OidcLogin(c echo.Context) error {
+	authURL, err := oidcAuth.AuthURL(c.Response().Writer, c.Request(), LocalRedirect(c.QueryParam("redirect")))
+	if err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// OidcCallback finishes the login of identity provider.
+func OidcCallback(c echo.Context) error {
+	redirect, err := oidcAuth.Callback(c.Response().Writer, c.Request(), sessionAuth)
+	switch {
+	case err == nil:
+		return c.Redirect(http.StatusFound, LocalRedirect(redirect))
+	case auth.ErrOidc.Equal(err):
+		log.Info(errors.As(err, GetClientIp(c)))
+		return renderLogin(c, 401, "/", "Single sign-on failed, please try again.")
+	case auth.ErrNotProvisioned.Equal(err):
+		log.Info(errors.As(err, GetClientIp(c)))
+		return renderLogin(c, 403, "/", "The user is not registered, please contact the admin.")
+	case auth.ErrLocalUser.Equal(err):
+		log.Info(errors.As(err, GetClientIp(c)))
+		return renderLogin(c, 403, "/", "The user has a local password, please login with the password.")
+	case auth.ErrReject.Equal(err):
+		log.Info(errors.As(err, GetClientIp(c)))
+		return renderLogin(c, 403, "/", "The user is disabled.")
+	default:
+		log.Warn(errors.As(err))
+		return renderLogin(c, 500, "/", "System interval error")
+	}
+}
@@ -0,0 +1,94 @@
+package route
+
+import (
+	"time"
+
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/gwaylib/eweb"
+	"github.com/gwaylib/log"
+	"github.com/labstack/echo"
+)
+
+func init() {
+	e := eweb.Default()
+	e.POST("/user/token/create", UserTokenCreate)
+	e.POST("/user/token/list", UserTokenList)
+	e.POST("/user/token/revoke", UserTokenRevoke)
+}
+
+type TokenCreateResp struct {
+	Token string
+	Info  *auth.UserToken
+}
+
+// UserTokenCreate makes a new api token for the login user, the token can not be got again.
+func UserTokenCreate(c echo.Context) error {
+	username := GetLoginUser(c)
+	if len(username) == 0 {
+		return c.String(401, "Need login.")
+	}
+	// a token can not make another token.
+	if GetLoginToken(c) != nil {
+		return c.String(403, "Need login with password.")
+	}
+
+	name := FormValue(c, "name")
+	if len(name) == 0 {
+		return c.String(400, "Need name")
+	}
+	scope, err := auth.ParseTokenScope(FormValue(c, "scope"))
+	if err != nil {
+		return c.String(400, err.Error())
+	}
+	// the admin scope can only be granted by admin.
+	if (&auth.UserToken{Scope: scope}).HasScope(auth.TOKEN_SCOPE_ADMIN) && !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	expires := time.Duration(0)
+	if expiresStr := FormValue(c, "expires"); len(expiresStr) > 0 {
+		expires, err = time.ParseDuration(expiresStr)
+		if err != nil || expires < 0 {
+			return c.String(400, "Invalid expires")
+		}
+	}
+	token, info, err := auth.CreateToken(username, name, scope, expires)
+	if err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	auditMemo(c, auth.AUDIT_TOKEN_CREATE, auth.AUDIT_RESULT_OK, "", info.ID+" "+info.Scope)
+	return c.JSON(200, &TokenCreateResp{Token: token, Info: info})
+}
+
+// UserTokenList lists the api tokens of the login user, the token secret is not included.
+func UserTokenList(c echo.Context) error {
+	username := GetLoginUser(c)
+	if len(username) == 0 {
+		return c.String(401, "Need login.")
+	}
+	tokens, err := auth.ListTokens(username)
+	if err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	return c.JSON(200, tokens)
+}
+
+// UserTokenRevoke revokes the api token of the login user.
+func UserTokenRevoke(c echo.Context) error {
+	username := GetLoginUser(c)
+	if len(username) == 0 {
+		return c.String(401, "Need login.")
+	}
+	if err := auth.RevokeToken(username, FormValue(c, "id")); err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return c.String(404, "Token not found")
+		}
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	auditMemo(c, auth.AUDIT_TOKEN_REVOKE, auth.AUDIT_RESULT_OK, "", FormValue(c, "id"))
+	return c.String(200, "OK")
+}
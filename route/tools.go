@@ -2,11 +2,14 @@ package route
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"net/http"
 	"net/http/httputil"
 
+	"github.com/gwaycc/mdoc/tools/auth"
+
 	"github.com/gwaylib/errors"
 	"github.com/labstack/echo"
 )
@@ -26,6 +29,17 @@ func FormValue(c echo.Context, key string) string {
 	return req.FormValue(key)
 }
 
+// HasFormValue returns true if the key is in the form, even the value is empty.
+func HasFormValue(c echo.Context, key string) bool {
+	req := c.Request()
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := req.ParseForm(); err != nil {
+		return false
+	}
+	_, ok := req.Form[key]
+	return ok
+}
+
 func ParseTime(timeStr string) (time.Time, error) {
 	t, err := time.Parse("2006-01-02 15:04:05", timeStr)
 	if err != nil {
@@ -33,3 +47,59 @@ func ParseTime(timeStr string) (time.Time, error) {
 	}
 	return t, nil
 }
+
+const (
+	_LOGIN_USER_KEY = "login_user"
+)
+
+// SetLoginUser keeps the username who passed the authentication of the daemon filter.
+func SetLoginUser(c echo.Context, username string) {
+	c.Set(_LOGIN_USER_KEY, username)
+}
+
+// GetLoginUser returns the username who passed the authentication, empty if not login.
+func GetLoginUser(c echo.Context) string {
+	username, _ := c.Get(_LOGIN_USER_KEY).(string)
+	return username
+}
+
+// LocalRedirect returns the uri if it is a local path, or return "/" for preventing open redirect.
+func LocalRedirect(uri string) string {
+	if !strings.HasPrefix(uri, "/") || strings.HasPrefix(uri, "//") || strings.HasPrefix(uri, "/\\") {
+		return "/"
+	}
+	return uri
+}
+
+const (
+	_LOGIN_TOKEN_KEY = "login_token"
+)
+
+// SetLoginToken keeps the api token when the user is login with "Authorization: Bearer".
+func SetLoginToken(c echo.Context, token *auth.UserToken) {
+	c.Set(_LOGIN_TOKEN_KEY, token)
+}
+
+// GetLoginToken returns the api token of the login user, nil if not login with token.
+func GetLoginToken(c echo.Context) *auth.UserToken {
+	token, _ := c.Get(_LOGIN_TOKEN_KEY).(*auth.UserToken)
+	return token
+}
+
+const (
+	_CLIENT_IP_KEY = "client_ip"
+)
+
+// SetClientIp keeps the client ip resolved by auth.ClientIp.
+func SetClientIp(c echo.Context, ip string) {
+	c.Set(_CLIENT_IP_KEY, ip)
+}
+
+// GetClientIp returns the client ip resolved by the daemon filter, or the ip of the remote address.
+func GetClientIp(c echo.Context) string {
+	ip, ok := c.Get(_CLIENT_IP_KEY).(string)
+	if !ok {
+		return auth.ClientIp(c.Request())
+	}
+	return ip
+}
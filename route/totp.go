@@ -0,0 +1,100 @@
+package route
+
+import (
+	"github.com/gwaycc/mdoc/tools/auth"
+
+	"github.com/gwaylib/errors"
+	"github.com/gwaylib/eweb"
+	"github.com/gwaylib/log"
+	"github.com/labstack/echo"
+)
+
+const (
+	TOTP_ISSUER = "mdoc"
+)
+
+func init() {
+	e := eweb.Default()
+	e.POST("/user/totp/enroll", UserTotpEnroll)
+	e.POST("/user/totp/confirm", UserTotpConfirm)
+	e.POST("/user/totp/reset", UserTotpReset)
+}
+
+type TotpEnrollResp struct {
+	Secret string
+	URI    string
+}
+
+// UserTotpEnroll makes a new two-factor secret for the login user, it need be confirmed by UserTotpConfirm.
+//
+// It is refused in the digest login mode, since the browser can not send the code with the digest.
+func UserTotpEnroll(c echo.Context) error {
+	username := GetLoginUser(c)
+	if len(username) == 0 {
+		return c.String(401, "Need login.")
+	}
+	if GetLoginToken(c) != nil {
+		return c.String(403, "Need login with password.")
+	}
+	if sessionAuth == nil {
+		return c.String(403, "Two-factor needs the session or oidc login mode, the browser can not send the code in the digest login mode.")
+	}
+	secret, err := auth.EnrollTotp(username)
+	if err != nil {
+		if auth.ErrTotpEnabled.Equal(err) {
+			return c.String(403, "Two-factor already enabled, ask the admin to reset it.")
+		}
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	return c.JSON(200, &TotpEnrollResp{
+		Secret: secret,
+		URI:    auth.TotpURI(TOTP_ISSUER, username, secret),
+	})
+}
+
+type TotpConfirmResp struct {
+	RecoveryCodes []string
+}
+
+// UserTotpConfirm enables the two-factor of the login user, and returns the recovery codes.
+func UserTotpConfirm(c echo.Context) error {
+	username := GetLoginUser(c)
+	if len(username) == 0 {
+		return c.String(401, "Need login.")
+	}
+	if GetLoginToken(c) != nil {
+		return c.String(403, "Need login with password.")
+	}
+	codes, err := auth.ConfirmTotp(username, FormValue(c, "code"))
+	if err != nil {
+		switch {
+		case auth.ErrTotpEnabled.Equal(err):
+			return c.String(403, "Two-factor already enabled.")
+		case auth.ErrNeedOtp.Equal(err):
+			return c.String(400, "Incorrect two-factor code.")
+		}
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	audit(c, auth.AUDIT_TOTP_ENABLE, auth.AUDIT_RESULT_OK, "")
+	return c.JSON(200, &TotpConfirmResp{RecoveryCodes: codes})
+}
+
+// UserTotpReset removes the two-factor of the user by admin.
+func UserTotpReset(c echo.Context) error {
+	if !isAdminLogin(c) {
+		audit(c, auth.AUDIT_TOTP_RESET, auth.AUDIT_RESULT_REJECTED, FormValue(c, "username"))
+		return c.String(403, "you don't have admin auth")
+	}
+	uInfo, err := formUser(c)
+	if uInfo == nil {
+		return err
+	}
+	if err := auth.ResetTotp(uInfo.ID); err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	audit(c, auth.AUDIT_TOTP_RESET, auth.AUDIT_RESULT_OK, uInfo.ID)
+	return c.String(200, "OK")
+}
@@ -0,0 +1,21 @@
+package route
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func TestUserTotpEnrollDigest(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest("POST", "/user/totp/enroll", nil), rec)
+	SetLoginUser(c, "carl")
+	if err := UserTotpEnroll(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != 403 || !strings.Contains(rec.Body.String(), "session or oidc login mode") {
+		t.Fatalf("expect 403 of the digest login mode, but: %d %s", rec.Code, rec.Body.String())
+	}
+}
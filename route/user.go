@@ -1,9 +1,11 @@
 package route
 
 import (
+	"fmt"
+	"strconv"
+
 	"github.com/gwaycc/mdoc/tools/auth"
 
-	httpauth "github.com/abbot/go-http-auth"
 	"github.com/gwaylib/errors"
 	"github.com/gwaylib/eweb"
 	"github.com/gwaylib/log"
@@ -14,35 +16,68 @@ func init() {
 	e := eweb.Default()
 	e.POST("/user/add", UserAdd)
 	e.POST("/user/pwd/reset", UserPwdReset)
+	e.POST("/user/pwd/change", UserPwdChange)
+	e.POST("/user/list", UserList)
+	e.POST("/user/del", UserDel)
+	e.POST("/user/disable", UserDisable)
+	e.POST("/user/enable", UserEnable)
+	e.POST("/user/info/update", UserInfoUpdate)
+	e.POST("/user/kind/update", UserKindUpdate)
+	e.POST("/user/export", UserExport)
 }
 
 func isAdminLogin(c echo.Context) bool {
 	// checksum admin auth
-	authParams := httpauth.DigestAuthParams(c.Request().Header.Get("Authorization"))
-	if authParams == nil {
+	username := GetLoginUser(c)
+	if len(username) == 0 {
+		return false
+	}
+	if token := GetLoginToken(c); token != nil && !token.HasScope(auth.TOKEN_SCOPE_ADMIN) {
 		return false
 	}
-	admin, err := auth.GetUser(authParams["username"])
+	admin, err := auth.GetUser(username)
 	if err != nil {
 		if !errors.ErrNoData.Equal(err) {
 			log.Warn(errors.As(err))
 		}
 		return false
 	}
-	if admin.Kind != auth.USER_KIND_ADMIN {
+	if admin.Kind == auth.USER_KIND_ADMIN {
+		return true
+	}
+	adminRole, err := auth.HasAdminRole(username)
+	if err != nil {
+		log.Warn(errors.As(err))
 		return false
 	}
-	return true
+	return adminRole
 }
 
 func UserAdd(c echo.Context) error {
 	if !isAdminLogin(c) {
+		audit(c, auth.AUDIT_USER_ADD, auth.AUDIT_RESULT_REJECTED, FormValue(c, "username"))
 		return c.String(403, "you don't have admin auth")
 	}
 
 	username := FormValue(c, "username")
 	passwd := FormValue(c, "passwd")
+	plainPasswd := FormValue(c, "plain_passwd")
 	nickName := FormValue(c, "nickname")
+	uInfo := &auth.UserInfo{
+		ID:       username,
+		Passwd:   passwd,
+		NickName: nickName,
+	}
+	if len(plainPasswd) > 0 {
+		if err := uInfo.SetPlainPasswd(auth.GetRealm(), plainPasswd); err != nil {
+			log.Warn(errors.As(err))
+			return c.String(500, "System interval error")
+		}
+	} else if auth.DigestDisabled() {
+		return c.String(400, "Need plain password when the digest is disabled.")
+	} else if !formRealm(c) {
+		return c.String(400, fmt.Sprintf("The realm of HA1 not match, the realm is '%s'.", auth.GetRealm()))
+	}
 
 	if _, err := auth.GetUser(username); err != nil {
 		if !errors.ErrNoData.Equal(err) {
@@ -54,29 +89,293 @@ func UserAdd(c echo.Context) error {
 		return c.String(403, "User already exist.")
 	}
 
-	if err := auth.AddUser(&auth.UserInfo{
-		ID:       username,
-		Passwd:   passwd,
-		NickName: nickName,
-	}); err != nil {
+	if err := auth.AddUser(uInfo); err != nil {
 		log.Warn(errors.As(err))
 		return c.String(500, "System interval error")
 	}
 
+	audit(c, auth.AUDIT_USER_ADD, auth.AUDIT_RESULT_OK, username)
 	return c.String(200, "OK")
 }
 
 func UserPwdReset(c echo.Context) error {
 	if !isAdminLogin(c) {
+		audit(c, auth.AUDIT_PWD_RESET, auth.AUDIT_RESULT_REJECTED, FormValue(c, "username"))
 		return c.String(403, "you don't have admin auth")
 	}
 
 	username := FormValue(c, "username")
 	passwd := FormValue(c, "passwd")
-	if err := auth.ResetPwd(username, passwd); err != nil {
+	plainPasswd := FormValue(c, "plain_passwd")
+	if len(plainPasswd) > 0 {
+		if err := auth.SetPasswd(username, auth.GetRealm(), plainPasswd); err != nil {
+			log.Warn(errors.As(err))
+			return c.String(500, "System interval error")
+		}
+	} else {
+		if auth.DigestDisabled() {
+			return c.String(400, "Need plain password when the digest is disabled.")
+		}
+		if !formRealm(c) {
+			return c.String(400, fmt.Sprintf("The realm of HA1 not match, the realm is '%s'.", auth.GetRealm()))
+		}
+		if err := auth.ResetPwd(username, passwd); err != nil {
+			log.Warn(errors.As(err))
+			return c.String(500, "System interval error")
+		}
+	}
+	// the sessions of the old password should login again.
+	if err := auth.DelUserSessions(username); err != nil {
+		log.Warn(errors.As(err))
+	}
+	auth.DelAuthCache(username)
+	audit(c, auth.AUDIT_PWD_RESET, auth.AUDIT_RESULT_OK, username)
+	return c.String(200, "OK")
+}
+
+// return true if the 'realm' of the HA1 in form is the current realm,
+// the old clients without the 'realm' made the HA1 in the REALM.
+func formRealm(c echo.Context) bool {
+	realm := auth.REALM
+	if HasFormValue(c, "realm") {
+		realm = FormValue(c, "realm")
+	}
+	return realm == auth.GetRealm()
+}
+
+// return true if the user is the last enabled admin.
+func isLastAdmin(uInfo *auth.UserInfo) (bool, error) {
+	if uInfo.Kind != auth.USER_KIND_ADMIN || uInfo.Disabled {
+		return false, nil
+	}
+	count, err := auth.CountAdmin()
+	if err != nil {
+		return false, errors.As(err)
+	}
+	return count <= 1, nil
+}
+
+// get the user of the form, and reply the error if failed.
+func formUser(c echo.Context) (*auth.UserInfo, error) {
+	username := FormValue(c, "username")
+	uInfo, err := auth.GetUser(username)
+	if err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return nil, c.String(404, "User not found.")
+		}
+		log.Warn(errors.As(err))
+		return nil, c.String(500, "System interval error")
+	}
+	return uInfo, nil
+}
+
+// UserPwdChange changes the password of the login user, the old password is required.
+func UserPwdChange(c echo.Context) error {
+	username := GetLoginUser(c)
+	if len(username) == 0 {
+		return c.String(401, "Need login.")
+	}
+
+	oldPasswd := FormValue(c, "old_passwd")
+	passwd := FormValue(c, "passwd")
+	ok, err := auth.CheckPasswdLimited(c.Request(), username, auth.GetRealm(), oldPasswd)
+	if err != nil {
+		if auth.ErrReject.Equal(err) {
+			log.Info(errors.As(err, GetClientIp(c)))
+			audit(c, auth.AUDIT_PWD_CHANGE, auth.AUDIT_RESULT_REJECTED, "")
+			return c.String(403, auth.ErrReject.Code())
+		}
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	if !ok {
+		audit(c, auth.AUDIT_PWD_CHANGE, auth.AUDIT_RESULT_FAILED, "")
+		return c.String(403, "Old password not match.")
+	}
+
+	if err := auth.CheckPasswdPolicy(username, auth.GetRealm(), passwd); err != nil {
+		policy := auth.GetPasswdPolicy()
+		switch {
+		case auth.ErrPasswdTooShort.Equal(err):
+			return c.String(400, fmt.Sprintf("Password need at least %d characters.", policy.MinLen))
+		case auth.ErrPasswdReused.Equal(err):
+			return c.String(400, fmt.Sprintf("Password can not be the same as the last %d passwords.", policy.History))
+		default:
+			log.Warn(errors.As(err))
+			return c.String(500, "System interval error")
+		}
+	}
+
+	if err := auth.SetPasswd(username, auth.GetRealm(), passwd); err != nil {
 		log.Warn(errors.As(err))
 		return c.String(500, "System interval error")
 	}
+	// the other sessions should login again with the new password.
+	if sessionAuth != nil {
+		if err := sessionAuth.ResetSessions(c.Response().Writer, c.Request(), username); err != nil {
+			log.Warn(errors.As(err))
+		}
+	} else if err := auth.DelUserSessions(username); err != nil {
+		log.Warn(errors.As(err))
+	}
 	auth.DelAuthCache(username)
+	audit(c, auth.AUDIT_PWD_CHANGE, auth.AUDIT_RESULT_OK, "")
+	return c.String(200, "OK")
+}
+
+func UserList(c echo.Context) error {
+	if !isAdminLogin(c) {
+		return c.String(403, "you don't have admin auth")
+	}
+	users, err := auth.ListUsers()
+	if err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	return c.JSON(200, users)
+}
+
+// UserExport returns the users for exporting, the HA1 is included when the 'secrets' is "1".
+func UserExport(c echo.Context) error {
+	secrets := FormValue(c, "secrets") == "1"
+	target := ""
+	if secrets {
+		target = "secrets"
+	}
+	if !isAdminLogin(c) {
+		audit(c, auth.AUDIT_USER_EXPORT, auth.AUDIT_RESULT_REJECTED, target)
+		return c.String(403, "you don't have admin auth")
+	}
+	users, err := auth.ExportUsers(secrets)
+	if err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	audit(c, auth.AUDIT_USER_EXPORT, auth.AUDIT_RESULT_OK, target)
+	return c.JSON(200, users)
+}
+
+func UserDel(c echo.Context) error {
+	if !isAdminLogin(c) {
+		audit(c, auth.AUDIT_USER_DEL, auth.AUDIT_RESULT_REJECTED, FormValue(c, "username"))
+		return c.String(403, "you don't have admin auth")
+	}
+	uInfo, err := formUser(c)
+	if uInfo == nil {
+		return err
+	}
+	if last, err := isLastAdmin(uInfo); err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	} else if last {
+		return c.String(403, "Can not delete the last admin.")
+	}
+
+	if err := auth.DelUser(uInfo.ID); err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	auth.DelAuthCache(uInfo.ID)
+	audit(c, auth.AUDIT_USER_DEL, auth.AUDIT_RESULT_OK, uInfo.ID)
+	return c.String(200, "OK")
+}
+
+func userDisable(c echo.Context, disabled bool) error {
+	action := auth.AUDIT_USER_ENABLE
+	if disabled {
+		action = auth.AUDIT_USER_DISABLE
+	}
+	if !isAdminLogin(c) {
+		audit(c, action, auth.AUDIT_RESULT_REJECTED, FormValue(c, "username"))
+		return c.String(403, "you don't have admin auth")
+	}
+	uInfo, err := formUser(c)
+	if uInfo == nil {
+		return err
+	}
+	if disabled {
+		if last, err := isLastAdmin(uInfo); err != nil {
+			log.Warn(errors.As(err))
+			return c.String(500, "System interval error")
+		} else if last {
+			return c.String(403, "Can not disable the last admin.")
+		}
+	}
+
+	if err := auth.DisableUser(uInfo.ID, disabled); err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	if disabled {
+		if err := auth.DelUserSessions(uInfo.ID); err != nil {
+			log.Warn(errors.As(err))
+		}
+	}
+	auth.DelAuthCache(uInfo.ID)
+	audit(c, action, auth.AUDIT_RESULT_OK, uInfo.ID)
+	return c.String(200, "OK")
+}
+
+func UserDisable(c echo.Context) error {
+	return userDisable(c, true)
+}
+
+func UserEnable(c echo.Context) error {
+	return userDisable(c, false)
+}
+
+// Only the fields in the form will be updated.
+func UserInfoUpdate(c echo.Context) error {
+	if !isAdminLogin(c) {
+		audit(c, auth.AUDIT_USER_UPDATE, auth.AUDIT_RESULT_REJECTED, FormValue(c, "username"))
+		return c.String(403, "you don't have admin auth")
+	}
+	uInfo, err := formUser(c)
+	if uInfo == nil {
+		return err
+	}
+
+	nickName, memo := uInfo.NickName, uInfo.Memo
+	if HasFormValue(c, "nickname") {
+		nickName = FormValue(c, "nickname")
+	}
+	if HasFormValue(c, "memo") {
+		memo = FormValue(c, "memo")
+	}
+	if err := auth.UpdateUserInfo(uInfo.ID, nickName, memo); err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	audit(c, auth.AUDIT_USER_UPDATE, auth.AUDIT_RESULT_OK, uInfo.ID)
+	return c.String(200, "OK")
+}
+
+func UserKindUpdate(c echo.Context) error {
+	if !isAdminLogin(c) {
+		audit(c, auth.AUDIT_USER_KIND, auth.AUDIT_RESULT_REJECTED, FormValue(c, "username"))
+		return c.String(403, "you don't have admin auth")
+	}
+	uInfo, err := formUser(c)
+	if uInfo == nil {
+		return err
+	}
+
+	kind, err := strconv.Atoi(FormValue(c, "kind"))
+	if err != nil || (kind != auth.USER_KIND_ADMIN && kind != auth.USER_KIND_COMMON) {
+		return c.String(400, "Unknow kind.")
+	}
+	if kind != auth.USER_KIND_ADMIN {
+		if last, err := isLastAdmin(uInfo); err != nil {
+			log.Warn(errors.As(err))
+			return c.String(500, "System interval error")
+		} else if last {
+			return c.String(403, "Can not demote the last admin.")
+		}
+	}
+	if err := auth.UpdateUserKind(uInfo.ID, kind); err != nil {
+		log.Warn(errors.As(err))
+		return c.String(500, "System interval error")
+	}
+	audit(c, auth.AUDIT_USER_KIND, auth.AUDIT_RESULT_OK, uInfo.ID)
 	return c.String(200, "OK")
 }
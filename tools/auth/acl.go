@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/csv"
+	"path"
+	"strings"
+
+	"github.com/gwaylib/errors"
+)
+
+const (
+	ACL_PERM_READ  = 1
+	ACL_PERM_WRITE = 2
+
+	// the subject for all the login users.
+	ACL_SUBJECT_ALL = "*"
+	// the prefix of subject for the group.
+	ACL_GROUP_PREFIX = "@"
+)
+
+var (
+	ErrAuthAcl = errors.New("Invalid acl")
+	ErrAclPerm = errors.New("Unknow acl permission")
+)
+
+type AclRule struct {
+	Prefix
+	Perm     int      // ACL_PERM_READ | ACL_PERM_WRITE
+	Subjects []string // username, "@group" or "*"
+}
+
+// AuthAcl controls the access of the login users by the path,
+// the most specific(longest) path of rules will be used,
+// and all login users are allowed when there is no rule matched.
+type AuthAcl struct {
+	rules []AclRule
+}
+
+func parseAclPerm(perm string) (int, error) {
+	switch strings.ToLower(perm) {
+	case "r":
+		return ACL_PERM_READ, nil
+	case "w":
+		return ACL_PERM_WRITE, nil
+	case "rw", "wr":
+		return ACL_PERM_READ | ACL_PERM_WRITE, nil
+	}
+	return 0, ErrAclPerm.As(perm)
+}
+
+// The format of the acl file is:
+//
+// # path, permission(r|w|rw), subjects(username, @group or *)...
+// /markdown/arch, rw, admin, @arch
+// /markdown/arch, r, @dev
+// /markdown/*.md, r, *
+func ParseAuthAcl(data []byte) *AuthAcl {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	record, err := r.ReadAll()
+	if err != nil {
+		panic(err)
+	}
+	rules := []AclRule{}
+	for i, r := range record {
+		if len(r) == 0 || len(strings.TrimSpace(r[0])) == 0 {
+			continue
+		}
+		if len(r) < 3 {
+			panic(ErrAuthAcl.As(i+1, "need path, permission and subjects"))
+		}
+		path := strings.TrimSpace(r[0])
+		perm, err := parseAclPerm(strings.TrimSpace(r[1]))
+		if err != nil {
+			panic(errors.As(err, i+1))
+		}
+		subjects := []string{}
+		for _, s := range r[2:] {
+			if s = strings.TrimSpace(s); len(s) > 0 {
+				subjects = append(subjects, s)
+			}
+		}
+		rules = append(rules, AclRule{
+			Prefix:   Prefix{Path: path, Regexp: strings.Contains(path, "*")},
+			Perm:     perm,
+			Subjects: subjects,
+		})
+	}
+	return NewAuthAcl(rules)
+}
+
+// CleanPath returns the path as the static handler serves, so the rules can not be bypassed by "//", "/./" or "/../".
+func CleanPath(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return path.Clean(p)
+}
+
+// MatchDir is the Match of the path, but the prefix only matches at the boundary of "/",
+// e.g. "/markdown/arch" matches "/markdown/arch/arch.md" but not "/markdown/architecture".
+func (p *Prefix) MatchDir(path string) bool {
+	if p.Regexp {
+		return p.Match(path)
+	}
+	dir := strings.TrimSuffix(p.Path, "/")
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+func NewAuthAcl(rules []AclRule) *AuthAcl {
+	return &AuthAcl{rules: rules}
+}
+
+// Allow returns true if the user or the groups of user have the permission of the path,
+// the path is cleaned by CleanPath before matching.
+func (a *AuthAcl) Allow(uri, username string, groups []string, perm int) bool {
+	uri = CleanPath(uri)
+	matched := []AclRule{}
+	maxLen := -1
+	for _, r := range a.rules {
+		if !r.MatchDir(uri) {
+			continue
+		}
+		switch {
+		case len(r.Path) > maxLen:
+			maxLen = len(r.Path)
+			matched = []AclRule{r}
+		case len(r.Path) == maxLen:
+			matched = append(matched, r)
+		}
+	}
+	if len(matched) == 0 {
+		return true
+	}
+
+	for _, r := range matched {
+		if r.Perm&perm != perm {
+			continue
+		}
+		for _, s := range r.Subjects {
+			if s == ACL_SUBJECT_ALL || s == username {
+				return true
+			}
+			if !strings.HasPrefix(s, ACL_GROUP_PREFIX) {
+				continue
+			}
+			for _, g := range groups {
+				if s[len(ACL_GROUP_PREFIX):] == g {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// AclPerm returns the permission needed by the http method.
+func AclPerm(method string) int {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return ACL_PERM_READ
+	}
+	return ACL_PERM_WRITE
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/gwaylib/errors"
+)
+
+func TestParseAuthAcl(t *testing.T) {
+	ParseAuthAcl(nil)
+
+	in := []byte(`
+# path, permission, subjects
+/markdown/arch, rw, admin, @arch
+/markdown/arch, r, bob
+/markdown/*.md, r, *
+`)
+	acl := ParseAuthAcl(in)
+	cases := []struct {
+		path     string
+		username string
+		groups   []string
+		perm     int
+		expect   bool
+	}{
+		{"/markdown/arch/arch.md", "admin", nil, ACL_PERM_WRITE, true},
+		{"/markdown/arch/arch.md", "alice", []string{"arch"}, ACL_PERM_WRITE, true},
+		{"/markdown/arch/arch.md", "bob", nil, ACL_PERM_READ, true},
+		{"/markdown/arch/arch.md", "bob", nil, ACL_PERM_WRITE, false},
+		{"/markdown/arch/arch.md", "carol", []string{"dev"}, ACL_PERM_READ, false},
+		{"/markdown/README.md", "carol", nil, ACL_PERM_READ, true},
+		{"/markdown/README.md", "carol", nil, ACL_PERM_WRITE, false},
+		{"/markdown/doc/doc.md", "carol", nil, ACL_PERM_WRITE, true}, // no rule matched
+		// the rules can not be bypassed by the uncleaned path
+		{"/markdown//arch/arch.md", "carol", nil, ACL_PERM_READ, false},
+		{"/markdown/./arch/arch.md", "carol", nil, ACL_PERM_READ, false},
+		{"/markdown/doc/../arch/arch.md", "carol", nil, ACL_PERM_READ, false},
+		{"/markdown/arch/", "carol", nil, ACL_PERM_READ, false},
+		{"/markdown/arch", "carol", nil, ACL_PERM_READ, false},
+		// the prefix only matches at the boundary of "/"
+		{"/markdown/architecture/a.md", "carol", nil, ACL_PERM_WRITE, true},
+		{"/markdown/archive.md", "carol", nil, ACL_PERM_READ, true},
+	}
+	for i, c := range cases {
+		if acl.Allow(c.path, c.username, c.groups, c.perm) != c.expect {
+			t.Fatalf("case %d: expect %t, %+v", i, c.expect, c)
+		}
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	for in, expect := range map[string]string{
+		"":                        "/",
+		"/":                       "/",
+		"markdown/a.md":           "/markdown/a.md",
+		"/markdown//arch/a.md":    "/markdown/arch/a.md",
+		"/markdown/./arch/a.md":   "/markdown/arch/a.md",
+		"/markdown/../../etc/pwd": "/etc/pwd",
+		"/markdown/arch/":         "/markdown/arch",
+	} {
+		if out := CleanPath(in); out != expect {
+			t.Fatalf("expect %q of %q, but: %q", expect, in, out)
+		}
+	}
+}
+
+func TestParseAuthAclError(t *testing.T) {
+	for _, c := range []struct {
+		in     string
+		expect errors.Error
+	}{
+		{"/markdown/arch, x, admin", ErrAclPerm},
+		{"/markdown/arch, r", ErrAuthAcl},
+	} {
+		func() {
+			defer func() {
+				err, _ := recover().(error)
+				if !c.expect.Equal(err) {
+					t.Fatalf("expect %v of %q, but: %v", c.expect, c.in, err)
+				}
+			}()
+			ParseAuthAcl([]byte(c.in))
+		}()
+	}
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gwaylib/database"
+	"github.com/gwaylib/errors"
+	"github.com/gwaylib/log"
+)
+
+const (
+	AUDIT_LOGIN         = "login"
+	AUDIT_LOGOUT        = "logout"
+	AUDIT_USER_ADD      = "user_add"
+	AUDIT_USER_DEL      = "user_del"
+	AUDIT_USER_DISABLE  = "user_disable"
+	AUDIT_USER_ENABLE   = "user_enable"
+	AUDIT_USER_UPDATE   = "user_update"
+	AUDIT_USER_KIND     = "user_kind"
+	AUDIT_USER_EXPORT   = "user_export"
+	AUDIT_PWD_RESET     = "pwd_reset"
+	AUDIT_PWD_CHANGE    = "pwd_change"
+	AUDIT_GROUP_ADD     = "group_add"
+	AUDIT_GROUP_DEL     = "group_del"
+	AUDIT_GROUP_ROLE    = "group_role"
+	AUDIT_GROUP_JOIN    = "group_join"
+	AUDIT_GROUP_LEAVE   = "group_leave"
+	AUDIT_TOTP_ENABLE   = "totp_enable"
+	AUDIT_TOTP_RESET    = "totp_reset"
+	AUDIT_TOKEN_CREATE  = "token_create"
+	AUDIT_TOKEN_REVOKE  = "token_revoke"
+	AUDIT_INVITE_CREATE = "invite_create"
+	AUDIT_INVITE_REVOKE = "invite_revoke"
+	AUDIT_INVITE_ACCEPT = "invite_accept"
+	AUDIT_UNLOCK        = "unlock"
+	AUDIT_REALM         = "realm_change"
+
+	AUDIT_RESULT_OK       = "ok"
+	AUDIT_RESULT_FAILED   = "failed"   // the password or the code is incorrect
+	AUDIT_RESULT_REJECTED = "rejected" // locked or no permission
+
+	_AUDIT_PURGE_INTERVAL = time.Hour
+)
+
+// Audit is a record of the authentication or the user management, the records are append only.
+type Audit struct {
+	ID        int64  `db:"id"`
+	CreatedAt int64  `db:"created_at"` // unix seconds
+	UserID    string `db:"user_id"`    // the operator
+	Ip        string `db:"ip"`
+	Action    string `db:"action"`
+	Result    string `db:"result"`
+	Target    string `db:"target"` // the target user or group of the action
+	Memo      string `db:"memo"`
+}
+
+var (
+	auditRetention time.Duration
+	auditLastPurge time.Time
+	auditLk        sync.Mutex
+)
+
+// SetAuditRetention sets how long the audit records are kept, zero for keeping forever.
+func SetAuditRetention(d time.Duration) {
+	auditLk.Lock()
+	defer auditLk.Unlock()
+	auditRetention = d
+}
+
+// purge the expired records at most once an interval.
+func purgeAudits(now time.Time) error {
+	auditLk.Lock()
+	if auditRetention <= 0 || now.Sub(auditLastPurge) < _AUDIT_PURGE_INTERVAL {
+		auditLk.Unlock()
+		return nil
+	}
+	auditLastPurge = now
+	before := now.Add(-auditRetention).Unix()
+	auditLk.Unlock()
+
+	db := GetDB()
+	if _, err := db.Exec("DELETE FROM auth_audit WHERE created_at<?", before); err != nil {
+		return errors.As(err)
+	}
+	return nil
+}
+
+// AddAudit appends a audit record, the CreatedAt is set when it is zero.
+func AddAudit(a *Audit) error {
+	now := time.Now()
+	if a.CreatedAt == 0 {
+		a.CreatedAt = now.Unix()
+	}
+	if err := purgeAudits(now); err != nil {
+		return errors.As(err)
+	}
+	db := GetDB()
+	result, err := db.Exec(
+		"INSERT INTO auth_audit(created_at,user_id,ip,action,result,target,memo)VALUES(?,?,?,?,?,?,?)",
+		a.CreatedAt, a.UserID, a.Ip, a.Action, a.Result, a.Target, a.Memo,
+	)
+	if err != nil {
+		return errors.As(err, *a)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return errors.As(err)
+	}
+	a.ID = id
+	return nil
+}
+
+// audit the request, the error is only logged since the audit should not break the authentication.
+func auditReq(req *http.Request, username, action, result, target string) {
+	if err := AddAudit(&Audit{
+		UserID: username,
+		Ip:     ClientIp(req),
+		Action: action,
+		Result: result,
+		Target: target,
+	}); err != nil {
+		log.Warn(errors.As(err))
+	}
+}
+
+type AuditQuery struct {
+	UserID string // the operator or the target
+	Action string
+	Since  int64 // unix seconds, zero for no limit
+	Until  int64 // unix seconds, zero for no limit
+	Limit  int   // zero for no limit
+	Offset int
+}
+
+// ListAudits returns the records matched the query, the newest is the first.
+func ListAudits(q *AuditQuery) ([]Audit, error) {
+	where := []string{}
+	args := []interface{}{}
+	if len(q.UserID) > 0 {
+		where = append(where, "(user_id=? OR target=?)")
+		args = append(args, q.UserID, q.UserID)
+	}
+	if len(q.Action) > 0 {
+		where = append(where, "action=?")
+		args = append(args, q.Action)
+	}
+	if q.Since > 0 {
+		where = append(where, "created_at>=?")
+		args = append(args, q.Since)
+	}
+	if q.Until > 0 {
+		where = append(where, "created_at<?")
+		args = append(args, q.Until)
+	}
+	qsql := "SELECT id,created_at,user_id,ip,action,result,target,memo FROM auth_audit"
+	if len(where) > 0 {
+		qsql += " WHERE " + strings.Join(where, " AND ")
+	}
+	qsql += " ORDER BY id DESC"
+	if q.Limit > 0 {
+		qsql += " LIMIT ? OFFSET ?"
+		args = append(args, q.Limit, q.Offset)
+	}
+
+	result := []Audit{}
+	db := GetDB()
+	if err := database.QueryStructs(db, &result, qsql, args...); err != nil {
+		return nil, errors.As(err, *q)
+	}
+	return result, nil
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAudit(t *testing.T) {
+	username := fmt.Sprintf("audit_%d", time.Now().UnixNano())
+	if err := AddUser(&UserInfo{ID: username, Passwd: HashPasswd(username, REALM, "hello")}); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := NewSessionAuth(REALM, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/login", nil)
+	req.RemoteAddr = "192.0.2.20:1234"
+	if err := sa.Login(httptest.NewRecorder(), req, username, "bad", "", "", ""); !ErrNeedPwd.Equal(err) {
+		t.Fatalf("expect ErrNeedPwd, but: %v", err)
+	}
+	if err := sa.Login(httptest.NewRecorder(), req, username, "hello", "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddAudit(&Audit{UserID: "admin", Action: AUDIT_USER_DEL, Result: AUDIT_RESULT_OK, Target: username}); err != nil {
+		t.Fatal(err)
+	}
+
+	audits, err := ListAudits(&AuditQuery{UserID: username})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audits) != 3 {
+		t.Fatalf("unexpect audits: %+v", audits)
+	}
+	// the newest is the first.
+	if audits[0].Action != AUDIT_USER_DEL || audits[1].Result != AUDIT_RESULT_OK || audits[2].Result != AUDIT_RESULT_FAILED {
+		t.Fatalf("unexpect audits: %+v", audits)
+	}
+	if audits[2].Ip != "192.0.2.20" {
+		t.Fatalf("unexpect ip: %s", audits[2].Ip)
+	}
+	audits, err = ListAudits(&AuditQuery{UserID: username, Action: AUDIT_LOGIN, Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audits) != 1 || audits[0].Result != AUDIT_RESULT_FAILED {
+		t.Fatalf("unexpect audits: %+v", audits)
+	}
+	audits, err = ListAudits(&AuditQuery{UserID: username, Until: time.Now().Add(-time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audits) != 0 {
+		t.Fatalf("unexpect audits: %+v", audits)
+	}
+
+	// append only
+	if _, err := GetDB().Exec("UPDATE auth_audit SET result=? WHERE user_id=?", AUDIT_RESULT_OK, username); err == nil {
+		t.Fatal("expect the update is aborted")
+	}
+}
+
+func TestAuditRetention(t *testing.T) {
+	defer SetAuditRetention(0)
+	SetAuditRetention(time.Hour)
+	auditLastPurge = time.Time{}
+
+	old := &Audit{UserID: "audit_retention", Action: AUDIT_LOGIN, Result: AUDIT_RESULT_OK, CreatedAt: time.Now().Add(-2 * time.Hour).Unix()}
+	if err := AddAudit(old); err != nil {
+		t.Fatal(err)
+	}
+	// purged by the next record.
+	auditLastPurge = time.Time{}
+	if err := AddAudit(&Audit{UserID: "audit_retention", Action: AUDIT_LOGIN, Result: AUDIT_RESULT_OK}); err != nil {
+		t.Fatal(err)
+	}
+	audits, err := ListAudits(&AuditQuery{UserID: "audit_retention"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audits) != 1 || audits[0].ID == old.ID {
+		t.Fatalf("unexpect audits: %+v", audits)
+	}
+}
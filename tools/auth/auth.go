@@ -1,29 +1,87 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	httpauth "github.com/abbot/go-http-auth"
+	"github.com/gwaycc/mdoc/client"
 	"github.com/gwaycc/mdoc/tools/cache"
 	"github.com/gwaylib/errors"
+	"github.com/gwaylib/log"
 )
 
 const (
 	REALM = "mdoc"
 )
 
+// the digest algorithms of RFC 7616.
+const (
+	DIGEST_MD5        = "MD5"
+	DIGEST_SHA256     = "SHA-256"
+	DIGEST_SHA512_256 = "SHA-512-256"
+)
+
 var (
-	ErrNeedLogin = errors.New("Need login")
-	ErrNeedPwd   = errors.New("Passwd failed")
-	ErrReject    = errors.New("Too many login failures")
+	ErrNeedLogin       = errors.New("Need login")
+	ErrNeedPwd         = errors.New("Passwd failed")
+	ErrReject          = errors.New("Too many login failures")
+	ErrDigestAlgorithm = errors.New("Unsupported digest algorithm")
 )
 
+// the supported digest algorithms, the strongest is first.
+var digestAlgorithms = []string{DIGEST_SHA512_256, DIGEST_SHA256, DIGEST_MD5}
+
+// DigestAlgorithms returns the supported digest algorithms, the strongest is first.
+func DigestAlgorithms() []string {
+	return append([]string{}, digestAlgorithms...)
+}
+
+// ParseDigestAlgorithms parses the comma separated algorithms, the order is kept,
+// ErrDigestAlgorithm will be returned if one of them is not supported.
+func ParseDigestAlgorithms(value string) ([]string, error) {
+	result := []string{}
+	for _, field := range strings.Split(value, ",") {
+		algorithm := strings.ToUpper(strings.TrimSpace(field))
+		if len(algorithm) == 0 {
+			continue
+		}
+		if len(digestH(algorithm, "")) == 0 {
+			return nil, ErrDigestAlgorithm.As(algorithm)
+		}
+		result = append(result, algorithm)
+	}
+	if len(result) == 0 {
+		return nil, ErrDigestAlgorithm.As("need algorithm")
+	}
+	return result, nil
+}
+
+// digestH is the H function of the algorithm, empty will be returned if the algorithm is not supported.
+func digestH(algorithm, data string) string {
+	switch algorithm {
+	case DIGEST_MD5:
+		return httpauth.H(data)
+	case DIGEST_SHA256:
+		sum := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(sum[:])
+	case DIGEST_SHA512_256:
+		sum := sha512.Sum512_256([]byte(data))
+		return hex.EncodeToString(sum[:])
+	}
+	return ""
+}
+
 // TODO: store to redis if need.
 var (
 	authCache = cache.NewMemoryCache(true)
@@ -31,9 +89,10 @@ var (
 
 const (
 	_AUTH_TOKEN_HEAD   = "token_%s"
-	_AUTH_LIMIT_HEAD   = "limit_%s"
-	_AUTH_LIMIT_TIMES  = 4 // 4+1 times
+	_AUTH_DIGEST_HEAD  = "digest_%s:%s" // algorithm, username
 	_AUTH_EXPIRES_DAYS = 7
+
+	_DIGEST_PURGE_INTERVAL = time.Hour
 )
 
 func UpdateAuthCache(username, token string) {
@@ -47,50 +106,46 @@ func GetAuthCache(username string) (string, bool) {
 	}
 	return cacheToken.(string), true
 }
-func DelAuthCache(username string) {
-	authCache.Delete(fmt.Sprintf(_AUTH_TOKEN_HEAD, username))
-}
 
-func updateAuthLimit(key string, value int) {
-	waitTime := int64(60 * 30)
-	if value > _AUTH_LIMIT_TIMES {
-		waitTime = int64(60 * 30 * (value - _AUTH_LIMIT_TIMES))
+// the cache key of the HA1, the MD5 is kept in the key of UpdateAuthCache.
+func digestCacheKey(username, algorithm string) string {
+	if algorithm == DIGEST_MD5 {
+		return fmt.Sprintf(_AUTH_TOKEN_HEAD, username)
 	}
-	authCache.Put(fmt.Sprintf(_AUTH_LIMIT_HEAD, key), value, waitTime)
+	return fmt.Sprintf(_AUTH_DIGEST_HEAD, algorithm, username)
 }
 
-func getAuthLimit(key string) int {
-	val := authCache.Get(fmt.Sprintf(_AUTH_LIMIT_HEAD, key))
-	if val == nil {
-		return 0
-	}
-	return val.(int)
-}
-
-func realIp(r *http.Request) []string {
-	ips := []string{}
-	addrInfo := strings.Split(r.RemoteAddr, ":")
-	if len(addrInfo) > 0 {
-		ips = append(ips, addrInfo[0])
-	}
-
-	for _, header := range r.Header["X-Forwarded-For"] {
-		addrInfo := strings.Split(header, ":")
-		if len(addrInfo) > 0 {
-			ips = append(ips, addrInfo[0])
-		}
+func DelAuthCache(username string) {
+	for _, algorithm := range digestAlgorithms {
+		authCache.Delete(digestCacheKey(username, algorithm))
 	}
-	return ips
+	authCache.Delete(fmt.Sprintf(_LDAP_CACHE_HEAD, username))
 }
 
 func HashPasswd(user, realm, passwd string) string {
 	return httpauth.H(fmt.Sprintf("%s:%s:%s", user, realm, passwd))
 }
 
+// HashPasswdAlg is same as HashPasswd, but hash with the digest algorithm.
+func HashPasswdAlg(algorithm, user, realm, passwd string) string {
+	return digestH(algorithm, fmt.Sprintf("%s:%s:%s", user, realm, passwd))
+}
+
+// DigestSecretProvider returns the HA1 of the digest algorithm, empty if not found.
+type DigestSecretProvider func(user, realm, algorithm string) string
+
 type DigestAuth struct {
-	*httpauth.DigestAuth
+	Realm            string
+	Secrets          httpauth.SecretProvider
+	PlainTextSecrets bool
 
-	mutex sync.Mutex
+	// AlgSecrets is used instead of Secrets when it is not nil.
+	AlgSecrets DigestSecretProvider
+
+	algorithms []string
+	store      NonceStore
+	lastPurge  int64
+	mutex      sync.Mutex
 }
 
 // About SecretProvider
@@ -100,18 +155,263 @@ type DigestAuth struct {
 //
 // hash mode
 // provider need return H(user + ":" + realm + ":" + passwd)
-func NewDigestAuth(realm string, plainTextSecret bool, secret httpauth.SecretProvider) *DigestAuth {
-	da := httpauth.NewDigestAuthenticator(realm, secret)
-	da.PlainTextSecrets = plainTextSecret
+//
+// # About NonceStore
+//
+// The opaque and the nonces of clients are kept by the store,
+// using NewDBNonceStore() to keep the login of clients after the server restarted.
+// If the store is nil, NewMemNonceStore() will be used.
+//
+// # About algorithms
+//
+// All the algorithms are offered in the plain text mode, and only MD5 is offered in the hash mode,
+// set the AlgSecrets and call SetAlgorithms to offer the others.
+func NewDigestAuth(realm string, plainTextSecret bool, secret httpauth.SecretProvider, store NonceStore) *DigestAuth {
+	if store == nil {
+		store = NewMemNonceStore()
+	}
+	algorithms := []string{DIGEST_MD5}
+	if plainTextSecret {
+		algorithms = DigestAlgorithms()
+	}
 	return &DigestAuth{
-		DigestAuth: da,
+		Realm:            realm,
+		Secrets:          secret,
+		PlainTextSecrets: plainTextSecret,
+		algorithms:       algorithms,
+		store:            store,
+	}
+}
+
+// SetAlgorithms sets the algorithms of the challenges in order, the client will use the first one it supports.
+func (da *DigestAuth) SetAlgorithms(algorithms ...string) error {
+	if len(algorithms) == 0 {
+		return ErrDigestAlgorithm.As("need algorithm")
+	}
+	for _, algorithm := range algorithms {
+		if len(digestH(algorithm, "")) == 0 {
+			return ErrDigestAlgorithm.As(algorithm)
+		}
+	}
+	da.mutex.Lock()
+	defer da.mutex.Unlock()
+	da.algorithms = append([]string{}, algorithms...)
+	return nil
+}
+
+// return the HA1 of user with the algorithm, empty if not found.
+func (da *DigestAuth) secret(user, algorithm string) string {
+	if da.AlgSecrets != nil {
+		return da.AlgSecrets(user, da.Realm, algorithm)
+	}
+	secret := da.Secrets(user, da.Realm)
+	if len(secret) == 0 {
+		return ""
+	}
+	if da.PlainTextSecrets {
+		return HashPasswdAlg(algorithm, user, da.Realm, secret)
+	}
+	if algorithm != DIGEST_MD5 {
+		return ""
+	}
+	return secret
+}
+
+// return the offered algorithms that the user has the HA1.
+func (da *DigestAuth) userAlgorithms(user string) []string {
+	result := []string{}
+	for _, algorithm := range da.algorithms {
+		if len(da.secret(user, algorithm)) > 0 {
+			result = append(result, algorithm)
+		}
 	}
+	return result
+}
+
+func (da *DigestAuth) offered(algorithm string) bool {
+	for _, a := range da.algorithms {
+		if a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// purge the nonces that have not been used for a long time.
+func (da *DigestAuth) purge(now int64) {
+	if now-da.lastPurge < int64(_DIGEST_PURGE_INTERVAL) {
+		return
+	}
+	da.lastPurge = now
+	if err := da.store.Purge(now - int64(_AUTH_EXPIRES_DAYS*24*time.Hour)); err != nil {
+		log.Warn(errors.As(err))
+	}
+}
+
+// rebuild httpauth.DigestAuth.RequireAuth, a challenge is sent for each of the algorithms.
+//
+// If the request has the authorization of a user, the algorithms are narrowed to the HA1 of the user,
+// so the client can retry with the algorithm that the user has.
+// The "stale=true" is set if the response of the authorization is valid but the nonce is unknown or the nonce-count is replayed.
+func (da *DigestAuth) RequireAuth(w http.ResponseWriter, r *http.Request) {
+	da.mutex.Lock()
+	defer da.mutex.Unlock()
+
+	headers := httpauth.NormalHeaders
+	opaque, err := da.store.Opaque()
+	if err != nil {
+		log.Warn(errors.As(err))
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	now := time.Now()
+	da.purge(now.UnixNano())
+	secret, err := da.store.Secret()
+	if err != nil {
+		log.Warn(errors.As(err))
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	// the nonce is kept in the store at the first valid use, see checkDigest.
+	nonce := newNonce(secret, now)
+	algorithms := da.algorithms
+	staleParam := ""
+	if auth := httpauth.DigestAuthParams(r.Header.Get(headers.Authorization)); auth != nil && len(auth["username"]) > 0 {
+		if userAlgorithms := da.userAlgorithms(auth["username"]); len(userAlgorithms) > 0 {
+			algorithms = userAlgorithms
+		}
+		// the response is valid but the nonce is unknown or the nonce-count is replayed,
+		// the client can retry without asking the password.
+		if ok, _ := da.verifyResponse(r, auth); ok {
+			nc, ncErr := strconv.ParseUint(auth["nc"], 16, 64)
+			if lastNc, known, err := da.lookupNonce(auth["nonce"], now); err == nil && (!known || (ncErr == nil && nc <= lastNc)) {
+				staleParam = ", stale=true"
+			}
+		}
+	}
+	w.Header().Set("Content-Type", headers.UnauthContentType)
+	for _, algorithm := range algorithms {
+		w.Header().Add(headers.Authenticate,
+			fmt.Sprintf(`Digest realm="%s", nonce="%s", opaque="%s", algorithm="%s", qop="auth"%s`,
+				da.Realm, nonce, opaque, algorithm, staleParam))
+	}
+	w.WriteHeader(headers.UnauthCode)
+	w.Write([]byte(headers.UnauthResponse))
+}
+
+// verify the response of the authorization without the nonce count, the da.mutex should be locked.
+//
+// ErrNeedLogin will be returned if the algorithm is not offered or the user has no HA1 of the algorithm,
+// then the client can retry with the narrowed challenge.
+func (da *DigestAuth) verifyResponse(r *http.Request, auth map[string]string) (bool, error) {
+	// see httpauth.DigestAuth.CheckAuth for the broken clients.
+	if _, ok := auth["algorithm"]; !ok {
+		auth["algorithm"] = DIGEST_MD5
+	}
+	algorithm := auth["algorithm"]
+	if !da.offered(algorithm) {
+		return false, ErrNeedLogin.As("algorithm not offered", algorithm)
+	}
+	opaque, err := da.store.Opaque()
+	if err != nil {
+		return false, errors.As(err)
+	}
+	if opaque != auth["opaque"] || auth["qop"] != "auth" {
+		return false, nil
+	}
+
+	// Check if the requested URI matches auth header
+	if r.RequestURI != auth["uri"] {
+		switch u, err := url.Parse(auth["uri"]); {
+		case err != nil:
+			return false, nil
+		case r.URL == nil:
+			return false, nil
+		case len(u.Path) > len(r.URL.Path):
+			return false, nil
+		case !strings.HasPrefix(r.URL.Path, u.Path):
+			return false, nil
+		}
+	}
+
+	HA1 := da.secret(auth["username"], algorithm)
+	if len(HA1) == 0 {
+		if len(da.userAlgorithms(auth["username"])) > 0 {
+			return false, ErrNeedLogin.As("no HA1 of the algorithm", auth["username"], algorithm)
+		}
+		return false, nil
+	}
+	HA2 := digestH(algorithm, r.Method+":"+auth["uri"])
+	KD := digestH(algorithm, strings.Join([]string{HA1, auth["nonce"], auth["nc"], auth["cnonce"], auth["qop"], HA2}, ":"))
+	return subtle.ConstantTimeCompare([]byte(KD), []byte(auth["response"])) == 1, nil
+}
+
+// lookupNonce returns the last nonce-count of the nonce, known is false if the nonce is not issued by the server,
+// the nonce that has not been used is known by the signature, the da.mutex should be locked.
+func (da *DigestAuth) lookupNonce(nonce string, now time.Time) (lastNc uint64, known bool, err error) {
+	lastNc, err = da.store.GetNonce(nonce)
+	if err == nil {
+		return lastNc, true, nil
+	}
+	if !errors.ErrNoData.Equal(err) {
+		return 0, false, errors.As(err)
+	}
+	secret, err := da.store.Secret()
+	if err != nil {
+		return 0, false, errors.As(err)
+	}
+	return 0, validNonce(secret, nonce, now), nil
+}
+
+// rebuild httpauth.DigestAuth.CheckAuth with the NonceStore,
+// ok is true if the authorization of request is valid, and newNonce is true when the nonce is used the first time.
+// stale is true if the response is valid but the nonce is unknown or the nonce-count is not increased,
+// the client should retry with a new nonce.
+//
+// firstUse is called before the nonce is stored at the first use, and the nonce is not stored if it fails,
+// so the following requests of the nonce are checked by it again.
+//
+// ErrNeedLogin will be returned as verifyResponse, and the error of firstUse is returned as it is.
+func (da *DigestAuth) checkDigest(r *http.Request, auth map[string]string, firstUse func() error) (ok bool, newNonce bool, stale bool, err error) {
+	da.mutex.Lock()
+	defer da.mutex.Unlock()
+
+	if ok, err := da.verifyResponse(r, auth); err != nil || !ok {
+		return false, false, false, errors.As(err)
+	}
+
+	// At this point crypto checks are completed and validated.
+	// Now check if the session is valid.
+	nc, err := strconv.ParseUint(auth["nc"], 16, 64)
+	if err != nil || nc == 0 {
+		return false, false, false, nil
+	}
+	lastNc, known, err := da.lookupNonce(auth["nonce"], time.Now())
+	if err != nil {
+		return false, false, false, errors.As(err)
+	}
+	if !known {
+		return false, false, true, nil
+	}
+	// the nonce count starts from 1 and must be increased by each request,
+	// the replayed or out-of-order count(e.g. the parallel requests of browser) needs a new nonce, it is not a failure.
+	if nc <= lastNc {
+		return false, false, true, nil
+	}
+	if lastNc == 0 && firstUse != nil {
+		if err := firstUse(); err != nil {
+			return false, true, false, errors.As(err)
+		}
+	}
+	if err := da.store.PutNonce(auth["nonce"], nc, time.Now().UnixNano()); err != nil {
+		return false, false, false, errors.As(err)
+	}
+	return true, lastNc == 0, false, nil
 }
 
-// rebuild httpauth.DigestAuth.CheckAuth
 func (da *DigestAuth) CheckAuth(req *http.Request) (string, error) {
 	username := ""
-	auth := httpauth.DigestAuthParams(req.Header.Get(da.Headers.V().Authorization))
+	auth := httpauth.DigestAuthParams(req.Header.Get(httpauth.NormalHeaders.Authorization))
 	if auth != nil {
 		username = auth["username"]
 	}
@@ -120,22 +420,53 @@ func (da *DigestAuth) CheckAuth(req *http.Request) (string, error) {
 	}
 
 	// detect whether it is an attack
-	limitKey := fmt.Sprintf("%s_%+v", username, realIp(req))
-	errTimes := getAuthLimit(limitKey)
-	if errTimes > _AUTH_LIMIT_TIMES {
-		return "", ErrReject.As(limitKey, errTimes)
+	limitKey := authLimitKey(req, username)
+	errTimes, err := getAuthLimit(limitKey)
+	if err != nil {
+		return "", errors.As(err)
+	}
+	if errTimes > GetLimitPolicy().Times {
+		auditReq(req, username, AUDIT_LOGIN, AUDIT_RESULT_REJECTED, "")
+		return "", ErrReject.As(limitKey.ID, errTimes)
 	}
 
-	// do login with password
-	_, info := da.DigestAuth.CheckAuth(req)
-	if info == nil {
+	// do login with password, and the two-factor code is checked at the first use of the nonce,
+	// so the code is bound to the nonce and the following requests of the nonce need not a new code.
+	otpFailed := false
+	ok, newNonce, stale, err := da.checkDigest(req, auth, func() error {
+		if err := checkOtp(username, req.Header.Get(OTP_HEADER), limitKey, errTimes); err != nil {
+			otpFailed = true
+			return errors.As(err)
+		}
+		return nil
+	})
+	if err != nil {
+		if otpFailed {
+			auditReq(req, username, AUDIT_LOGIN, AUDIT_RESULT_FAILED, "")
+		}
+		return "", errors.As(err)
+	}
+	if stale {
+		// the password is right, it is not a failure.
+		return "", ErrNeedPwd.As("stale nonce", username)
+	}
+	if !ok {
 		// auth failed
-		updateAuthLimit(limitKey, errTimes+1)
+		auditReq(req, username, AUDIT_LOGIN, AUDIT_RESULT_FAILED, "")
+		if err := updateAuthLimit(limitKey, errTimes+1); err != nil {
+			return "", errors.As(err)
+		}
 		return "", ErrNeedPwd.As(auth)
 	}
 
 	// clean the errTimes when success
-	updateAuthLimit(limitKey, 0)
+	if err := updateAuthLimit(limitKey, 0); err != nil {
+		return "", errors.As(err)
+	}
+	// the following requests of the nonce are not a new login.
+	if newNonce {
+		auditReq(req, username, AUDIT_LOGIN, AUDIT_RESULT_OK, "")
+	}
 	return username, nil
 }
 
@@ -146,46 +477,29 @@ func readHttpResp(resp *http.Response) string {
 	}
 	return string(body)
 }
+
+// AuthReq posts the params to the uri of server with the digest or session login.
+//
+// Deprecated: use client.New(server, adminUser, adminPwd).Post(uri, params).
 func AuthReq(server, uri, adminUser, adminPwd string, params url.Values) error {
-	authResp, err := http.Head(server + uri)
-	if err != nil {
+	if _, err := AuthReqData(server, uri, adminUser, adminPwd, params); err != nil {
 		return errors.As(err)
 	}
-	defer authResp.Body.Close()
-	if authResp.StatusCode != 401 {
-		return errors.New(fmt.Sprintf("%d", authResp.StatusCode)).As(readHttpResp(authResp))
-	}
+	return nil
+}
 
-	authParams := httpauth.DigestAuthParams(authResp.Header.Get("Www-Authenticate"))
-	nonce := authParams["nonce"]
-	opaque := authParams["opaque"]
-	qop := authParams["qop"]
-	//algorithm := authParams["algorithm"]
-	realm := authParams["realm"]
-	nc := fmt.Sprintf("%08d", rand.Intn(99999999))
-	cnonce := fmt.Sprintf("%016x", rand.Intn(99999999))
+// AuthReqData is same as AuthReq, but return the response body when success.
+//
+// Deprecated: use client.New(server, adminUser, adminPwd).Post(uri, params).
+func AuthReqData(server, uri, adminUser, adminPwd string, params url.Values) ([]byte, error) {
+	return AuthReqOtp(server, uri, adminUser, adminPwd, "", params)
+}
 
-	req, err := http.NewRequest("POST", server+uri, strings.NewReader(params.Encode()))
-	if err != nil {
-		return errors.As(err)
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Authorization", fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=MD5, response="%s", opaque="%s", qop=%s, nc=%s, cnonce="%s"`,
-		adminUser, realm, nonce, uri,
-		httpauth.H(strings.Join([]string{
-			httpauth.H(adminUser + ":" + realm + ":" + adminPwd),
-			nonce, nc, cnonce, qop,
-			httpauth.H("POST:" + uri),
-		}, ":")),
-		opaque, qop, nc, cnonce,
-	))
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return errors.As(err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return errors.New(fmt.Sprintf("%d", resp.StatusCode)).As(readHttpResp(resp))
-	}
-	return nil
+// AuthReqOtp is same as AuthReqData, and send the two-factor code if it is not empty.
+//
+// Deprecated: use client.New and Client.SetOtp, then Client.Post.
+func AuthReqOtp(server, uri, adminUser, adminPwd, otp string, params url.Values) ([]byte, error) {
+	c := client.New(server, adminUser, adminPwd)
+	c.SetOtp(otp)
+	return c.Post(uri, params)
 }
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/dchest/captcha"
+	"github.com/gwaylib/errors"
+)
+
+// ErrNeedCaptcha will be returned by the session login if the captcha is required but not match.
+var ErrNeedCaptcha = errors.New("Need captcha")
+
+// NewCaptcha makes a new captcha of digits and returns the id of it,
+// the image and audio are served by CaptchaHandler with the id, and it can only be verified once.
+func NewCaptcha() string {
+	return captcha.New()
+}
+
+// CaptchaHandler serves the "<id>.png" image and the "<id>.wav" audio of the captcha,
+// a new one of the same id is made with the query "reload=1".
+func CaptchaHandler() http.Handler {
+	return captcha.Server(captcha.StdWidth, captcha.StdHeight)
+}
+
+// verifyCaptcha checks the digits of the captcha, the captcha is deleted after checked.
+func verifyCaptcha(id, digits string) bool {
+	if len(id) == 0 || len(digits) == 0 {
+		return false
+	}
+	return captcha.VerifyString(id, digits)
+}
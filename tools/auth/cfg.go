@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/gwaylib/database"
+	"github.com/gwaylib/errors"
+)
+
+// GetSysCfg returns the value of the system config,
+// errors.ErrNoData will be returned if the key not found.
+func GetSysCfg(key string) (string, error) {
+	value := ""
+	db := GetDB()
+	if err := database.QueryElem(db, &value, "SELECT value FROM sys_cfg WHERE id=?", key); err != nil {
+		return "", errors.As(err, key)
+	}
+	return value, nil
+}
+
+func PutSysCfg(key, value string) error {
+	db := GetDB()
+	if _, err := db.Exec(
+		"INSERT INTO sys_cfg(id,value)VALUES(?,?) ON CONFLICT(id) DO UPDATE SET value=excluded.value,updated_at=?",
+		key, value, time.Now(),
+	); err != nil {
+		return errors.As(err, key)
+	}
+	return nil
+}
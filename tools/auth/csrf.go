@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	httpauth "github.com/abbot/go-http-auth"
+	"github.com/gwaylib/errors"
+)
+
+const (
+	CSRF_FORM_KEY      = "csrf_token"
+	CSRF_TOKEN_EXPIRES = 12 * time.Hour
+
+	_SYS_CFG_CSRF_SECRET = "csrf_secret"
+)
+
+var ErrCsrfToken = errors.New("Invalid csrf token")
+
+var (
+	csrfSecret []byte
+	csrfLk     sync.Mutex
+)
+
+// load the secret of csrf token, it is made at the first time.
+func getCsrfSecret() ([]byte, error) {
+	csrfLk.Lock()
+	defer csrfLk.Unlock()
+	if csrfSecret != nil {
+		return csrfSecret, nil
+	}
+
+	dbGlobalLk.Lock()
+	defer dbGlobalLk.Unlock()
+	key, err := GetSysCfg(_SYS_CFG_CSRF_SECRET)
+	if err != nil {
+		if !errors.ErrNoData.Equal(err) {
+			return nil, errors.As(err)
+		}
+		key = httpauth.RandomKey() + httpauth.RandomKey()
+		if err := PutSysCfg(_SYS_CFG_CSRF_SECRET, key); err != nil {
+			return nil, errors.As(err)
+		}
+	}
+	csrfSecret = []byte(key)
+	return csrfSecret, nil
+}
+
+func csrfSign(secret []byte, username, issued string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(username + ":" + issued))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewCsrfToken returns the token for the forms of the user, the token is "issued unix seconds" + "." + HMAC-SHA256,
+// so it is not stored and is valid in CSRF_TOKEN_EXPIRES.
+func NewCsrfToken(username string, now time.Time) (string, error) {
+	secret, err := getCsrfSecret()
+	if err != nil {
+		return "", errors.As(err)
+	}
+	issued := strconv.FormatInt(now.Unix(), 10)
+	return issued + "." + csrfSign(secret, username, issued), nil
+}
+
+// CheckCsrfToken returns ErrCsrfToken if the token is not made for the user or expired.
+func CheckCsrfToken(username, token string, now time.Time) error {
+	idx := strings.Index(token, ".")
+	if idx < 1 {
+		return ErrCsrfToken.As(username, "format")
+	}
+	issued := token[:idx]
+	unix, err := strconv.ParseInt(issued, 10, 64)
+	if err != nil {
+		return ErrCsrfToken.As(username, "format")
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age < -time.Minute || age > CSRF_TOKEN_EXPIRES {
+		return ErrCsrfToken.As(username, "expired")
+	}
+	secret, err := getCsrfSecret()
+	if err != nil {
+		return errors.As(err)
+	}
+	if subtle.ConstantTimeCompare([]byte(token[idx+1:]), []byte(csrfSign(secret, username, issued))) != 1 {
+		return ErrCsrfToken.As(username, "signature")
+	}
+	return nil
+}
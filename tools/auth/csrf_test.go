@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCsrfToken(t *testing.T) {
+	now := time.Now()
+	token, err := NewCsrfToken("csrf_test", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckCsrfToken("csrf_test", token, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// the secret is kept in the db, the token is still valid after restart.
+	csrfLk.Lock()
+	csrfSecret = nil
+	csrfLk.Unlock()
+	if err := CheckCsrfToken("csrf_test", token, now); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		username string
+		token    string
+		now      time.Time
+	}{
+		{"other", token, now},
+		{"csrf_test", token, now.Add(CSRF_TOKEN_EXPIRES + time.Second)},
+		{"csrf_test", token + "x", now},
+		{"csrf_test", "", now},
+		{"csrf_test", "abc.def", now},
+	} {
+		if err := CheckCsrfToken(c.username, c.token, c.now); !ErrCsrfToken.Equal(err) {
+			t.Fatalf("expect ErrCsrfToken of %+v, but: %v", c, err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"database/sql"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,6 +10,7 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/gwaylib/database"
+	"github.com/gwaylib/errors"
 	"github.com/gwaylib/log"
 )
 
@@ -44,9 +46,66 @@ func InitDB(dbFile string) {
 	mdb = db
 
 	// Init tables
-	if _, err := db.Exec(tb_user_sql); err != nil {
-		panic(err)
+	for _, tbSql := range []string{
+		tb_user_sql,
+		tb_sys_cfg_sql,
+		tb_digest_nonce_sql,
+		tb_user_session_sql,
+		tb_group_info_sql,
+		tb_group_member_sql,
+		tb_user_passwd_history_sql,
+		tb_user_totp_sql,
+		tb_user_recovery_code_sql,
+		tb_user_token_sql,
+		tb_user_invite_sql,
+		tb_auth_limit_sql,
+		tb_auth_audit_sql,
+		tb_doc_read_sql,
+	} {
+		if _, err := db.Exec(tbSql); err != nil {
+			panic(err)
+		}
+	}
+
+	// Upgrade the tables of old version.
+	for _, col := range tb_columns_upgrade {
+		if err := addColumn(db, col[0], col[1], col[2]); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// add the column to the table if it not exist.
+func addColumn(db *database.DB, table, column, define string) error {
+	columns := []string{}
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return errors.As(err, table)
+	}
+	defer database.Close(rows)
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, kind       string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &kind, &notNull, &dflt, &pk); err != nil {
+			return errors.As(err, table)
+		}
+		columns = append(columns, name)
+	}
+	if err := rows.Err(); err != nil {
+		return errors.As(err, table)
+	}
+	for _, name := range columns {
+		if name == column {
+			return nil
+		}
+	}
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, define)); err != nil {
+		return errors.As(err, table, column)
 	}
+	return nil
 }
 
 func HasDB() bool {
@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gwaycc/mdoc/client"
+)
+
+func TestDigestAlgorithms(t *testing.T) {
+	if _, err := ParseDigestAlgorithms("sha-256, MD5"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseDigestAlgorithms("SHA-1"); !ErrDigestAlgorithm.Equal(err) {
+		t.Fatalf("expect ErrDigestAlgorithm, but: %v", err)
+	}
+	if _, err := ParseDigestAlgorithms(""); !ErrDigestAlgorithm.Equal(err) {
+		t.Fatalf("expect ErrDigestAlgorithm, but: %v", err)
+	}
+
+	// the example of RFC 7616 section 3.9.1
+	ha1 := HashPasswdAlg(DIGEST_SHA256, "Mufasa", "http-auth@example.org", "Circle of Life")
+	response := digestH(DIGEST_SHA256, ha1+":7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v:00000001:f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ:auth:"+
+		digestH(DIGEST_SHA256, "GET:/dir/index.html"))
+	if response != "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1" {
+		t.Fatalf("unexpect response: %s", response)
+	}
+
+	header := http.Header{}
+	header.Add("WWW-Authenticate", `Digest realm="mdoc", nonce="n1", opaque="o", algorithm="MD5", qop="auth"`)
+	header.Add("WWW-Authenticate", `Digest realm="mdoc", nonce="n1", opaque="o", algorithm="SHA-256", qop="auth"`)
+	header.Add("WWW-Authenticate", `Digest realm="mdoc", nonce="n1", opaque="o", algorithm="SHA-1", qop="auth"`)
+	if ch := client.ParseDigestChallenge(header); ch == nil || ch.Algorithm != DIGEST_SHA256 {
+		t.Fatalf("expect the SHA-256 challenge, but: %+v", ch)
+	}
+}
+
+func TestDigestNonceCount(t *testing.T) {
+	secret := func(user, realm, algorithm string) string {
+		return HashPasswdAlg(algorithm, user, realm, "hello")
+	}
+	da := NewDigestAuth(REALM, false, nil, nil)
+	da.AlgSecrets = secret
+	if err := da.SetAlgorithms(DigestAlgorithms()...); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	da.RequireAuth(w, httptest.NewRequest("GET", "/markdown/README.md", nil))
+	if challenges := w.Header().Values("WWW-Authenticate"); len(challenges) != 3 {
+		t.Fatalf("expect 3 challenges, but: %v", challenges)
+	}
+	challenge := client.ParseDigestChallenge(w.Header())
+	if challenge == nil || challenge.Algorithm != DIGEST_SHA512_256 {
+		t.Fatalf("expect the SHA-512-256 challenge, but: %+v", challenge)
+	}
+
+	username := fmt.Sprintf("digest_nc_%d", time.Now().UnixNano())
+	newReq := func(nc uint64) *http.Request {
+		challenge.Nc = nc - 1
+		authorization, err := challenge.Authorization("GET", "/markdown/README.md", username, "hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", "/markdown/README.md", nil)
+		req.Header.Set("Authorization", authorization)
+		return req
+	}
+	// the nonce count starts from 1
+	if _, err := da.CheckAuth(newReq(0)); !ErrNeedPwd.Equal(err) {
+		t.Fatalf("expect ErrNeedPwd, but: %v", err)
+	}
+	if _, err := da.CheckAuth(newReq(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := da.CheckAuth(newReq(3)); err != nil {
+		t.Fatal(err)
+	}
+	// the replayed or out-of-order nonce count is stale, it is not a password failure.
+	for _, nc := range []uint64{3, 2} {
+		replayReq := newReq(nc)
+		errTimes, err := getAuthLimit(authLimitKey(replayReq, username))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := da.CheckAuth(replayReq); !ErrNeedPwd.Equal(err) {
+			t.Fatalf("expect ErrNeedPwd of nc %d, but: %v", nc, err)
+		}
+		if times, err := getAuthLimit(authLimitKey(replayReq, username)); err != nil || times != errTimes {
+			t.Fatalf("expect the failures of nc %d not changed, %d %d %v", nc, errTimes, times, err)
+		}
+		w := httptest.NewRecorder()
+		da.RequireAuth(w, replayReq)
+		if next := client.ParseDigestChallenge(w.Header()); next == nil || !next.Stale {
+			t.Fatalf("expect the stale challenge of nc %d, but: %v", nc, w.Header().Values("WWW-Authenticate"))
+		}
+	}
+	if _, err := da.CheckAuth(newReq(4)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the state of nonce is lost, the valid response is stale and not a failure.
+	// (the purged nonces are idle for days, so their signatures are expired too)
+	opaque, err := da.store.Opaque()
+	if err != nil {
+		t.Fatal(err)
+	}
+	da.store = &memNonceStore{opaque: opaque, secret: "rotated", nonces: map[string]*memNonce{}}
+	staleReq := newReq(5)
+	errTimes, err := getAuthLimit(authLimitKey(staleReq, username))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := da.CheckAuth(staleReq); !ErrNeedPwd.Equal(err) {
+		t.Fatalf("expect ErrNeedPwd, but: %v", err)
+	}
+	if times, err := getAuthLimit(authLimitKey(staleReq, username)); err != nil || times != errTimes {
+		t.Fatalf("expect the failures not changed, %d %d %v", errTimes, times, err)
+	}
+	w = httptest.NewRecorder()
+	da.RequireAuth(w, staleReq)
+	if next := client.ParseDigestChallenge(w.Header()); next == nil || !next.Stale {
+		t.Fatalf("expect the stale challenge, but: %v", w.Header().Values("WWW-Authenticate"))
+	}
+	wrongAuth, err := challenge.Authorization("GET", "/markdown/README.md", username, "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongReq := httptest.NewRequest("GET", "/markdown/README.md", nil)
+	wrongReq.Header.Set("Authorization", wrongAuth)
+	w = httptest.NewRecorder()
+	da.RequireAuth(w, wrongReq)
+	if next := client.ParseDigestChallenge(w.Header()); next == nil || next.Stale {
+		t.Fatalf("expect the challenge not stale for the wrong password, but: %v", w.Header().Values("WWW-Authenticate"))
+	}
+}
+
+func TestDigestNegotiate(t *testing.T) {
+	username := fmt.Sprintf("digest_alg_%d", time.Now().UnixNano())
+	// the user made before the algorithms added has only the HA1 of MD5.
+	if err := AddUser(&UserInfo{ID: username, Passwd: HashPasswd(username, REALM, "hello1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	da := NewDigestAuth(REALM, false, nil, nil)
+	da.AlgSecrets = LocalProvider{}.AlgSecret
+	if err := da.SetAlgorithms(DigestAlgorithms()...); err != nil {
+		t.Fatal(err)
+	}
+	// the client answers the strongest challenge, and retries once with the challenge narrowed for the user.
+	expectAlgorithm := func(expect string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		da.RequireAuth(w, httptest.NewRequest("POST", "/user/list", nil))
+		challenge := client.ParseDigestChallenge(w.Header())
+		for retried := false; ; retried = true {
+			authorization, err := challenge.Authorization("POST", "/user/list", username, "hello1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest("POST", "/user/list", nil)
+			req.RemoteAddr = "127.0.0.1:1234"
+			req.Header.Set("Authorization", authorization)
+			name, err := da.CheckAuth(req)
+			if (ErrNeedLogin.Equal(err) || ErrNeedPwd.Equal(err)) && !retried {
+				w := httptest.NewRecorder()
+				da.RequireAuth(w, req)
+				if next := client.ParseDigestChallenge(w.Header()); next != nil && next.Algorithm != challenge.Algorithm {
+					challenge = next
+					continue
+				}
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != username || challenge.Algorithm != expect {
+				t.Fatalf("expect %s by %s, but: %s by %s", username, expect, name, challenge.Algorithm)
+			}
+			return
+		}
+	}
+	// the challenge is narrowed to MD5 for the user, and it is not a password failure.
+	expectAlgorithm(DIGEST_MD5)
+	limitReq := httptest.NewRequest("GET", "/", nil)
+	limitReq.RemoteAddr = "127.0.0.1:1234"
+	errTimes, err := getAuthLimit(authLimitKey(limitReq, username))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errTimes != 0 {
+		t.Fatalf("expect no failure, but: %d", errTimes)
+	}
+
+	// the HA1 of the other algorithms are made by the login with the plain password.
+	if ok, err := CheckPasswd(username, REALM, "hello1"); err != nil || !ok {
+		t.Fatalf("expect passed, %v", err)
+	}
+	uInfo, err := GetUser(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uInfo.PasswdSha256 != HashPasswdAlg(DIGEST_SHA256, username, REALM, "hello1") ||
+		uInfo.PasswdSha512_256 != HashPasswdAlg(DIGEST_SHA512_256, username, REALM, "hello1") {
+		t.Fatalf("expect the HA1 of algorithms: %+v", uInfo)
+	}
+	expectAlgorithm(DIGEST_SHA512_256)
+
+	// the HA1 of MD5 reset by the admin removes the others.
+	if err := ResetPwd(username, HashPasswd(username, REALM, "hello2")); err != nil {
+		t.Fatal(err)
+	}
+	DelAuthCache(username)
+	if len(LocalProvider{}.AlgSecret(username, REALM, DIGEST_SHA256)) > 0 {
+		t.Fatal("expect no HA1 of SHA-256 after reset")
+	}
+	if err := SetPasswd(username, REALM, "hello1"); err != nil {
+		t.Fatal(err)
+	}
+	DelAuthCache(username)
+	expectAlgorithm(DIGEST_SHA512_256)
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gwaylib/database"
+	"github.com/gwaylib/errors"
+)
+
+const (
+	// the uri prefix of the documents in public/markdown.
+	DOC_URI_PREFIX = "/markdown/"
+
+	_DOC_READ_DAY = "2006-01-02"
+)
+
+var (
+	ErrInvalidDay = errors.New("Invalid day")
+)
+
+// DocRead is the reads of a user on a page in a day.
+type DocRead struct {
+	UserID  string `db:"user_id"`
+	Path    string `db:"path"`
+	Day     string `db:"day"`      // local day of "2006-01-02"
+	FirstAt int64  `db:"first_at"` // unix seconds
+	LastAt  int64  `db:"last_at"`  // unix seconds
+	Times   int    `db:"times"`
+}
+
+// IsDocPage returns true if the uri is a markdown page of the documents,
+// the files start with "_" like "_sidebar.md" are the parts of docsify, they are not pages.
+func IsDocPage(uri string) bool {
+	if !strings.HasPrefix(uri, DOC_URI_PREFIX) || !strings.HasSuffix(uri, ".md") {
+		return false
+	}
+	return !strings.HasPrefix(path.Base(uri), "_")
+}
+
+// AddDocRead records the user read the page, the reads are deduplicated by the user, page and day.
+func AddDocRead(username, uri string, t time.Time) error {
+	db := GetDB()
+	if _, err := db.Exec(`
+INSERT INTO doc_read(user_id,path,day,first_at,last_at,times)VALUES(?,?,?,?,?,1)
+ON CONFLICT(user_id,path,day) DO UPDATE SET last_at=excluded.last_at,times=times+1
+`, username, path.Clean(uri), t.Format(_DOC_READ_DAY), t.Unix(), t.Unix(),
+	); err != nil {
+		return errors.As(err, username, uri)
+	}
+	return nil
+}
+
+type DocReadQuery struct {
+	UserID string
+	Path   string // the page, or the directory ends with "/"
+	Since  string // the first day of "2006-01-02", empty for no limit
+	Until  string // the last day of "2006-01-02", empty for no limit
+}
+
+// ListDocReads returns the reads matched the query, the newest day is the first,
+// ErrInvalidDay will be returned if the Since or Until is not "2006-01-02".
+func ListDocReads(q *DocReadQuery) ([]DocRead, error) {
+	where := []string{}
+	args := []interface{}{}
+	if len(q.UserID) > 0 {
+		where = append(where, "user_id=?")
+		args = append(args, q.UserID)
+	}
+	if len(q.Path) > 0 {
+		if strings.HasSuffix(q.Path, "/") {
+			where = append(where, "substr(path,1,?)=?")
+			args = append(args, len(q.Path), q.Path)
+		} else {
+			where = append(where, "path=?")
+			args = append(args, path.Clean(q.Path))
+		}
+	}
+	for _, day := range []string{q.Since, q.Until} {
+		if len(day) == 0 {
+			continue
+		}
+		if _, err := time.Parse(_DOC_READ_DAY, day); err != nil {
+			return nil, ErrInvalidDay.As(day)
+		}
+	}
+	if len(q.Since) > 0 {
+		where = append(where, "day>=?")
+		args = append(args, q.Since)
+	}
+	if len(q.Until) > 0 {
+		where = append(where, "day<=?")
+		args = append(args, q.Until)
+	}
+	qsql := "SELECT user_id,path,day,first_at,last_at,times FROM doc_read"
+	if len(where) > 0 {
+		qsql += " WHERE " + strings.Join(where, " AND ")
+	}
+	qsql += " ORDER BY day DESC,path,user_id"
+
+	result := []DocRead{}
+	db := GetDB()
+	if err := database.QueryStructs(db, &result, qsql, args...); err != nil {
+		return nil, errors.As(err, *q)
+	}
+	return result, nil
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIsDocPage(t *testing.T) {
+	cases := map[string]bool{
+		"/markdown/README.md":       true,
+		"/markdown/arch/arch.md":    true,
+		"/markdown/_sidebar.md":     false,
+		"/markdown/arch/a.png":      false,
+		"/index.html":               false,
+		"/markdownx/README.md":      false,
+		"/markdown/arch/_navbar.md": false,
+	}
+	for uri, expect := range cases {
+		if IsDocPage(uri) != expect {
+			t.Fatalf("%s expect %t", uri, expect)
+		}
+	}
+}
+
+func TestDocRead(t *testing.T) {
+	alice := fmt.Sprintf("read_alice_%d", time.Now().UnixNano())
+	bob := fmt.Sprintf("read_bob_%d", time.Now().UnixNano())
+	page := fmt.Sprintf("/markdown/policy_%d/a.md", time.Now().UnixNano())
+	day1 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+	day2 := day1.Add(24 * time.Hour)
+
+	for _, r := range []struct {
+		user string
+		uri  string
+		t    time.Time
+	}{
+		{alice, page, day1},
+		{alice, page, day1.Add(time.Hour)}, // deduplicated
+		{alice, page, day2},
+		{bob, page, day2},
+		{bob, "/markdown/other.md", day2},
+	} {
+		if err := AddDocRead(r.user, r.uri, r.t); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reads, err := ListDocReads(&DocReadQuery{Path: page})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reads) != 3 {
+		t.Fatalf("unexpect reads: %+v", reads)
+	}
+	last := reads[len(reads)-1]
+	if last.UserID != alice || last.Day != "2024-01-01" || last.Times != 2 || last.LastAt-last.FirstAt != 3600 {
+		t.Fatalf("unexpect read: %+v", last)
+	}
+
+	reads, err = ListDocReads(&DocReadQuery{UserID: bob, Since: "2024-01-02", Until: "2024-01-02"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reads) != 2 {
+		t.Fatalf("unexpect reads: %+v", reads)
+	}
+	// the pages in directory
+	dir := page[:len(page)-len("a.md")]
+	reads, err = ListDocReads(&DocReadQuery{Path: dir, Until: "2024-01-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reads) != 1 || reads[0].UserID != alice {
+		t.Fatalf("unexpect reads: %+v", reads)
+	}
+	if _, err := ListDocReads(&DocReadQuery{Path: page, Since: "2024/01/01"}); !ErrInvalidDay.Equal(err) {
+		t.Fatalf("expect ErrInvalidDay, but: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/gwaylib/database"
+	"github.com/gwaylib/errors"
+)
+
+const (
+	ROLE_VIEWER = 1 // read the documents
+	ROLE_EDITOR = 2 // read and write the documents
+	ROLE_ADMIN  = 3 // read and write the documents, and administer the users
+)
+
+// RolePerm returns the acl permissions granted by the role.
+func RolePerm(role int) int {
+	switch role {
+	case ROLE_VIEWER:
+		return ACL_PERM_READ
+	case ROLE_EDITOR, ROLE_ADMIN:
+		return ACL_PERM_READ | ACL_PERM_WRITE
+	}
+	return 0
+}
+
+func RoleName(role int) string {
+	switch role {
+	case ROLE_VIEWER:
+		return "viewer"
+	case ROLE_EDITOR:
+		return "editor"
+	case ROLE_ADMIN:
+		return "admin"
+	}
+	return "unknow"
+}
+
+// ParseRole returns the role of name, zero if the name is unknow.
+func ParseRole(name string) int {
+	for _, role := range []int{ROLE_VIEWER, ROLE_EDITOR, ROLE_ADMIN} {
+		if RoleName(role) == name {
+			return role
+		}
+	}
+	return 0
+}
+
+type GroupInfo struct {
+	ID   string `db:"id"`
+	Role int    `db:"role"`
+	Memo string `db:"memo"`
+}
+
+// GroupItem is the group info for listing.
+type GroupItem struct {
+	ID        string    `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+	Role      int       `db:"role"`
+	Memo      string    `db:"memo"`
+	Members   []string  `db:"-"`
+}
+
+func AddGroup(gInfo *GroupInfo) error {
+	db := GetDB()
+	if gInfo.Role == 0 {
+		gInfo.Role = ROLE_VIEWER // if the role not set, fix to viewer.
+	}
+	if _, err := database.InsertStruct(db, gInfo, "group_info"); err != nil {
+		return errors.As(err)
+	}
+	return nil
+}
+
+func GetGroup(name string) (*GroupInfo, error) {
+	gInfo := &GroupInfo{}
+	db := GetDB()
+	if err := database.QueryStruct(db, gInfo, `SELECT id,role,memo FROM group_info WHERE id=?`, name); err != nil {
+		return nil, errors.As(err, name)
+	}
+	return gInfo, nil
+}
+
+func DelGroup(name string) error {
+	db := GetDB()
+	if _, err := db.Exec("DELETE FROM group_member WHERE group_id=?", name); err != nil {
+		return errors.As(err, name)
+	}
+	if _, err := db.Exec("DELETE FROM group_info WHERE id=?", name); err != nil {
+		return errors.As(err, name)
+	}
+	return nil
+}
+
+func UpdateGroupRole(name string, role int) error {
+	db := GetDB()
+	if _, err := db.Exec("UPDATE group_info SET role=?,updated_at=? WHERE id=?", role, time.Now(), name); err != nil {
+		return errors.As(err, name, role)
+	}
+	return nil
+}
+
+func ListGroups() ([]GroupItem, error) {
+	groups := []GroupItem{}
+	db := GetDB()
+	if err := database.QueryStructs(db, &groups, "SELECT id,created_at,updated_at,role,memo FROM group_info ORDER BY id"); err != nil {
+		return nil, errors.As(err)
+	}
+	for i := range groups {
+		members := []string{}
+		if err := database.QueryElems(db, &members, "SELECT user_id FROM group_member WHERE group_id=? ORDER BY user_id", groups[i].ID); err != nil {
+			return nil, errors.As(err, groups[i].ID)
+		}
+		groups[i].Members = members
+	}
+	return groups, nil
+}
+
+func AddGroupMember(name, username string) error {
+	db := GetDB()
+	if _, err := db.Exec("INSERT OR IGNORE INTO group_member(group_id,user_id)VALUES(?,?)", name, username); err != nil {
+		return errors.As(err, name, username)
+	}
+	return nil
+}
+
+func DelGroupMember(name, username string) error {
+	db := GetDB()
+	if _, err := db.Exec("DELETE FROM group_member WHERE group_id=? AND user_id=?", name, username); err != nil {
+		return errors.As(err, name, username)
+	}
+	return nil
+}
+
+// UserRoleGroups returns the groups and the roles of the user.
+func UserRoleGroups(username string) (map[string]int, error) {
+	type roleGroup struct {
+		ID   string `db:"id"`
+		Role int    `db:"role"`
+	}
+	groups := []roleGroup{}
+	db := GetDB()
+	if err := database.QueryStructs(db, &groups,
+		"SELECT g.id,g.role FROM group_info g INNER JOIN group_member m ON g.id=m.group_id WHERE m.user_id=?",
+		username,
+	); err != nil {
+		return nil, errors.As(err, username)
+	}
+	result := map[string]int{}
+	for _, g := range groups {
+		result[g.ID] = g.Role
+	}
+	return result, nil
+}
+
+// HasAdminRole returns true if the user is in any group with the admin role.
+func HasAdminRole(username string) (bool, error) {
+	groups, err := UserRoleGroups(username)
+	if err != nil {
+		return false, errors.As(err)
+	}
+	for _, role := range groups {
+		if role == ROLE_ADMIN {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gwaylib/errors"
+)
+
+func TestGroup(t *testing.T) {
+	username := fmt.Sprintf("group_%d", time.Now().UnixNano())
+	if err := AddUser(&UserInfo{ID: username, Passwd: "testing"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddGroup(&GroupInfo{ID: "viewers"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddGroup(&GroupInfo{ID: "editors", Role: ROLE_EDITOR}); err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range []string{"viewers", "editors"} {
+		if err := AddGroupMember(g, username); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	groups, err := UserGroups(username, ACL_PERM_READ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(groups)
+	if fmt.Sprint(groups) != "[editors viewers]" {
+		t.Fatalf("unexpect groups: %v", groups)
+	}
+	groups, err = UserGroups(username, ACL_PERM_WRITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(groups) != "[editors]" {
+		t.Fatalf("unexpect groups: %v", groups)
+	}
+
+	if ok, err := HasAdminRole(username); err != nil || ok {
+		t.Fatalf("expect not admin role, %v", err)
+	}
+	if err := UpdateGroupRole("viewers", ROLE_ADMIN); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := HasAdminRole(username); err != nil || !ok {
+		t.Fatalf("expect admin role, %v", err)
+	}
+
+	if err := DelGroupMember("editors", username); err != nil {
+		t.Fatal(err)
+	}
+	list, err := ListGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range list {
+		if g.ID == "editors" && len(g.Members) != 0 {
+			t.Fatalf("unexpect members: %+v", g)
+		}
+	}
+
+	if err := DelGroup("viewers"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetGroup("viewers"); !errors.ErrNoData.Equal(err) {
+		t.Fatal("need data not exist, but: ", err)
+	}
+	if err := DelGroup("editors"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -12,6 +12,14 @@ type Prefix struct {
 	Regexp bool
 }
 
+func (p *Prefix) Match(path string) bool {
+	if p.Regexp {
+		ok, _ := filepath.Match(p.Path, path)
+		return ok
+	}
+	return strings.HasPrefix(path, p.Path)
+}
+
 type IgnoreAuth struct {
 	prefixes []Prefix
 }
@@ -38,14 +46,7 @@ func NewIgnoreAuth(prefix []Prefix) *IgnoreAuth {
 
 func (n *IgnoreAuth) Match(path string) bool {
 	for _, p := range n.prefixes {
-		if p.Regexp {
-			if ok, _ := filepath.Match(p.Path, path); ok {
-				return true
-			}
-			continue
-		}
-
-		if strings.HasPrefix(path, p.Path) {
+		if p.Match(path) {
 			return true
 		}
 	}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"net/mail"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gwaylib/database"
+	"github.com/gwaylib/errors"
+)
+
+var (
+	// ErrInviteInvalid will be returned if the invite token not found, used, revoked or expired.
+	ErrInviteInvalid = errors.New("Invalid invite")
+	ErrEmail         = errors.New("Invalid email")
+	ErrUserExist     = errors.New("User already exist")
+)
+
+// UserInvite is a single use invite for a new user, the invitee sets the password by the token of invite.
+type UserInvite struct {
+	ID        string `db:"id"`
+	UserID    string `db:"user_id"` // the bound username, empty for choosing by the invitee
+	Email     string `db:"email"`
+	CreatedBy string `db:"created_by"`
+	CreatedAt int64  `db:"created_at"`
+	ExpiredAt int64  `db:"expired_at"` // unix seconds
+	UsedAt    int64  `db:"used_at"`    // unix seconds, zero for unused
+	UsedBy    string `db:"used_by"`
+	Revoked   bool   `db:"revoked"`
+}
+
+// Pending returns true if the invite can be accepted.
+func (iv *UserInvite) Pending(now time.Time) bool {
+	return iv.UsedAt == 0 && !iv.Revoked && iv.ExpiredAt >= now.Unix()
+}
+
+// Status returns the status text of the invite, "pending", "used by <user>", "revoked" or "expired".
+func (iv *UserInvite) Status(now time.Time) string {
+	switch {
+	case iv.UsedAt > 0:
+		return "used by " + iv.UsedBy
+	case iv.Revoked:
+		return "revoked"
+	case iv.ExpiredAt < now.Unix():
+		return "expired"
+	}
+	return "pending"
+}
+
+// CheckEmail returns ErrEmail if the email is not a bare address like "someone@example.com".
+func CheckEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return ErrEmail.As(email)
+	}
+	return nil
+}
+
+// CreateInvite makes a new invite bound to the username or the email, and returns the token of invite,
+// only the hash of token is stored, so the token can only be got once.
+func CreateInvite(username, email, createdBy string, expires time.Duration) (string, *UserInvite, error) {
+	if len(username) == 0 && len(email) == 0 {
+		return "", nil, errors.New("need username or email")
+	}
+	if len(username) > 0 {
+		if err := CheckUserName(username); err != nil {
+			return "", nil, errors.As(err)
+		}
+	}
+	if len(email) > 0 {
+		if err := CheckEmail(email); err != nil {
+			return "", nil, errors.As(err)
+		}
+	}
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", nil, errors.As(err)
+	}
+	token, err := uuid.NewRandom()
+	if err != nil {
+		return "", nil, errors.As(err)
+	}
+	now := time.Now()
+	iv := &UserInvite{
+		ID:        id.String(),
+		UserID:    username,
+		Email:     email,
+		CreatedBy: createdBy,
+		CreatedAt: now.Unix(),
+		ExpiredAt: now.Add(expires).Unix(),
+	}
+	db := GetDB()
+	if _, err := db.Exec(
+		"INSERT INTO user_invite(id,secret,user_id,email,created_by,created_at,expired_at)VALUES(?,?,?,?,?,?,?)",
+		iv.ID, hashToken(token.String()), iv.UserID, iv.Email, iv.CreatedBy, iv.CreatedAt, iv.ExpiredAt,
+	); err != nil {
+		return "", nil, errors.As(err, username, email)
+	}
+	return token.String(), iv, nil
+}
+
+// GetInvite returns the pending invite of the token, ErrInviteInvalid will be returned if it can not be accepted.
+func GetInvite(token string) (*UserInvite, error) {
+	if _, err := uuid.Parse(token); err != nil {
+		return nil, ErrInviteInvalid.As("format")
+	}
+	// the token is found by the hash, so the time of comparing leaks nothing of the token.
+	iv := &UserInvite{}
+	db := GetDB()
+	if err := database.QueryStruct(db, iv,
+		"SELECT id,user_id,email,created_by,created_at,expired_at,used_at,used_by,revoked FROM user_invite WHERE secret=?",
+		hashToken(token),
+	); err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return nil, ErrInviteInvalid.As("not found")
+		}
+		return nil, errors.As(err)
+	}
+	if !iv.Pending(time.Now()) {
+		return nil, ErrInviteInvalid.As(iv.ID, "not pending")
+	}
+	return iv, nil
+}
+
+// AcceptInvite adds the user by the invite of token, the invite can only be used once.
+// ErrInviteInvalid will be returned if the invite can not be accepted or the username is not the bound one,
+// ErrUserExist will be returned if the username is used.
+func AcceptInvite(token string, uInfo *UserInfo) (*UserInvite, error) {
+	iv, err := GetInvite(token)
+	if err != nil {
+		return nil, errors.As(err)
+	}
+	if len(iv.UserID) > 0 && iv.UserID != uInfo.ID {
+		return nil, ErrInviteInvalid.As(iv.ID, "username", uInfo.ID)
+	}
+	if _, err := GetUser(uInfo.ID); err == nil {
+		return nil, ErrUserExist.As(uInfo.ID)
+	} else if !errors.ErrNoData.Equal(err) {
+		return nil, errors.As(err)
+	}
+
+	// take the invite before adding the user, so it can not be used by the concurrent requests.
+	now := time.Now().Unix()
+	db := GetDB()
+	result, err := db.Exec(
+		"UPDATE user_invite SET used_at=?,used_by=? WHERE id=? AND used_at=0 AND revoked=0 AND expired_at>=?",
+		now, uInfo.ID, iv.ID, now,
+	)
+	if err != nil {
+		return nil, errors.As(err, iv.ID)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, errors.As(err)
+	} else if n == 0 {
+		return nil, ErrInviteInvalid.As(iv.ID, "used")
+	}
+	if err := AddUser(uInfo); err != nil {
+		// give back the invite since the user is not added.
+		if _, rErr := db.Exec("UPDATE user_invite SET used_at=0,used_by='' WHERE id=?", iv.ID); rErr != nil {
+			return nil, errors.As(err, rErr)
+		}
+		return nil, errors.As(err, iv.ID)
+	}
+	iv.UsedAt, iv.UsedBy = now, uInfo.ID
+	return iv, nil
+}
+
+// ListInvites returns the invites, only the pending when pendingOnly is true, the newest is the first.
+func ListInvites(pendingOnly bool) ([]UserInvite, error) {
+	qsql := "SELECT id,user_id,email,created_by,created_at,expired_at,used_at,used_by,revoked FROM user_invite"
+	args := []interface{}{}
+	if pendingOnly {
+		qsql += " WHERE used_at=0 AND revoked=0 AND expired_at>=?"
+		args = append(args, time.Now().Unix())
+	}
+	qsql += " ORDER BY created_at DESC"
+	result := []UserInvite{}
+	db := GetDB()
+	if err := database.QueryStructs(db, &result, qsql, args...); err != nil {
+		return nil, errors.As(err)
+	}
+	return result, nil
+}
+
+// RevokeInvite revokes the unused invite, errors.ErrNoData will be returned if the invite not found or used.
+func RevokeInvite(id string) error {
+	db := GetDB()
+	result, err := db.Exec("UPDATE user_invite SET revoked=1 WHERE id=? AND used_at=0", id)
+	if err != nil {
+		return errors.As(err, id)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return errors.As(err)
+	}
+	if n == 0 {
+		return errors.ErrNoData.As(id)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestUserInvite(t *testing.T) {
+	username := fmt.Sprintf("invite_%d", time.Now().UnixNano())
+	if _, _, err := CreateInvite("", "", "admin", time.Hour); err == nil {
+		t.Fatal("expect the error of no username or email")
+	}
+	if _, _, err := CreateInvite("", "Someone <someone@example.com>", "admin", time.Hour); !ErrEmail.Equal(err) {
+		t.Fatalf("expect ErrEmail, but: %v", err)
+	}
+	token, iv, err := CreateInvite(username, "", "admin", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := GetInvite(token); err != nil || got.ID != iv.ID || got.UserID != username {
+		t.Fatalf("unexpect invite: %+v, %v", got, err)
+	}
+	if _, err := GetInvite("not-a-token"); !ErrInviteInvalid.Equal(err) {
+		t.Fatalf("expect ErrInviteInvalid, but: %v", err)
+	}
+
+	// the username is bound.
+	if _, err := AcceptInvite(token, &UserInfo{ID: username + "_other", Passwd: "testing"}); !ErrInviteInvalid.Equal(err) {
+		t.Fatalf("expect ErrInviteInvalid, but: %v", err)
+	}
+	uInfo := &UserInfo{ID: username}
+	if err := uInfo.SetPlainPasswd(REALM, "hello1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AcceptInvite(token, uInfo); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := CheckPasswd(username, REALM, "hello1"); err != nil || !ok {
+		t.Fatalf("expect the password of invitee, %v", err)
+	}
+	// single use
+	if _, err := AcceptInvite(token, &UserInfo{ID: username, Passwd: "testing"}); !ErrInviteInvalid.Equal(err) {
+		t.Fatalf("expect ErrInviteInvalid, but: %v", err)
+	}
+	if err := RevokeInvite(iv.ID); err == nil {
+		t.Fatal("expect the used invite can not be revoked")
+	}
+
+	// the username is chosen by the invitee of email, and the invite is kept when the user exists.
+	token, iv, err = CreateInvite("", "someone@example.com", "admin", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AcceptInvite(token, &UserInfo{ID: username, Passwd: "testing"}); !ErrUserExist.Equal(err) {
+		t.Fatalf("expect ErrUserExist, but: %v", err)
+	}
+	pending, err := ListInvites(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, p := range pending {
+		found = found || p.ID == iv.ID
+	}
+	if !found {
+		t.Fatalf("expect the invite pending: %+v", pending)
+	}
+	if err := RevokeInvite(iv.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetInvite(token); !ErrInviteInvalid.Equal(err) {
+		t.Fatalf("expect ErrInviteInvalid of revoked, but: %v", err)
+	}
+	all, err := ListInvites(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range all {
+		if a.ID == iv.ID && a.Status(time.Now()) != "revoked" {
+			t.Fatalf("expect the invite revoked: %+v", a)
+		}
+	}
+
+	// expired
+	token, _, err = CreateInvite("", "someone@example.com", "admin", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetInvite(token); !ErrInviteInvalid.Equal(err) {
+		t.Fatalf("expect ErrInviteInvalid of expired, but: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gwaylib/errors"
+)
+
+var (
+	trustedProxies   []*net.IPNet
+	trustedProxiesLk sync.Mutex
+)
+
+// SetTrustedProxies sets the CIDRs of the reverse proxies, a single ip is accepted too.
+// The forwarded headers are only trusted when the request comes from these proxies.
+func SetTrustedProxies(cidrs []string) error {
+	nets := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if len(cidr) == 0 {
+			continue
+		}
+		ipNet, err := parseCIDR(cidr)
+		if err != nil {
+			return errors.As(err)
+		}
+		nets = append(nets, ipNet)
+	}
+	trustedProxiesLk.Lock()
+	defer trustedProxiesLk.Unlock()
+	trustedProxies = nets
+	return nil
+}
+
+// parseCIDR parses the CIDR, a single ip is parsed as /32 or /128.
+func parseCIDR(cidr string) (*net.IPNet, error) {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, errors.New("invalid ip").As(cidr)
+		}
+		if ip.To4() != nil {
+			cidr += "/32"
+		} else {
+			cidr += "/128"
+		}
+	}
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, errors.As(err, cidr)
+	}
+	return ipNet, nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	trustedProxiesLk.Lock()
+	defer trustedProxiesLk.Unlock()
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNodeIp parses the ip of "ip", "ip:port", "[ipv6]:port" or "[ipv6]", and the quoted value of Forwarded.
+func parseNodeIp(node string) net.IP {
+	node = strings.Trim(strings.TrimSpace(node), "\"")
+	if ip := net.ParseIP(node); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(node, "["), "]"))
+}
+
+// forwardedFor returns the "for" nodes of the Forwarded(RFC 7239) headers,
+// or the X-Forwarded-For headers when there is no Forwarded header, the nearest proxy is the last.
+func forwardedFor(req *http.Request) []string {
+	nodes := []string{}
+	if headers := req.Header.Values("Forwarded"); len(headers) > 0 {
+		for _, header := range headers {
+			for _, elem := range strings.Split(header, ",") {
+				node := ""
+				for _, pair := range strings.Split(elem, ";") {
+					kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+					if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+						node = kv[1]
+					}
+				}
+				nodes = append(nodes, node)
+			}
+		}
+		return nodes
+	}
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		nodes = append(nodes, strings.Split(header, ",")...)
+	}
+	return nodes
+}
+
+// ClientIp returns the ip of the client. The forwarded headers are walked from the nearest proxy,
+// and the first ip which is not a trusted proxy is the client, so the client can not fake its ip.
+func ClientIp(req *http.Request) string {
+	ip := parseNodeIp(req.RemoteAddr)
+	if ip == nil {
+		return req.RemoteAddr
+	}
+	if !isTrustedProxy(ip) {
+		return ip.String()
+	}
+	nodes := forwardedFor(req)
+	for i := len(nodes) - 1; i >= 0; i-- {
+		nodeIp := parseNodeIp(nodes[i])
+		if nodeIp == nil {
+			// "unknown" or the obfuscated node, stop at the last known proxy.
+			break
+		}
+		ip = nodeIp
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return ip.String()
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIp(t *testing.T) {
+	defer SetTrustedProxies(nil)
+	if err := SetTrustedProxies([]string{"10.0.0.0/8", "::1", "fd00::/8"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetTrustedProxies([]string{"10.0.0.0/8", "bad"}); err == nil {
+		t.Fatal("expect error of invalid proxy")
+	}
+	if err := SetTrustedProxies([]string{"10.0.0.0/8", "::1", "fd00::/8"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		remote  string
+		headers map[string][]string
+		expect  string
+	}{
+		// the headers are ignored when the remote is not a trusted proxy.
+		{"192.0.2.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "192.0.2.1"},
+		{"[2001:db8::1]:1234", nil, "2001:db8::1"},
+		{"10.0.0.1:1234", nil, "10.0.0.1"},
+		{"10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		// the fake ip added by the client is skipped.
+		{"10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"1.1.1.1, 198.51.100.1, 10.0.0.2"}}, "198.51.100.1"},
+		{"10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"1.1.1.1", "198.51.100.1"}}, "198.51.100.1"},
+		{"[::1]:1234", map[string][]string{"X-Forwarded-For": {"2001:db8::2"}}, "2001:db8::2"},
+		// the Forwarded header is preferred.
+		{"10.0.0.1:1234", map[string][]string{
+			"Forwarded":       {`for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"`},
+			"X-Forwarded-For": {"198.51.100.1"},
+		}, "2001:db8:cafe::17"},
+		{"10.0.0.1:1234", map[string][]string{"Forwarded": {"For=\"198.51.100.3:80\""}}, "198.51.100.3"},
+		{"10.0.0.1:1234", map[string][]string{"Forwarded": {"for=unknown"}}, "10.0.0.1"},
+		// all the nodes are trusted.
+		{"10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+	}
+	for i, c := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = c.remote
+		for k, v := range c.headers {
+			req.Header[k] = v
+		}
+		if ip := ClientIp(req); ip != c.expect {
+			t.Fatalf("case %d expect %s, but: %s", i, c.expect, ip)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gwaylib/errors"
+	"github.com/gwaylib/log"
+)
+
+const (
+	IP_RULE_ALLOW = "allow"
+	IP_RULE_DENY  = "deny"
+
+	_IP_POLICY_CHECK_INTERVAL = time.Second
+)
+
+// ErrIpPolicy is returned by ParseIpPolicy with the line number and the invalid value.
+var ErrIpPolicy = errors.New("Invalid ip policy")
+
+type IpRule struct {
+	Prefix
+	Allow bool
+	Nets  []*net.IPNet
+}
+
+// IpPolicy controls the access by the client ip and the path, it works even if the user is not login.
+// The most specific(longest) path of rules will be used, and the rules are checked in order,
+// the first rule which contains the ip decides the access. When no rule contains the ip,
+// the ip is denied if there is a allow rule of the path, else it is allowed.
+type IpPolicy struct {
+	rules []IpRule
+}
+
+// The format of the ip policy file is:
+//
+// # path, allow|deny, CIDRs or ips...
+// /markdown/internal, allow, 10.0.0.0/8, 192.168.0.0/16, fd00::/8
+// /, deny, 203.0.113.0/24
+func ParseIpPolicy(data []byte) (*IpPolicy, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	record, err := r.ReadAll()
+	if err != nil {
+		return nil, errors.As(err)
+	}
+	rules := []IpRule{}
+	for i, r := range record {
+		if len(r) == 0 || len(strings.TrimSpace(r[0])) == 0 {
+			continue
+		}
+		if len(r) < 3 {
+			return nil, ErrIpPolicy.As(i+1, "need path, allow|deny and CIDRs")
+		}
+		path := strings.TrimSpace(r[0])
+		rule := IpRule{Prefix: Prefix{Path: path, Regexp: strings.Contains(path, "*")}}
+		switch strings.ToLower(strings.TrimSpace(r[1])) {
+		case IP_RULE_ALLOW:
+			rule.Allow = true
+		case IP_RULE_DENY:
+		default:
+			return nil, ErrIpPolicy.As(i+1, r[1])
+		}
+		for _, cidr := range r[2:] {
+			if cidr = strings.TrimSpace(cidr); len(cidr) == 0 {
+				continue
+			}
+			ipNet, err := parseCIDR(cidr)
+			if err != nil {
+				return nil, ErrIpPolicy.As(i+1, cidr, err)
+			}
+			rule.Nets = append(rule.Nets, ipNet)
+		}
+		rules = append(rules, rule)
+	}
+	return NewIpPolicy(rules), nil
+}
+
+func NewIpPolicy(rules []IpRule) *IpPolicy {
+	return &IpPolicy{rules: rules}
+}
+
+// Allow returns true if the ip can access the path,
+// the path is cleaned by CleanPath and the prefix of rule matches at the boundary of "/" as the AuthAcl.
+func (p *IpPolicy) Allow(uri, ip string) bool {
+	uri = CleanPath(uri)
+	matched := []IpRule{}
+	maxLen := -1
+	for _, r := range p.rules {
+		if !r.MatchDir(uri) {
+			continue
+		}
+		switch {
+		case len(r.Path) > maxLen:
+			maxLen = len(r.Path)
+			matched = []IpRule{r}
+		case len(r.Path) == maxLen:
+			matched = append(matched, r)
+		}
+	}
+	if len(matched) == 0 {
+		return true
+	}
+
+	clientIp := net.ParseIP(ip)
+	hasAllow := false
+	for _, r := range matched {
+		hasAllow = hasAllow || r.Allow
+		if clientIp == nil {
+			continue
+		}
+		for _, n := range r.Nets {
+			if n.Contains(clientIp) {
+				return r.Allow
+			}
+		}
+	}
+	return !hasAllow
+}
+
+// IpPolicyFile is the IpPolicy of file which is reloaded when the file changed.
+type IpPolicyFile struct {
+	file string
+
+	mutex     sync.Mutex
+	policy    *IpPolicy
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewIpPolicyFile loads the policy of file, and a empty policy is used when the file not exist.
+func NewIpPolicyFile(file string) (*IpPolicyFile, error) {
+	f := &IpPolicyFile{file: file, policy: NewIpPolicy(nil)}
+	if err := f.load(); err != nil {
+		return nil, errors.As(err, file)
+	}
+	return f, nil
+}
+
+func (f *IpPolicyFile) load() error {
+	modTime := time.Time{}
+	data := []byte{}
+	info, err := os.Stat(f.file)
+	switch {
+	case err == nil:
+		modTime = info.ModTime()
+		if modTime.Equal(f.modTime) {
+			return nil
+		}
+		data, err = ioutil.ReadFile(f.file)
+		if err != nil {
+			return errors.As(err)
+		}
+	case os.IsNotExist(err):
+		if f.modTime.IsZero() {
+			return nil
+		}
+	default:
+		return errors.As(err)
+	}
+
+	// record the time even if the file is invalid, so it is only reported once.
+	f.modTime = modTime
+	policy, err := ParseIpPolicy(data)
+	if err != nil {
+		return errors.As(err)
+	}
+	f.policy = policy
+	return nil
+}
+
+// Allow checks the ip with the policy of file, the file is reloaded if it changed,
+// and the old policy is kept when the new file is invalid.
+func (f *IpPolicyFile) Allow(path, ip string) bool {
+	f.mutex.Lock()
+	now := time.Now()
+	if now.Sub(f.lastCheck) >= _IP_POLICY_CHECK_INTERVAL {
+		f.lastCheck = now
+		oldTime := f.modTime
+		if err := f.load(); err != nil {
+			log.Warn(errors.As(err, f.file))
+		} else if !f.modTime.Equal(oldTime) {
+			log.Infof("ip policy reloaded: %s", f.file)
+		}
+	}
+	policy := f.policy
+	f.mutex.Unlock()
+	return policy.Allow(path, ip)
+}
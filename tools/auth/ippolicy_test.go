@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIpPolicy(t *testing.T) {
+	policy, err := ParseIpPolicy([]byte(`
+# path, allow|deny, CIDRs...
+/markdown/internal, allow, 10.0.0.0/8, fd00::/8
+/markdown/internal, deny, 10.1.0.0/16
+/, deny, 203.0.113.0/24, 2001:db8::1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path   string
+		ip     string
+		expect bool
+	}{
+		{"/markdown/internal/a.md", "10.0.0.1", true},
+		{"/markdown/internal/a.md", "fd00::1", true},
+		{"/markdown/internal/a.md", "192.0.2.1", false},
+		// the rules are checked in order.
+		{"/markdown/internal/a.md", "10.1.0.1", true},
+		{"/markdown/internal/a.md", "", false},
+		{"/markdown/a.md", "203.0.113.9", false},
+		{"/markdown/a.md", "2001:db8::1", false},
+		{"/markdown/a.md", "192.0.2.1", true},
+		// the rules can not be bypassed by the uncleaned path
+		{"/markdown//internal/a.md", "192.0.2.1", false},
+		{"/markdown/./internal/a.md", "192.0.2.1", false},
+		{"/markdown/a/../internal/a.md", "192.0.2.1", false},
+		{"//markdown/a.md", "203.0.113.9", false},
+		// the prefix only matches at the boundary of "/"
+		{"/markdown/internals/a.md", "192.0.2.1", true},
+	}
+	for i, c := range cases {
+		if policy.Allow(c.path, c.ip) != c.expect {
+			t.Fatalf("case %d expect %t: %+v", i, c.expect, c)
+		}
+	}
+
+	if _, err := ParseIpPolicy([]byte("/, allow")); !ErrIpPolicy.Equal(err) {
+		t.Fatalf("expect ErrIpPolicy of missing CIDRs, but: %v", err)
+	}
+	if _, err := ParseIpPolicy([]byte("/, pass, 10.0.0.0/8")); !ErrIpPolicy.Equal(err) {
+		t.Fatalf("expect ErrIpPolicy of unknow action, but: %v", err)
+	}
+	if _, err := ParseIpPolicy([]byte("/, deny, 10.0.0.0/33")); !ErrIpPolicy.Equal(err) {
+		t.Fatalf("expect ErrIpPolicy of invalid CIDR, but: %v", err)
+	}
+}
+
+func TestIpPolicyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mdoc_ip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, ".authip")
+
+	f, err := NewIpPolicyFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Allow("/", "192.0.2.1") {
+		t.Fatal("expect allowed without file")
+	}
+
+	reload := func(data string, modTime time.Time) {
+		if err := ioutil.WriteFile(file, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		f.lastCheck = time.Time{}
+	}
+	now := time.Now()
+	reload("/, deny, 192.0.2.0/24", now.Add(-time.Hour))
+	if f.Allow("/", "192.0.2.1") {
+		t.Fatal("expect denied after reload")
+	}
+	// the old policy is kept when the file is invalid.
+	reload("/, deny", now.Add(-time.Minute))
+	if f.Allow("/", "192.0.2.1") {
+		t.Fatal("expect the old policy kept")
+	}
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	f.lastCheck = time.Time{}
+	if !f.Allow("/", "192.0.2.1") {
+		t.Fatal("expect allowed after removed")
+	}
+
+	// the path is cleaned before matching the rules of file
+	reload("/markdown/internal, deny, 192.0.2.0/24", now)
+	for _, path := range []string{"/markdown/internal/a.md", "/markdown//internal/a.md", "/markdown/./internal/a.md", "/markdown/a/../internal/a.md"} {
+		if f.Allow(path, "192.0.2.1") {
+			t.Fatalf("expect denied: %s", path)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/gwaylib/errors"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	_KDF_ARGON2ID = "argon2id"
+
+	// see the recommendation of OWASP
+	_ARGON2_TIME    = 2
+	_ARGON2_MEMORY  = 19 * 1024 // KiB
+	_ARGON2_THREADS = 1
+	_ARGON2_KEY_LEN = 32
+	_ARGON2_SALT    = 16
+)
+
+// HashKdf returns the argon2id hash of the password with a random salt, the format is:
+// $argon2id$v=19$m=19456,t=2,p=1$<base64 salt>$<base64 hash>
+func HashKdf(passwd string) (string, error) {
+	salt := make([]byte, _ARGON2_SALT)
+	if _, err := rand.Read(salt); err != nil {
+		return "", errors.As(err)
+	}
+	key := argon2.IDKey([]byte(passwd), salt, _ARGON2_TIME, _ARGON2_MEMORY, _ARGON2_THREADS, _ARGON2_KEY_LEN)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		_KDF_ARGON2ID, argon2.Version, _ARGON2_MEMORY, _ARGON2_TIME, _ARGON2_THREADS,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// VerifyKdf returns true if the password matches the hash of HashKdf.
+func VerifyKdf(hash, passwd string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != _KDF_ARGON2ID {
+		return false, errors.New("unknow kdf hash").As(parts[:1])
+	}
+	var (
+		version       int
+		memory, times uint32
+		threads       uint8
+	)
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, errors.As(err)
+	}
+	if version != argon2.Version {
+		return false, errors.New("unsupported argon2 version").As(version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &times, &threads); err != nil {
+		return false, errors.As(err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, errors.As(err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, errors.As(err)
+	}
+	out := argon2.IDKey([]byte(passwd), salt, times, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(out, key) == 1, nil
+}
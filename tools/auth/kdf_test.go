@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHashKdf(t *testing.T) {
+	hash1, err := HashKdf("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash2, err := HashKdf("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash1, "$argon2id$v=19$") || hash1 == hash2 {
+		t.Fatalf("expect salted argon2id hash, but: %s, %s", hash1, hash2)
+	}
+	if ok, err := VerifyKdf(hash1, "hello"); err != nil || !ok {
+		t.Fatalf("expect match, %v", err)
+	}
+	if ok, err := VerifyKdf(hash1, "hello1"); err != nil || ok {
+		t.Fatalf("expect not match, %v", err)
+	}
+	if _, err := VerifyKdf(HashPasswd("admin", REALM, "hello"), "hello"); err == nil {
+		t.Fatal("expect error for the unknow hash")
+	}
+}
+
+func TestCheckPasswdUpgrade(t *testing.T) {
+	username := fmt.Sprintf("kdf_%d", time.Now().UnixNano())
+	if err := AddUser(&UserInfo{ID: username, Passwd: HashPasswd(username, REALM, "hello")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the kdf hash is made after login
+	if ok, err := CheckPasswd(username, REALM, "hello"); err != nil || !ok {
+		t.Fatalf("expect match, %v", err)
+	}
+	uInfo, err := GetUser(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uInfo.PasswdKdf) == 0 || len(uInfo.Passwd) == 0 {
+		t.Fatalf("unexpect hashes: %+v", uInfo)
+	}
+
+	// the HA1 is removed when the digest is disabled
+	DisableDigest(true)
+	defer DisableDigest(false)
+	if ok, err := CheckPasswd(username, REALM, "bad"); err != nil || ok {
+		t.Fatalf("expect not match, %v", err)
+	}
+	if ok, err := CheckPasswd(username, REALM, "hello"); err != nil || !ok {
+		t.Fatalf("expect match, %v", err)
+	}
+	uInfo, err = GetUser(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uInfo.PasswdKdf) == 0 || len(uInfo.Passwd) != 0 {
+		t.Fatalf("unexpect hashes: %+v", uInfo)
+	}
+
+	if err := SetPasswd(username, REALM, "world1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := CheckPasswd(username, REALM, "world1"); err != nil || !ok {
+		t.Fatalf("expect match, %v", err)
+	}
+	if err := CheckPasswdPolicy(username, REALM, "world1"); !ErrPasswdReused.Equal(err) {
+		t.Fatalf("expect ErrPasswdReused, but: %v", err)
+	}
+	if err := CheckPasswdPolicy(username, REALM, "hello1"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/gwaylib/errors"
+	"github.com/gwaylib/log"
+)
+
+const (
+	_LDAP_CACHE_HEAD = "ldap_%s"
+	_LDAP_TIMEOUT    = 10 * time.Second
+)
+
+var (
+	ErrLdap = errors.New("LDAP failed")
+)
+
+type LdapConfig struct {
+	URL        string // ldap://host:389 or ldaps://host:636
+	StartTLS   bool   // upgrade the ldap:// connection with StartTLS
+	SkipVerify bool   // skip the verification of the server certificate, only for testing
+
+	BindDN     string // the account to search the users, empty for the anonymous search
+	BindPasswd string
+
+	BaseDN      string // the base to search the users
+	UserFilter  string // the filter to search the user, %s is the escaped username, default is (uid=%s)
+	NameAttr    string // the attribute of the nickname, default is cn
+	GroupBaseDN string // the base to search the groups, default is the BaseDN
+	GroupFilter string // the filter to search the groups of user, %s is the escaped user dn, default is (member=%s)
+	GroupAttr   string // the attribute of the group name, default is cn
+
+	GroupRoles    map[string]int // the ldap groups to sync, and the role of the mdoc groups
+	AutoProvision bool           // add the user when it is not found
+	CacheExpires  time.Duration  // the time to keep the success of the bind, 0 for no cache
+}
+
+// LdapProvider authenticates the users by binding to the LDAP directory with the password.
+//
+// The users who have a local password are not authenticated by the directory,
+// and the directory can not offer the HA1 of digest, so the ldap users need the session login or the api token.
+type LdapProvider struct {
+	cfg      LdapConfig
+	cacheKey []byte
+}
+
+func NewLdapProvider(cfg LdapConfig) (*LdapProvider, error) {
+	if len(cfg.URL) == 0 || len(cfg.BaseDN) == 0 {
+		return nil, errors.New("need the ldap url and base dn")
+	}
+	if len(cfg.UserFilter) == 0 {
+		cfg.UserFilter = "(uid=%s)"
+	}
+	if len(cfg.NameAttr) == 0 {
+		cfg.NameAttr = "cn"
+	}
+	if len(cfg.GroupBaseDN) == 0 {
+		cfg.GroupBaseDN = cfg.BaseDN
+	}
+	if len(cfg.GroupFilter) == 0 {
+		cfg.GroupFilter = "(member=%s)"
+	}
+	if len(cfg.GroupAttr) == 0 {
+		cfg.GroupAttr = "cn"
+	}
+	// the cached passwords are hashed with the random key, it is only valid in the process.
+	cacheKey := make([]byte, 32)
+	if _, err := rand.Read(cacheKey); err != nil {
+		return nil, errors.As(err)
+	}
+	return &LdapProvider{cfg: cfg, cacheKey: cacheKey}, nil
+}
+
+func (lp *LdapProvider) Name() string {
+	return "ldap"
+}
+
+// Secret returns empty since the directory can not offer the HA1.
+func (lp *LdapProvider) Secret(username, realm string) string {
+	return ""
+}
+
+func (lp *LdapProvider) cacheHash(username, passwd string) string {
+	mac := hmac.New(sha256.New, lp.cacheKey)
+	mac.Write([]byte(username + "\x00" + passwd))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (lp *LdapProvider) dial() (*ldap.Conn, error) {
+	tlsCfg := &tls.Config{InsecureSkipVerify: lp.cfg.SkipVerify}
+	conn, err := ldap.DialURL(lp.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: _LDAP_TIMEOUT}),
+		ldap.DialWithTLSConfig(tlsCfg),
+	)
+	if err != nil {
+		return nil, ErrLdap.As(err, lp.cfg.URL)
+	}
+	conn.SetTimeout(_LDAP_TIMEOUT)
+	if lp.cfg.StartTLS {
+		if u, err := url.Parse(lp.cfg.URL); err == nil {
+			tlsCfg.ServerName = u.Hostname()
+		}
+		if err := conn.StartTLS(tlsCfg); err != nil {
+			conn.Close()
+			return nil, ErrLdap.As(err, lp.cfg.URL)
+		}
+	}
+	return conn, nil
+}
+
+// bind as the search account.
+func (lp *LdapProvider) bindSearch(conn *ldap.Conn) error {
+	if len(lp.cfg.BindDN) == 0 {
+		return conn.UnauthenticatedBind("")
+	}
+	return conn.Bind(lp.cfg.BindDN, lp.cfg.BindPasswd)
+}
+
+// CheckPasswd searches the user in the directory and binds with the password,
+// the user and the groups are synced to the db when success.
+func (lp *LdapProvider) CheckPasswd(username, realm, passwd string) (bool, error) {
+	// an empty password is the unauthenticated bind, it always success.
+	if len(username) == 0 || len(passwd) == 0 {
+		return false, nil
+	}
+	uInfo, err := GetUser(username)
+	switch {
+	case err == nil:
+		if uInfo.Disabled || len(uInfo.Passwd) > 0 || len(uInfo.PasswdKdf) > 0 {
+			return false, nil
+		}
+	case errors.ErrNoData.Equal(err):
+		if !lp.cfg.AutoProvision {
+			return false, nil
+		}
+	default:
+		return false, errors.As(err)
+	}
+
+	cacheKey := fmt.Sprintf(_LDAP_CACHE_HEAD, username)
+	hash := lp.cacheHash(username, passwd)
+	if uInfo != nil {
+		if cached, ok := authCache.Get(cacheKey).(string); ok && hmac.Equal([]byte(cached), []byte(hash)) {
+			return true, nil
+		}
+	}
+
+	conn, err := lp.dial()
+	if err != nil {
+		return false, errors.As(err)
+	}
+	defer conn.Close()
+	if err := lp.bindSearch(conn); err != nil {
+		return false, ErrLdap.As(err, "search bind")
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		lp.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(_LDAP_TIMEOUT/time.Second), false,
+		fmt.Sprintf(lp.cfg.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn", lp.cfg.NameAttr},
+		nil,
+	))
+	if err != nil {
+		return false, ErrLdap.As(err, "search user", username)
+	}
+	if len(result.Entries) != 1 {
+		// not found, or not unique.
+		return false, nil
+	}
+	entry := result.Entries[0]
+	if err := conn.Bind(entry.DN, passwd); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return false, nil
+		}
+		return false, ErrLdap.As(err, "user bind", entry.DN)
+	}
+
+	// search the groups as the search account, the user may have no permission to read the groups.
+	if err := lp.bindSearch(conn); err != nil {
+		return false, ErrLdap.As(err, "search bind")
+	}
+	groupResult, err := conn.Search(ldap.NewSearchRequest(
+		lp.cfg.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(_LDAP_TIMEOUT/time.Second), false,
+		fmt.Sprintf(lp.cfg.GroupFilter, ldap.EscapeFilter(entry.DN)),
+		[]string{lp.cfg.GroupAttr},
+		nil,
+	))
+	if err != nil {
+		return false, ErrLdap.As(err, "search groups", entry.DN)
+	}
+	groups := []string{}
+	for _, g := range groupResult.Entries {
+		groups = append(groups, g.GetAttributeValues(lp.cfg.GroupAttr)...)
+	}
+
+	if err := syncExternalUser("ldap", username, entry.GetAttributeValue(lp.cfg.NameAttr), groups, lp.cfg.GroupRoles, lp.cfg.AutoProvision); err != nil {
+		if ErrNotProvisioned.Equal(err) || ErrLocalUser.Equal(err) || ErrReject.Equal(err) {
+			log.Info(errors.As(err))
+			return false, nil
+		}
+		return false, errors.As(err)
+	}
+	if lp.cfg.CacheExpires > 0 {
+		authCache.Put(cacheKey, hash, int64(lp.cfg.CacheExpires/time.Second))
+	}
+	return true, nil
+}
@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+type ldapEntry struct {
+	dn     string
+	passwd string
+	attrs  map[string][]string
+}
+
+// mockLdap is an in-process LDAP server for testing,
+// it supports the simple bind and the search with an equality filter.
+type mockLdap struct {
+	t       *testing.T
+	ln      net.Listener
+	entries []ldapEntry
+
+	mutex sync.Mutex
+	binds int
+}
+
+func newMockLdap(t *testing.T, entries []ldapEntry) *mockLdap {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockLdap{t: t, ln: ln, entries: entries}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go m.serve(conn)
+		}
+	}()
+	return m
+}
+
+func (m *mockLdap) URL() string {
+	return "ldap://" + m.ln.Addr().String()
+}
+
+func (m *mockLdap) Binds() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.binds
+}
+
+func ldapResult(tag ber.Tag, code int) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return p
+}
+
+func (m *mockLdap) serve(conn net.Conn) {
+	defer conn.Close()
+	bound := ""
+	for {
+		req, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		msgID := req.Children[0].Value.(int64)
+		op := req.Children[1]
+		resps := []*ber.Packet{}
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, passwd := op.Children[1].Value.(string), op.Children[2].Data.String()
+			code := ldap.LDAPResultInvalidCredentials
+			if len(passwd) == 0 {
+				// anonymous
+				code, bound = ldap.LDAPResultSuccess, ""
+			}
+			for _, e := range m.entries {
+				if e.dn == dn && e.passwd == passwd {
+					code, bound = ldap.LDAPResultSuccess, dn
+				}
+			}
+			m.mutex.Lock()
+			m.binds++
+			m.mutex.Unlock()
+			resps = append(resps, ldapResult(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			if bound != "cn=search,dc=test" {
+				resps = append(resps, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights))
+				break
+			}
+			base := op.Children[0].Value.(string)
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				m.t.Error(err)
+				return
+			}
+			kv := strings.SplitN(strings.Trim(filter, "()"), "=", 2)
+			for _, e := range m.entries {
+				if !strings.HasSuffix(e.dn, base) {
+					continue
+				}
+				matched := false
+				for _, v := range e.attrs[kv[0]] {
+					matched = matched || v == kv[1]
+				}
+				if !matched {
+					continue
+				}
+				entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+				entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, ""))
+				attrs := ber.NewSequence("")
+				for name, values := range e.attrs {
+					attr := ber.NewSequence("")
+					attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+					set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+					for _, v := range values {
+						set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, ""))
+					}
+					attr.AppendChild(set)
+					attrs.AppendChild(attr)
+				}
+				entry.AppendChild(attrs)
+				resps = append(resps, entry)
+			}
+			resps = append(resps, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		default:
+			// unbind
+			return
+		}
+		for _, resp := range resps {
+			envelope := ber.NewSequence("")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgID, ""))
+			envelope.AppendChild(resp)
+			if _, err := conn.Write(envelope.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestLdapProvider(t *testing.T) {
+	suffix := time.Now().UnixNano()
+	username := fmt.Sprintf("ldap_%d", suffix)
+	group := fmt.Sprintf("ldap_writers_%d", suffix)
+	userDN := "uid=" + username + ",ou=people,dc=test"
+	m := newMockLdap(t, []ldapEntry{
+		{dn: "cn=search,dc=test", passwd: "search"},
+		{dn: userDN, passwd: "ldappass", attrs: map[string][]string{"uid": {username}, "cn": {"Nick " + username}}},
+		{dn: "cn=" + group + ",ou=groups,dc=test", attrs: map[string][]string{"cn": {group}, "member": {userDN}}},
+		{dn: "cn=others,ou=groups,dc=test", attrs: map[string][]string{"cn": {"others"}, "member": {userDN}}},
+	})
+	defer m.ln.Close()
+
+	cfg := LdapConfig{
+		URL:          m.URL(),
+		BindDN:       "cn=search,dc=test",
+		BindPasswd:   "search",
+		BaseDN:       "dc=test",
+		GroupRoles:   map[string]int{group: ROLE_EDITOR},
+		CacheExpires: time.Minute,
+	}
+	lp, err := NewLdapProvider(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// not provisioned
+	if ok, err := lp.CheckPasswd(username, REALM, "ldappass"); err != nil || ok {
+		t.Fatalf("expect not provisioned, %v", err)
+	}
+	if m.Binds() != 0 {
+		t.Fatal("expect no bind for the unknow user")
+	}
+
+	cfg.AutoProvision = true
+	lp, err = NewLdapProvider(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := lp.CheckPasswd(username, REALM, "wrong"); err != nil || ok {
+		t.Fatalf("expect password failed, %v", err)
+	}
+	if ok, err := lp.CheckPasswd(username, REALM, ""); err != nil || ok {
+		t.Fatalf("expect empty password failed, %v", err)
+	}
+	if _, err := GetUser(username); err == nil {
+		t.Fatal("expect not provisioned after failed")
+	}
+	if ok, err := lp.CheckPasswd(username, REALM, "ldappass"); err != nil || !ok {
+		t.Fatalf("expect password passed, %v", err)
+	}
+	uInfo, err := GetUser(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uInfo.NickName != "Nick "+username || len(uInfo.Passwd) > 0 || len(uInfo.PasswdKdf) > 0 {
+		t.Fatalf("unexpect user: %+v", uInfo)
+	}
+	roles, err := UserRoleGroups(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles[group] != ROLE_EDITOR || len(roles) != 1 {
+		t.Fatalf("unexpect groups: %+v", roles)
+	}
+
+	// cached
+	binds := m.Binds()
+	if ok, err := lp.CheckPasswd(username, REALM, "ldappass"); err != nil || !ok {
+		t.Fatalf("expect cached, %v", err)
+	}
+	if m.Binds() != binds {
+		t.Fatal("expect no bind when cached")
+	}
+	if ok, err := lp.CheckPasswd(username, REALM, "wrong"); err != nil || ok {
+		t.Fatalf("expect password failed, %v", err)
+	}
+	DelAuthCache(username)
+	binds = m.Binds()
+	if ok, err := lp.CheckPasswd(username, REALM, "ldappass"); err != nil || !ok {
+		t.Fatalf("expect password passed, %v", err)
+	}
+	if m.Binds() == binds {
+		t.Fatal("expect bind after the cache deleted")
+	}
+
+	// the digest is not available.
+	if len(lp.Secret(username, REALM)) > 0 {
+		t.Fatal("expect no secret")
+	}
+
+	// disabled
+	if err := DisableUser(username, true); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := lp.CheckPasswd(username, REALM, "ldappass"); err != nil || ok {
+		t.Fatalf("expect disabled, %v", err)
+	}
+	if err := DisableUser(username, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server is down
+	DelAuthCache(username)
+	m.ln.Close()
+	if _, err := lp.CheckPasswd(username, REALM, "ldappass"); !ErrLdap.Equal(err) {
+		t.Fatalf("expect ErrLdap, but: %v", err)
+	}
+}
+
+func TestAuthProviders(t *testing.T) {
+	suffix := time.Now().UnixNano()
+	localUser := fmt.Sprintf("local_%d", suffix)
+	ldapUser := fmt.Sprintf("ldap_%d", suffix)
+	m := newMockLdap(t, []ldapEntry{
+		{dn: "cn=search,dc=test", passwd: "search"},
+		{dn: "uid=" + localUser + ",dc=test", passwd: "ldappass", attrs: map[string][]string{"uid": {localUser}}},
+		{dn: "uid=" + ldapUser + ",dc=test", passwd: "ldappass", attrs: map[string][]string{"uid": {ldapUser}}},
+	})
+	defer m.ln.Close()
+	lp, err := NewLdapProvider(LdapConfig{
+		URL:           m.URL(),
+		BindDN:        "cn=search,dc=test",
+		BindPasswd:    "search",
+		BaseDN:        "dc=test",
+		AutoProvision: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetAuthProviders(LocalProvider{}, lp)
+	defer SetAuthProviders(LocalProvider{})
+
+	ha1, kdf, err := makePasswd(localUser, REALM, "localpass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddUser(&UserInfo{ID: localUser, Passwd: ha1, PasswdKdf: kdf}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the local user is only authenticated by the local password.
+	if ok, err := AuthPasswd(localUser, REALM, "localpass"); err != nil || !ok {
+		t.Fatalf("expect local password passed, %v", err)
+	}
+	if ok, err := AuthPasswd(localUser, REALM, "ldappass"); err != nil || ok {
+		t.Fatalf("expect ldap password failed for the local user, %v", err)
+	}
+	if AuthSecret(localUser, REALM) != ha1 {
+		t.Fatal("expect the secret of local user")
+	}
+
+	if ok, err := AuthPasswd(ldapUser, REALM, "ldappass"); err != nil || !ok {
+		t.Fatalf("expect ldap password passed, %v", err)
+	}
+	if len(AuthSecret(ldapUser, REALM)) > 0 {
+		t.Fatal("expect no secret of ldap user")
+	}
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gwaylib/database"
+	"github.com/gwaylib/errors"
+)
+
+type LimitPolicy struct {
+	Times   int           // the login failures allowed before locking, the login is rejected after Times+1 failures
+	Backoff time.Duration // the lock time of each failure over the Times, and the time to forget the failures
+	// the login failures before the captcha is required by the session login, zero for disabled.
+	// The captcha is asked between the Captcha and Times failures, the login is still locked after the Times.
+	Captcha int
+}
+
+var (
+	limitPolicy   = LimitPolicy{Times: 4, Backoff: 30 * time.Minute, Captcha: 2}
+	limitPolicyLk sync.Mutex
+)
+
+func SetLimitPolicy(p LimitPolicy) {
+	limitPolicyLk.Lock()
+	defer limitPolicyLk.Unlock()
+	limitPolicy = p
+}
+
+func GetLimitPolicy() LimitPolicy {
+	limitPolicyLk.Lock()
+	defer limitPolicyLk.Unlock()
+	return limitPolicy
+}
+
+// AuthLimit is the login failures counter of a user from the ip.
+type AuthLimit struct {
+	ID        string `db:"id"`
+	UserID    string `db:"user_id"`
+	Ip        string `db:"ip"` // see ClientIp
+	Times     int    `db:"times"`
+	UpdatedAt int64  `db:"updated_at"` // unix seconds
+	ExpiredAt int64  `db:"expired_at"` // unix seconds, the failures will be forgot after it
+}
+
+// Locked returns true if the login is rejected by the failures.
+func (l *AuthLimit) Locked() bool {
+	return l.Times > GetLimitPolicy().Times && l.ExpiredAt > time.Now().Unix()
+}
+
+// needCaptcha returns true if the session login of the failure times needs the captcha.
+func needCaptcha(times int) bool {
+	p := GetLimitPolicy()
+	return p.Captcha > 0 && times >= p.Captcha
+}
+
+// the key for counting the login failures.
+func authLimitKey(req *http.Request, username string) *AuthLimit {
+	ip := ClientIp(req)
+	return &AuthLimit{
+		ID:     username + "_" + ip,
+		UserID: username,
+		Ip:     ip,
+	}
+}
+
+// getAuthLimit returns the unexpired failure times of the limit key.
+func getAuthLimit(l *AuthLimit) (int, error) {
+	times := 0
+	db := GetDB()
+	if err := database.QueryElem(db, &times,
+		"SELECT times FROM auth_limit WHERE id=? AND expired_at>?",
+		l.ID, time.Now().Unix(),
+	); err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return 0, nil
+		}
+		return 0, errors.As(err, l.ID)
+	}
+	return times, nil
+}
+
+// updateAuthLimit records the failure times, and zero for cleaning the failures.
+// The failures are forgot after the backoff, and the lock time increases by the backoff with each failure over the limit.
+func updateAuthLimit(l *AuthLimit, times int) error {
+	db := GetDB()
+	if times <= 0 {
+		if _, err := db.Exec("DELETE FROM auth_limit WHERE id=?", l.ID); err != nil {
+			return errors.As(err, l.ID)
+		}
+		return nil
+	}
+
+	p := GetLimitPolicy()
+	waitTime := p.Backoff
+	if times > p.Times {
+		waitTime = p.Backoff * time.Duration(times-p.Times)
+	}
+	now := time.Now()
+	if _, err := db.Exec(`
+INSERT INTO auth_limit(id,user_id,ip,times,updated_at,expired_at)VALUES(?,?,?,?,?,?)
+ON CONFLICT(id) DO UPDATE SET times=excluded.times,updated_at=excluded.updated_at,expired_at=excluded.expired_at
+`, l.ID, l.UserID, l.Ip, times, now.Unix(), now.Add(waitTime).Unix(),
+	); err != nil {
+		return errors.As(err, l.ID)
+	}
+	return nil
+}
+
+// CheckPasswdLimited is CheckPasswd of the user from the request, and the failures are counted as the login,
+// so the password can not be guessed by a stolen session or token.
+// ErrReject will be returned if there are too many login failures.
+func CheckPasswdLimited(req *http.Request, username, realm, passwd string) (bool, error) {
+	limitKey := authLimitKey(req, username)
+	errTimes, err := getAuthLimit(limitKey)
+	if err != nil {
+		return false, errors.As(err)
+	}
+	if errTimes > GetLimitPolicy().Times {
+		return false, ErrReject.As(limitKey.ID, errTimes)
+	}
+	ok, err := CheckPasswd(username, realm, passwd)
+	if err != nil {
+		return false, errors.As(err)
+	}
+	if !ok {
+		if err := updateAuthLimit(limitKey, errTimes+1); err != nil {
+			return false, errors.As(err)
+		}
+		return false, nil
+	}
+	if err := updateAuthLimit(limitKey, 0); err != nil {
+		return false, errors.As(err)
+	}
+	return true, nil
+}
+
+// ListAuthLimits returns the unexpired failure counters, only the locked when lockedOnly is true.
+func ListAuthLimits(lockedOnly bool) ([]AuthLimit, error) {
+	now := time.Now().Unix()
+	db := GetDB()
+	if _, err := db.Exec("DELETE FROM auth_limit WHERE expired_at<=?", now); err != nil {
+		return nil, errors.As(err)
+	}
+	minTimes := 0
+	if lockedOnly {
+		minTimes = GetLimitPolicy().Times
+	}
+	result := []AuthLimit{}
+	if err := database.QueryStructs(db, &result,
+		"SELECT id,user_id,ip,times,updated_at,expired_at FROM auth_limit WHERE times>? ORDER BY updated_at DESC",
+		minTimes,
+	); err != nil {
+		return nil, errors.As(err)
+	}
+	return result, nil
+}
+
+// UnlockAuth cleans the failures of the username or the ip, both of them are matched when they are not empty,
+// and returns the number of the cleaned counters.
+func UnlockAuth(username, ip string) (int64, error) {
+	if len(username) == 0 && len(ip) == 0 {
+		return 0, errors.New("need username or ip")
+	}
+	db := GetDB()
+	result, err := db.Exec(
+		"DELETE FROM auth_limit WHERE (?='' OR user_id=?) AND (?='' OR ip=?)",
+		username, username, ip, ip,
+	)
+	if err != nil {
+		return 0, errors.As(err, username, ip)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.As(err)
+	}
+	return n, nil
+}
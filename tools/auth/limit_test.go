@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthLimit(t *testing.T) {
+	username := fmt.Sprintf("limit_%d", time.Now().UnixNano())
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.10:1234"
+	l := authLimitKey(req, username)
+	if l.UserID != username || l.Ip != "192.0.2.10" {
+		t.Fatalf("unexpect limit key: %+v", l)
+	}
+
+	times := GetLimitPolicy().Times + 1
+	if err := updateAuthLimit(l, times); err != nil {
+		t.Fatal(err)
+	}
+	errTimes, err := getAuthLimit(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errTimes != times {
+		t.Fatalf("expect %d, but: %d", times, errTimes)
+	}
+	limits, err := ListAuthLimits(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, item := range limits {
+		if item.ID == l.ID {
+			found = item.Locked()
+		}
+	}
+	if !found {
+		t.Fatalf("expect locked: %+v", limits)
+	}
+
+	// unlock by ip
+	if n, err := UnlockAuth("", "192.0.2.1"); err != nil || n != 0 {
+		t.Fatalf("expect nothing unlocked, %d %v", n, err)
+	}
+	if n, err := UnlockAuth(username, "192.0.2.10"); err != nil || n != 1 {
+		t.Fatalf("expect unlocked, %d %v", n, err)
+	}
+	if errTimes, err := getAuthLimit(l); err != nil || errTimes != 0 {
+		t.Fatalf("expect cleaned, %d %v", errTimes, err)
+	}
+	if _, err := UnlockAuth("", ""); err == nil {
+		t.Fatal("expect error of empty condition")
+	}
+}
+
+func TestAuthLimitExpired(t *testing.T) {
+	old := GetLimitPolicy()
+	defer SetLimitPolicy(old)
+	SetLimitPolicy(LimitPolicy{Times: 1, Backoff: -time.Second})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	l := authLimitKey(req, fmt.Sprintf("expired_%d", time.Now().UnixNano()))
+	if err := updateAuthLimit(l, 3); err != nil {
+		t.Fatal(err)
+	}
+	if errTimes, err := getAuthLimit(l); err != nil || errTimes != 0 {
+		t.Fatalf("expect expired, %d %v", errTimes, err)
+	}
+}
+
+func TestCheckPasswdLimited(t *testing.T) {
+	old := GetLimitPolicy()
+	defer SetLimitPolicy(old)
+	SetLimitPolicy(LimitPolicy{Times: 1, Backoff: time.Hour})
+
+	username := fmt.Sprintf("limited_%d", time.Now().UnixNano())
+	if err := AddUser(&UserInfo{ID: username, Passwd: HashPasswd(username, REALM, "hello")}); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/user/pwd/change", nil)
+	req.RemoteAddr = "192.0.2.20:1234"
+	for i := 0; i < 2; i++ {
+		if ok, err := CheckPasswdLimited(req, username, REALM, "bad"); err != nil || ok {
+			t.Fatalf("expect not match, %v %v", ok, err)
+		}
+	}
+	// the right password is rejected after too many failures
+	if _, err := CheckPasswdLimited(req, username, REALM, "hello"); !ErrReject.Equal(err) {
+		t.Fatalf("expect ErrReject, but: %v", err)
+	}
+	if _, err := UnlockAuth(username, ""); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := CheckPasswdLimited(req, username, REALM, "hello"); err != nil || !ok {
+		t.Fatalf("expect match, %v %v", ok, err)
+	}
+}
@@ -0,0 +1,19 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "mdoc-auth-test")
+	if err != nil {
+		panic(err)
+	}
+	InitDB(filepath.Join(dir, "mdoc.db"))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
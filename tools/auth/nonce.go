@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	httpauth "github.com/abbot/go-http-auth"
+	"github.com/gwaylib/database"
+	"github.com/gwaylib/errors"
+)
+
+const (
+	_SYS_CFG_DIGEST_OPAQUE       = "digest_opaque"
+	_SYS_CFG_DIGEST_NONCE_SECRET = "digest_nonce_secret"
+
+	// how long the nonce of challenge can be used the first time,
+	// the nonce is not stored until the first valid use, so the anonymous requests can not fill the store.
+	_DIGEST_NONCE_EXPIRES = time.Hour
+)
+
+// NonceStore keeps the state of the digest server,
+// the state should be kept by a persistent store
+// so the clients need not login again after the server restarted.
+type NonceStore interface {
+	// Return the opaque of the server, it should be generated once and kept it.
+	Opaque() (string, error)
+
+	// Return the secret for signing the nonces, it should be generated once and kept it.
+	Secret() (string, error)
+
+	// Store the nonce with the last nonce-count of the client.
+	PutNonce(nonce string, nc uint64, lastSeen int64) error
+
+	// Return the last nonce-count of the nonce,
+	// errors.ErrNoData will be returned if the nonce not found.
+	GetNonce(nonce string) (nc uint64, err error)
+
+	// Remove the nonces which last seen before the time.
+	Purge(before int64) error
+}
+
+type memNonce struct {
+	nc       uint64
+	lastSeen int64
+}
+
+type memNonceStore struct {
+	opaque string
+	secret string
+	nonces map[string]*memNonce
+	mutex  sync.Mutex
+}
+
+// NewMemNonceStore returns a NonceStore in memory, it will lost after the server restart.
+func NewMemNonceStore() NonceStore {
+	return &memNonceStore{
+		opaque: httpauth.RandomKey(),
+		secret: httpauth.RandomKey() + httpauth.RandomKey(),
+		nonces: map[string]*memNonce{},
+	}
+}
+
+func (m *memNonceStore) Opaque() (string, error) {
+	return m.opaque, nil
+}
+
+func (m *memNonceStore) Secret() (string, error) {
+	return m.secret, nil
+}
+
+func (m *memNonceStore) PutNonce(nonce string, nc uint64, lastSeen int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.nonces[nonce] = &memNonce{nc: nc, lastSeen: lastSeen}
+	return nil
+}
+
+func (m *memNonceStore) GetNonce(nonce string) (uint64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	n, ok := m.nonces[nonce]
+	if !ok {
+		return 0, errors.ErrNoData.As(nonce)
+	}
+	return n.nc, nil
+}
+
+func (m *memNonceStore) Purge(before int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for key, n := range m.nonces {
+		if n.lastSeen < before {
+			delete(m.nonces, key)
+		}
+	}
+	return nil
+}
+
+type dbNonceStore struct{}
+
+// NewDBNonceStore returns a NonceStore with the sqlite db, the InitDB should be called before using.
+func NewDBNonceStore() NonceStore {
+	return &dbNonceStore{}
+}
+
+func (d *dbNonceStore) Opaque() (string, error) {
+	dbGlobalLk.Lock()
+	defer dbGlobalLk.Unlock()
+
+	opaque, err := GetSysCfg(_SYS_CFG_DIGEST_OPAQUE)
+	if err == nil {
+		return opaque, nil
+	}
+	if !errors.ErrNoData.Equal(err) {
+		return "", errors.As(err)
+	}
+	opaque = httpauth.RandomKey()
+	if err := PutSysCfg(_SYS_CFG_DIGEST_OPAQUE, opaque); err != nil {
+		return "", errors.As(err)
+	}
+	return opaque, nil
+}
+
+func (d *dbNonceStore) Secret() (string, error) {
+	dbGlobalLk.Lock()
+	defer dbGlobalLk.Unlock()
+
+	secret, err := GetSysCfg(_SYS_CFG_DIGEST_NONCE_SECRET)
+	if err == nil {
+		return secret, nil
+	}
+	if !errors.ErrNoData.Equal(err) {
+		return "", errors.As(err)
+	}
+	secret = httpauth.RandomKey() + httpauth.RandomKey()
+	if err := PutSysCfg(_SYS_CFG_DIGEST_NONCE_SECRET, secret); err != nil {
+		return "", errors.As(err)
+	}
+	return secret, nil
+}
+
+func (d *dbNonceStore) PutNonce(nonce string, nc uint64, lastSeen int64) error {
+	db := GetDB()
+	if _, err := db.Exec(
+		"INSERT INTO digest_nonce(nonce,nc,last_seen)VALUES(?,?,?) ON CONFLICT(nonce) DO UPDATE SET nc=excluded.nc,last_seen=excluded.last_seen",
+		nonce, int64(nc), lastSeen,
+	); err != nil {
+		return errors.As(err, nonce)
+	}
+	return nil
+}
+
+func (d *dbNonceStore) GetNonce(nonce string) (uint64, error) {
+	nc := int64(0)
+	db := GetDB()
+	if err := database.QueryElem(db, &nc, "SELECT nc FROM digest_nonce WHERE nonce=?", nonce); err != nil {
+		return 0, errors.As(err, nonce)
+	}
+	return uint64(nc), nil
+}
+
+func (d *dbNonceStore) Purge(before int64) error {
+	db := GetDB()
+	if _, err := db.Exec("DELETE FROM digest_nonce WHERE last_seen<?", before); err != nil {
+		return errors.As(err)
+	}
+	return nil
+}
+
+func nonceSign(secret, issued, key string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(issued + "." + key))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newNonce returns a nonce of "issued unix seconds" + "." + random key + "." + HMAC-SHA256,
+// so it need not be stored before the client uses it.
+func newNonce(secret string, now time.Time) string {
+	issued := strconv.FormatInt(now.Unix(), 10)
+	key := base64.RawURLEncoding.EncodeToString([]byte(httpauth.RandomKey()))
+	return issued + "." + key + "." + nonceSign(secret, issued, key)
+}
+
+// validNonce returns true if the nonce is signed by the secret and not expired for the first use.
+func validNonce(secret, nonce string, now time.Time) bool {
+	parts := strings.Split(nonce, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	unix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return false
+	}
+	if issued := time.Unix(unix, 0); now.Sub(issued) > _DIGEST_NONCE_EXPIRES || issued.After(now.Add(time.Minute)) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(nonceSign(secret, parts[0], parts[1])), []byte(parts[2])) == 1
+}
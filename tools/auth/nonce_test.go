@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	httpauth "github.com/abbot/go-http-auth"
+	"github.com/gwaylib/errors"
+)
+
+func digestAuthorization(challenge, method, uri, user, passwd string, nc int) string {
+	params := httpauth.DigestAuthParams(challenge)
+	ncStr := fmt.Sprintf("%08x", nc)
+	cnonce := "0a4f113b"
+	ha1 := HashPasswd(user, params["realm"], passwd)
+	ha2 := httpauth.H(method + ":" + uri)
+	response := httpauth.H(strings.Join([]string{ha1, params["nonce"], ncStr, cnonce, params["qop"], ha2}, ":"))
+	return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=MD5, response="%s", opaque="%s", qop=%s, nc=%s, cnonce="%s"`,
+		user, params["realm"], params["nonce"], uri, response, params["opaque"], params["qop"], ncStr, cnonce)
+}
+
+func TestDBNonceStore(t *testing.T) {
+	secret := func(user, realm string) string {
+		return HashPasswd(user, realm, "hello")
+	}
+
+	da := NewDigestAuth(REALM, false, secret, NewDBNonceStore())
+	w := httptest.NewRecorder()
+	da.RequireAuth(w, httptest.NewRequest("GET", "/markdown/README.md", nil))
+	if w.Code != 401 {
+		t.Fatalf("expect 401, but: %d", w.Code)
+	}
+	challenge := w.Header().Get("WWW-Authenticate")
+
+	newReq := func(nc int) *http.Request {
+		req := httptest.NewRequest("GET", "/markdown/README.md", nil)
+		req.Header.Set("Authorization", digestAuthorization(challenge, "GET", "/markdown/README.md", "nonce_test", "hello", nc))
+		return req
+	}
+	if _, err := da.CheckAuth(newReq(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate the server restarted.
+	da = NewDigestAuth(REALM, false, secret, NewDBNonceStore())
+	if _, err := da.CheckAuth(newReq(2)); err != nil {
+		t.Fatal(err)
+	}
+	// replay the nonce count
+	if _, err := da.CheckAuth(newReq(2)); !ErrNeedPwd.Equal(err) {
+		t.Fatalf("expect ErrNeedPwd, but: %v", err)
+	}
+
+	// the memory store will lost the state
+	da = NewDigestAuth(REALM, false, secret, NewMemNonceStore())
+	if _, err := da.CheckAuth(newReq(3)); !ErrNeedPwd.Equal(err) {
+		t.Fatalf("expect ErrNeedPwd, but: %v", err)
+	}
+}
+
+func TestSignedNonce(t *testing.T) {
+	secret := func(user, realm string) string {
+		return HashPasswd(user, realm, "hello")
+	}
+	store := NewMemNonceStore()
+	da := NewDigestAuth(REALM, false, secret, store)
+	w := httptest.NewRecorder()
+	da.RequireAuth(w, httptest.NewRequest("GET", "/markdown/README.md", nil))
+	challenge := w.Header().Get("WWW-Authenticate")
+	nonce := httpauth.DigestAuthParams(challenge)["nonce"]
+
+	// the challenge of anonymous request is not stored
+	if _, err := store.GetNonce(nonce); !errors.ErrNoData.Equal(err) {
+		t.Fatalf("expect the nonce not stored, but: %v", err)
+	}
+	req := httptest.NewRequest("GET", "/markdown/README.md", nil)
+	req.Header.Set("Authorization", digestAuthorization(challenge, "GET", "/markdown/README.md", "nonce_test", "hello", 1))
+	if _, err := da.CheckAuth(req); err != nil {
+		t.Fatal(err)
+	}
+	if nc, err := store.GetNonce(nonce); err != nil || nc != 1 {
+		t.Fatalf("expect the nonce stored at the first use, %d %v", nc, err)
+	}
+
+	// forged or expired nonces are unknown
+	key, err := store.Secret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if !validNonce(key, newNonce(key, now), now) {
+		t.Fatal("expect valid nonce")
+	}
+	if validNonce(key, newNonce(key, now.Add(-2*_DIGEST_NONCE_EXPIRES)), now) {
+		t.Fatal("expect the nonce expired")
+	}
+	if validNonce(key, newNonce("other", now), now) {
+		t.Fatal("expect the nonce of other secret invalid")
+	}
+	forged := strings.Replace(challenge, nonce, nonce[:len(nonce)-2]+"xx", 1)
+	req = httptest.NewRequest("GET", "/markdown/README.md", nil)
+	req.Header.Set("Authorization", digestAuthorization(forged, "GET", "/markdown/README.md", "nonce_test", "hello", 1))
+	if _, err := da.CheckAuth(req); !ErrNeedPwd.Equal(err) {
+		t.Fatalf("expect ErrNeedPwd, but: %v", err)
+	}
+}
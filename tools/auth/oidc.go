@@ -0,0 +1,468 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gwaylib/errors"
+)
+
+const (
+	OIDC_STATE_COOKIE = "mdoc_oidc_state"
+
+	_OIDC_STATE_HEAD    = "oidc_state_%s"
+	_OIDC_STATE_EXPIRES = 600 // seconds to finish the login in the identity provider
+	_OIDC_JWKS_INTERVAL = time.Minute
+	_OIDC_LEEWAY        = 60 // seconds of the clock skew
+)
+
+var (
+	ErrOidc = errors.New("OIDC login failed")
+)
+
+type OidcConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string   // the callback url of mdoc, e.g. "https://doc.example.com/login/oidc/callback"
+	Scopes       []string // "openid" is always requested
+
+	UsernameClaim string         // the claim of username, default is "preferred_username"
+	GroupsClaim   string         // the claim of groups, default is "groups"
+	GroupRoles    map[string]int // the groups of identity provider to sync, the mdoc group of the same name has the role
+	AutoProvision bool           // add the user when it is not found
+}
+
+// ParseGroupRoles parses the group roles like "idp-admins=admin,writers=editor".
+func ParseGroupRoles(str string) (map[string]int, error) {
+	result := map[string]int{}
+	for _, item := range strings.Split(str, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || len(strings.TrimSpace(kv[0])) == 0 {
+			return nil, errors.New("need group=role").As(item)
+		}
+		role := ParseRole(strings.TrimSpace(kv[1]))
+		if role == 0 {
+			return nil, errors.New("unknow role").As(item)
+		}
+		result[strings.TrimSpace(kv[0])] = role
+	}
+	return result, nil
+}
+
+type oidcState struct {
+	Verifier string
+	Nonce    string
+	Redirect string
+}
+
+// OidcAuth is the OpenID Connect login with the authorization code and PKCE,
+// the user who passed the login gets the session of SessionAuth.
+type OidcAuth struct {
+	cfg    OidcConfig
+	client *http.Client
+
+	authEndpoint  string
+	tokenEndpoint string
+	jwksURI       string
+
+	mutex     sync.Mutex
+	keys      map[string]crypto.PublicKey
+	lastFetch time.Time
+}
+
+// NewOidcAuth reads the configuration of the issuer by the discovery,
+// the http.DefaultClient is used when client is nil.
+func NewOidcAuth(cfg OidcConfig, client *http.Client) (*OidcAuth, error) {
+	if len(cfg.Issuer) == 0 || len(cfg.ClientID) == 0 || len(cfg.RedirectURL) == 0 {
+		return nil, errors.New("need issuer, client id and redirect url")
+	}
+	if len(cfg.UsernameClaim) == 0 {
+		cfg.UsernameClaim = "preferred_username"
+	}
+	if len(cfg.GroupsClaim) == 0 {
+		cfg.GroupsClaim = "groups"
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	o := &OidcAuth{cfg: cfg, client: client}
+
+	discovery := struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JwksURI               string `json:"jwks_uri"`
+	}{}
+	if err := o.getJson(strings.TrimSuffix(cfg.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, errors.As(err)
+	}
+	if discovery.Issuer != cfg.Issuer {
+		return nil, errors.New("issuer not match").As(discovery.Issuer, cfg.Issuer)
+	}
+	if len(discovery.AuthorizationEndpoint) == 0 || len(discovery.TokenEndpoint) == 0 || len(discovery.JwksURI) == 0 {
+		return nil, errors.New("incomplete discovery").As(discovery)
+	}
+	o.authEndpoint = discovery.AuthorizationEndpoint
+	o.tokenEndpoint = discovery.TokenEndpoint
+	o.jwksURI = discovery.JwksURI
+	return o, nil
+}
+
+func (o *OidcAuth) getJson(uri string, result interface{}) error {
+	resp, err := o.client.Get(uri)
+	if err != nil {
+		return errors.As(err, uri)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return errors.New(resp.Status).As(uri, readHttpResp(resp))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return errors.As(err, uri)
+	}
+	return nil
+}
+
+func randBase64(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.As(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthURL returns the url of identity provider to login, the state is bound to the browser by cookie,
+// and the user will be redirected to the local uri of redirect after login.
+func (o *OidcAuth) AuthURL(w http.ResponseWriter, req *http.Request, redirect string) (string, error) {
+	state, err := randBase64(24)
+	if err != nil {
+		return "", errors.As(err)
+	}
+	verifier, err := randBase64(32)
+	if err != nil {
+		return "", errors.As(err)
+	}
+	nonce, err := randBase64(24)
+	if err != nil {
+		return "", errors.As(err)
+	}
+	authCache.Put(fmt.Sprintf(_OIDC_STATE_HEAD, state), &oidcState{
+		Verifier: verifier,
+		Nonce:    nonce,
+		Redirect: redirect,
+	}, _OIDC_STATE_EXPIRES)
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDC_STATE_COOKIE,
+		Value:    state,
+		Path:     "/login/oidc",
+		MaxAge:   _OIDC_STATE_EXPIRES,
+		Secure:   req.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	scopes := []string{"openid"}
+	for _, s := range o.cfg.Scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.cfg.ClientID},
+		"redirect_uri":          {o.cfg.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(o.authEndpoint, "?") {
+		sep = "&"
+	}
+	return o.authEndpoint + sep + params.Encode(), nil
+}
+
+// Callback finishes the login of the identity provider, and returns the local uri to redirect.
+// ErrOidc will be returned if the response of identity provider is invalid,
+// ErrNotProvisioned will be returned if the user not found and the AutoProvision is false,
+// ErrLocalUser will be returned if the user has a local password,
+// ErrReject will be returned if the user is disabled.
+func (o *OidcAuth) Callback(w http.ResponseWriter, req *http.Request, sa *SessionAuth) (string, error) {
+	query := req.URL.Query()
+	if e := query.Get("error"); len(e) > 0 {
+		return "", ErrOidc.As(e, query.Get("error_description"))
+	}
+	state := query.Get("state")
+	cookie, err := req.Cookie(OIDC_STATE_COOKIE)
+	if err != nil || len(state) == 0 || cookie.Value != state {
+		return "", ErrOidc.As("state not match")
+	}
+	http.SetCookie(w, &http.Cookie{Name: OIDC_STATE_COOKIE, Value: "", Path: "/login/oidc", MaxAge: -1, HttpOnly: true})
+	key := fmt.Sprintf(_OIDC_STATE_HEAD, state)
+	val := authCache.Get(key)
+	if val == nil {
+		return "", ErrOidc.As("state expired")
+	}
+	authCache.Delete(key)
+	st := val.(*oidcState)
+
+	idToken, err := o.exchange(query.Get("code"), st.Verifier)
+	if err != nil {
+		return "", errors.As(err)
+	}
+	claims, err := o.verifyIdToken(idToken, time.Now())
+	if err != nil {
+		return "", errors.As(err)
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != st.Nonce {
+		return "", ErrOidc.As("nonce not match")
+	}
+	username, err := o.syncUser(claims)
+	if err != nil {
+		return "", errors.As(err)
+	}
+	if err := sa.startSession(w, req, username, "oidc"); err != nil {
+		return "", errors.As(err)
+	}
+	return st.Redirect, nil
+}
+
+// exchange the code for the id token.
+func (o *OidcAuth) exchange(code, verifier string) (string, error) {
+	if len(code) == 0 {
+		return "", ErrOidc.As("need code")
+	}
+	params := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.cfg.RedirectURL},
+		"client_id":     {o.cfg.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest("POST", o.tokenEndpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return "", errors.As(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if len(o.cfg.ClientSecret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(o.cfg.ClientID), url.QueryEscape(o.cfg.ClientSecret))
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", errors.As(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", ErrOidc.As(resp.Status, readHttpResp(resp))
+	}
+	token := struct {
+		IdToken string `json:"id_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", errors.As(err)
+	}
+	if len(token.IdToken) == 0 {
+		return "", ErrOidc.As("need id_token")
+	}
+	return token.IdToken, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, errors.As(err)
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, errors.As(err, k.Kid)
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, errors.As(err, k.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, errors.New("unsupported curve").As(k.Kid, k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, errors.As(err, k.Kid)
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, errors.As(err, k.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, errors.New("unsupported key type").As(k.Kid, k.Kty)
+}
+
+// getKey returns the key of jwks, the jwks is fetched again when the key not found for the key rotation.
+func (o *OidcAuth) getKey(kid string) (crypto.PublicKey, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(o.lastFetch) < _OIDC_JWKS_INTERVAL {
+		return nil, ErrOidc.As("key not found", kid)
+	}
+	o.lastFetch = time.Now()
+
+	jwks := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := o.getJson(o.jwksURI, &jwks); err != nil {
+		return nil, errors.As(err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range jwks.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			// skip the keys for other usages.
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	o.keys = keys
+	key, ok := o.keys[kid]
+	if !ok {
+		return nil, ErrOidc.As("key not found", kid)
+	}
+	return key, nil
+}
+
+// verifyIdToken checks the signature of RS256 or ES256, and the issuer, audience and the expiration.
+func (o *OidcAuth) verifyIdToken(token string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrOidc.As("invalid id_token")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrOidc.As("invalid id_token header")
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, ErrOidc.As("invalid id_token header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrOidc.As("invalid id_token signature")
+	}
+	key, err := o.getKey(header.Kid)
+	if err != nil {
+		return nil, errors.As(err)
+	}
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], sig) != nil {
+			return nil, ErrOidc.As("invalid id_token signature", header.Alg)
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 ||
+			!ecdsa.Verify(pub, hashed[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return nil, ErrOidc.As("invalid id_token signature", header.Alg)
+		}
+	default:
+		return nil, ErrOidc.As("unsupported alg", header.Alg)
+	}
+
+	data, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrOidc.As("invalid id_token payload")
+	}
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, ErrOidc.As("invalid id_token payload")
+	}
+	if iss, _ := claims["iss"].(string); iss != o.cfg.Issuer {
+		return nil, ErrOidc.As("issuer not match", iss)
+	}
+	audOk := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audOk = aud == o.cfg.ClientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == o.cfg.ClientID {
+				audOk = true
+			}
+		}
+	}
+	if !audOk {
+		return nil, ErrOidc.As("audience not match", claims["aud"])
+	}
+	exp, _ := claims["exp"].(float64)
+	if int64(exp)+_OIDC_LEEWAY < now.Unix() {
+		return nil, ErrOidc.As("id_token expired")
+	}
+	return claims, nil
+}
+
+// syncUser maps the claims to the user and groups, and returns the username.
+func (o *OidcAuth) syncUser(claims map[string]interface{}) (string, error) {
+	username, _ := claims[o.cfg.UsernameClaim].(string)
+	if len(username) == 0 {
+		return "", ErrOidc.As("need claim", o.cfg.UsernameClaim)
+	}
+	if err := CheckUserName(username); err != nil {
+		return "", ErrOidc.As("invalid username of claim", o.cfg.UsernameClaim, username)
+	}
+	nickName, _ := claims["name"].(string)
+
+	groups := []string{}
+	if values, ok := claims[o.cfg.GroupsClaim].([]interface{}); ok {
+		for _, g := range values {
+			if name, ok := g.(string); ok {
+				groups = append(groups, name)
+			}
+		}
+	}
+	if err := syncExternalUser("oidc", username, nickName, groups, o.cfg.GroupRoles, o.cfg.AutoProvision); err != nil {
+		return "", errors.As(err)
+	}
+	return username, nil
+}
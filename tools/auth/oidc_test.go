@@ -0,0 +1,358 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signJwt(t *testing.T, key crypto.Signer, kid string, claims map[string]interface{}) string {
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signing := b64(header) + "." + b64(payload)
+	hashed := sha256.Sum256([]byte(signing))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		s, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hashed[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = s
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, hashed[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signing + "." + b64(sig)
+}
+
+// mockIssuer is a identity provider for testing.
+type mockIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	mutex     sync.Mutex
+	challenge string
+	claims    map[string]interface{}
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{t: t, rsaKey: rsaKey, ecKey: ecKey}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/auth",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{"kty": "RSA", "kid": "rsa1", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+				{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+			},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		user, passwd, _ := r.BasicAuth()
+		verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if user != "mdoc" || passwd != "secret" || r.FormValue("code") != "good-code" || b64(verifier[:]) != m.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, 400)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     signJwt(t, m.rsaKey, "rsa1", m.claims),
+		})
+	})
+	m.server = httptest.NewServer(mux)
+	return m
+}
+
+// login in the identity provider, and returns the callback request.
+func (m *mockIssuer) login(o *OidcAuth, username string, groups []string) (*http.Request, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	authURL, err := o.AuthURL(w, httptest.NewRequest("GET", "/login/oidc", nil), "/markdown/a.md")
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "mdoc" || !strings.Contains(q.Get("scope"), "openid") {
+		m.t.Fatalf("unexpect auth url: %s", authURL)
+	}
+
+	m.mutex.Lock()
+	m.challenge = q.Get("code_challenge")
+	m.claims = map[string]interface{}{
+		"iss":                m.server.URL,
+		"aud":                "mdoc",
+		"sub":                "id-" + username,
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              q.Get("nonce"),
+		"preferred_username": username,
+		"name":               "Nick " + username,
+		"groups":             groups,
+	}
+	m.mutex.Unlock()
+
+	req := httptest.NewRequest("GET", "/login/oidc/callback?code=good-code&state="+url.QueryEscape(q.Get("state")), nil)
+	req.Header.Set("Cookie", strings.Split(w.Header().Get("Set-Cookie"), ";")[0])
+	return req, httptest.NewRecorder()
+}
+
+func TestOidcLogin(t *testing.T) {
+	m := newMockIssuer(t)
+	defer m.server.Close()
+
+	group := fmt.Sprintf("oidc_writers_%d", time.Now().UnixNano())
+	cfg := OidcConfig{
+		Issuer:       m.server.URL,
+		ClientID:     "mdoc",
+		ClientSecret: "secret",
+		RedirectURL:  "http://mdoc.test/login/oidc/callback",
+		GroupRoles:   map[string]int{group: ROLE_EDITOR},
+	}
+	o, err := NewOidcAuth(cfg, m.server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sa, err := NewSessionAuth(REALM, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	username := fmt.Sprintf("oidc_%d", time.Now().UnixNano())
+
+	// not provisioned
+	req, w := m.login(o, username, []string{group})
+	if _, err := o.Callback(w, req, sa); !ErrNotProvisioned.Equal(err) {
+		t.Fatalf("expect ErrNotProvisioned, but: %v", err)
+	}
+
+	// auto provision
+	cfg.AutoProvision = true
+	o, err = NewOidcAuth(cfg, m.server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, w = m.login(o, username, []string{group, "others"})
+	redirect, err := o.Callback(w, req, sa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redirect != "/markdown/a.md" {
+		t.Fatalf("unexpect redirect: %s", redirect)
+	}
+	cookie := ""
+	for _, c := range w.Result().Cookies() {
+		if c.Name == SESSION_COOKIE_NAME {
+			cookie = c.Name + "=" + c.Value
+		}
+	}
+	checkReq := httptest.NewRequest("GET", "/", nil)
+	checkReq.Header.Set("Cookie", cookie)
+	if name, err := sa.CheckAuth(checkReq); err != nil || name != username {
+		t.Fatalf("expect session of %s, but: %s %v", username, name, err)
+	}
+	uInfo, err := GetUser(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uInfo.NickName != "Nick "+username {
+		t.Fatalf("unexpect user: %+v", uInfo)
+	}
+	roles, err := UserRoleGroups(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles[group] != ROLE_EDITOR || len(roles) != 1 {
+		t.Fatalf("unexpect groups: %+v", roles)
+	}
+	// the password login is not allowed.
+	if ok, err := CheckPasswd(username, REALM, ""); err != nil || ok {
+		t.Fatalf("expect password login failed, %v", err)
+	}
+
+	// the groups are synced at each login.
+	req, w = m.login(o, username, nil)
+	if _, err := o.Callback(w, req, sa); err != nil {
+		t.Fatal(err)
+	}
+	if roles, err := UserRoleGroups(username); err != nil || len(roles) != 0 {
+		t.Fatalf("expect left the group, %+v %v", roles, err)
+	}
+
+	// the state is single use.
+	if _, err := o.Callback(httptest.NewRecorder(), req, sa); !ErrOidc.Equal(err) {
+		t.Fatalf("expect ErrOidc, but: %v", err)
+	}
+	// the state is bound to the browser.
+	req, w = m.login(o, username, nil)
+	req.Header.Set("Cookie", OIDC_STATE_COOKIE+"=other")
+	if _, err := o.Callback(w, req, sa); !ErrOidc.Equal(err) {
+		t.Fatalf("expect ErrOidc, but: %v", err)
+	}
+
+	// the local user with password can not be taken over by the same name of the provider.
+	local := fmt.Sprintf("oidc_local_%d", time.Now().UnixNano())
+	if err := AddUser(&UserInfo{ID: local, Passwd: HashPasswd(local, REALM, "hello")}); err != nil {
+		t.Fatal(err)
+	}
+	req, w = m.login(o, local, []string{group})
+	if _, err := o.Callback(w, req, sa); !ErrLocalUser.Equal(err) {
+		t.Fatalf("expect ErrLocalUser, but: %v", err)
+	}
+	if roles, err := UserRoleGroups(local); err != nil || len(roles) != 0 {
+		t.Fatalf("expect the groups not synced, %+v %v", roles, err)
+	}
+	kdfUser := &UserInfo{ID: local + "_kdf"}
+	if err := kdfUser.SetPlainPasswd(REALM, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	kdfUser.Passwd = ""
+	if err := AddUser(kdfUser); err != nil {
+		t.Fatal(err)
+	}
+	req, w = m.login(o, kdfUser.ID, nil)
+	if _, err := o.Callback(w, req, sa); !ErrLocalUser.Equal(err) {
+		t.Fatalf("expect ErrLocalUser of kdf, but: %v", err)
+	}
+	// the username of claim is checked
+	req, w = m.login(o, "bad name", nil)
+	if _, err := o.Callback(w, req, sa); !ErrOidc.Equal(err) {
+		t.Fatalf("expect ErrOidc, but: %v", err)
+	}
+
+	// the nickname of claim is cleaned before stored.
+	req, w = m.login(o, username, nil)
+	m.mutex.Lock()
+	m.claims["name"] = "Bad\nNick " + strings.Repeat("x", NICK_NAME_MAX_LEN)
+	m.mutex.Unlock()
+	if _, err := o.Callback(w, req, sa); err != nil {
+		t.Fatal(err)
+	}
+	if uInfo, err := GetUser(username); err != nil || CheckNickName(uInfo.NickName) != nil || !strings.HasPrefix(uInfo.NickName, "BadNick x") {
+		t.Fatalf("expect the nickname cleaned, %+v %v", uInfo, err)
+	}
+
+	// disabled user
+	if err := DisableUser(username, true); err != nil {
+		t.Fatal(err)
+	}
+	req, w = m.login(o, username, nil)
+	if _, err := o.Callback(w, req, sa); !ErrReject.Equal(err) {
+		t.Fatalf("expect ErrReject, but: %v", err)
+	}
+}
+
+func TestOidcVerifyIdToken(t *testing.T) {
+	m := newMockIssuer(t)
+	defer m.server.Close()
+	o, err := NewOidcAuth(OidcConfig{
+		Issuer:      m.server.URL,
+		ClientID:    "mdoc",
+		RedirectURL: "http://mdoc.test/login/oidc/callback",
+	}, m.server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	claims := func(k, v string) map[string]interface{} {
+		c := map[string]interface{}{"iss": m.server.URL, "aud": []string{"other", "mdoc"}, "exp": now.Add(time.Minute).Unix()}
+		if len(k) > 0 {
+			c[k] = v
+		}
+		return c
+	}
+	if _, err := o.verifyIdToken(signJwt(t, m.ecKey, "ec1", claims("", "")), now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.verifyIdToken(signJwt(t, m.rsaKey, "rsa1", claims("", "")), now); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []string{
+		signJwt(t, m.rsaKey, "rsa1", claims("iss", "http://evil.test")),
+		signJwt(t, m.rsaKey, "rsa1", claims("aud", "other")),
+		signJwt(t, m.rsaKey, "ec1", claims("", "")),      // key not match
+		signJwt(t, m.rsaKey, "unknow", claims("", "")),   // key not found
+		signJwt(t, m.rsaKey, "rsa1", claims("", ""))[1:], // tampered
+	}
+	for i, token := range cases {
+		if _, err := o.verifyIdToken(token, now); err == nil {
+			t.Fatalf("case %d expect error", i)
+		}
+	}
+	if _, err := o.verifyIdToken(signJwt(t, m.rsaKey, "rsa1", claims("", "")), now.Add(time.Hour)); !ErrOidc.Equal(err) {
+		t.Fatalf("expect expired, but: %v", err)
+	}
+
+	// alg none is rejected.
+	header := b64([]byte(`{"alg":"none","kid":"rsa1"}`))
+	payload, _ := json.Marshal(claims("", ""))
+	if _, err := o.verifyIdToken(header+"."+b64(payload)+".", now); err == nil {
+		t.Fatal("expect error of alg none")
+	}
+}
+
+func TestParseGroupRoles(t *testing.T) {
+	roles, err := ParseGroupRoles("idp-admins=admin, writers=editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles["idp-admins"] != ROLE_ADMIN || roles["writers"] != ROLE_EDITOR {
+		t.Fatalf("unexpect roles: %+v", roles)
+	}
+	if _, err := ParseGroupRoles("writers=root"); err == nil {
+		t.Fatal("expect error of unknow role")
+	}
+	if _, err := ParseGroupRoles("writers"); err == nil {
+		t.Fatal("expect error of missing role")
+	}
+}
@@ -0,0 +1,271 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"sync"
+
+	"github.com/gwaylib/database"
+	"github.com/gwaylib/errors"
+)
+
+var (
+	ErrPasswdTooShort = errors.New("Password too short")
+	ErrPasswdReused   = errors.New("Password used recently")
+)
+
+type PasswdPolicy struct {
+	MinLen  int // the minimum length of the password
+	History int // the number of recent passwords that can not be reused, includes the current one
+}
+
+var (
+	passwdPolicy   = PasswdPolicy{MinLen: 6, History: 3}
+	passwdPolicyLk sync.Mutex
+
+	digestDisabled   bool
+	digestDisabledLk sync.Mutex
+)
+
+// DisableDigest stops keeping the digest hash(HA1) of the passwords,
+// call ClearDigest to remove the HA1 of the users who have the kdf hash,
+// the HA1 of others will be removed when the user login with the plain password,
+// and only the kdf hash is kept.
+func DisableDigest(disabled bool) {
+	digestDisabledLk.Lock()
+	defer digestDisabledLk.Unlock()
+	digestDisabled = disabled
+}
+
+func DigestDisabled() bool {
+	digestDisabledLk.Lock()
+	defer digestDisabledLk.Unlock()
+	return digestDisabled
+}
+
+// ClearDigest removes the HA1 of the users and the password history which have the kdf hash,
+// and returns the users who have no kdf hash yet, their HA1 is kept until they login with the plain password.
+func ClearDigest() ([]string, error) {
+	db := GetDB()
+	if _, err := db.Exec(
+		"UPDATE user_info SET passwd='',passwd_sha256='',passwd_sha512_256='' WHERE passwd_kdf<>'' AND (passwd<>'' OR passwd_sha256<>'' OR passwd_sha512_256<>'')",
+	); err != nil {
+		return nil, errors.As(err)
+	}
+	if _, err := db.Exec("UPDATE user_passwd_history SET passwd='' WHERE passwd_kdf<>'' AND passwd<>''"); err != nil {
+		return nil, errors.As(err)
+	}
+	// the history of HA1 only can not be kept without the HA1.
+	if _, err := db.Exec("DELETE FROM user_passwd_history WHERE passwd_kdf=''"); err != nil {
+		return nil, errors.As(err)
+	}
+	users := []string{}
+	if err := database.QueryElems(db, &users, "SELECT id FROM user_info WHERE passwd_kdf='' ORDER BY id"); err != nil {
+		return nil, errors.As(err)
+	}
+	return users, nil
+}
+
+func SetPasswdPolicy(p PasswdPolicy) {
+	passwdPolicyLk.Lock()
+	defer passwdPolicyLk.Unlock()
+	passwdPolicy = p
+}
+
+func GetPasswdPolicy() PasswdPolicy {
+	passwdPolicyLk.Lock()
+	defer passwdPolicyLk.Unlock()
+	return passwdPolicy
+}
+
+// the stored hashes of a password.
+type storedPasswd struct {
+	Passwd      string `db:"passwd"`     // HA1 of digest
+	PasswdKdf   string `db:"passwd_kdf"` // see HashKdf
+	PasswdRealm string `db:"-"`          // the realm of HA1, empty for the realm of matching
+
+	// HA1 of the RFC 7616 algorithms, they are not kept in the history.
+	PasswdSha256     string `db:"-"`
+	PasswdSha512_256 string `db:"-"`
+}
+
+// return true if one of the HA1 is stored.
+func (s *storedPasswd) hasDigest() bool {
+	return len(s.Passwd) > 0 || len(s.PasswdSha256) > 0 || len(s.PasswdSha512_256) > 0
+}
+
+// make the HA1 of all the digest algorithms, they are removed when the digest is disabled.
+func (s *storedPasswd) setDigest(username, realm, passwd string) {
+	if DigestDisabled() {
+		s.Passwd, s.PasswdSha256, s.PasswdSha512_256 = "", "", ""
+		return
+	}
+	s.Passwd = HashPasswd(username, realm, passwd)
+	s.PasswdSha256 = HashPasswdAlg(DIGEST_SHA256, username, realm, passwd)
+	s.PasswdSha512_256 = HashPasswdAlg(DIGEST_SHA512_256, username, realm, passwd)
+	s.PasswdRealm = realm
+}
+
+// return true if the plain password matches the stored hashes, the kdf hash is preferred.
+func (s *storedPasswd) match(username, realm, passwd string) (bool, error) {
+	if len(s.PasswdKdf) > 0 {
+		ok, err := VerifyKdf(s.PasswdKdf, passwd)
+		if err != nil {
+			return false, errors.As(err, username)
+		}
+		return ok, nil
+	}
+	if len(s.Passwd) > 0 {
+		if len(s.PasswdRealm) > 0 {
+			realm = s.PasswdRealm
+		}
+		return subtle.ConstantTimeCompare([]byte(s.Passwd), []byte(HashPasswd(username, realm, passwd))) == 1, nil
+	}
+	return false, nil
+}
+
+// SetPasswd changes the password of user with the plain password,
+// the HA1 of all the digest algorithms are made.
+func SetPasswd(username, realm, passwd string) error {
+	kdf, err := HashKdf(passwd)
+	if err != nil {
+		return errors.As(err)
+	}
+	stored := &storedPasswd{PasswdKdf: kdf}
+	stored.setDigest(username, realm, passwd)
+	if err := resetPasswd(username, realm, stored); err != nil {
+		return errors.As(err)
+	}
+	return nil
+}
+
+// return the recent password hashes of user, includes the current one.
+func recentPasswds(username string, limit int) ([]storedPasswd, error) {
+	result := []storedPasswd{}
+	if limit <= 0 {
+		return result, nil
+	}
+	uInfo, err := GetUser(username)
+	if err != nil {
+		return nil, errors.As(err)
+	}
+	result = append(result, storedPasswd{Passwd: uInfo.Passwd, PasswdKdf: uInfo.PasswdKdf, PasswdRealm: uInfo.PasswdRealm})
+	if limit == 1 {
+		return result, nil
+	}
+
+	history := []storedPasswd{}
+	db := GetDB()
+	if err := database.QueryStructs(db, &history,
+		"SELECT passwd,passwd_kdf FROM user_passwd_history WHERE user_id=? ORDER BY rowid DESC LIMIT ?",
+		username, limit-1,
+	); err != nil {
+		return nil, errors.As(err, username)
+	}
+	return append(result, history...), nil
+}
+
+// record the password to the history, and drop the history out of the policy.
+func addPasswdHistory(exec database.Execer, username string, old *storedPasswd) error {
+	oldPasswd := old.Passwd
+	if DigestDisabled() && len(old.PasswdKdf) > 0 {
+		oldPasswd = ""
+	}
+	if _, err := exec.Exec("INSERT INTO user_passwd_history(user_id,passwd,passwd_kdf)VALUES(?,?,?)", username, oldPasswd, old.PasswdKdf); err != nil {
+		return errors.As(err, username)
+	}
+	keep := GetPasswdPolicy().History
+	if keep < 1 {
+		keep = 1
+	}
+	if _, err := exec.Exec(
+		"DELETE FROM user_passwd_history WHERE user_id=? AND rowid NOT IN (SELECT rowid FROM user_passwd_history WHERE user_id=? ORDER BY rowid DESC LIMIT ?)",
+		username, username, keep,
+	); err != nil {
+		return errors.As(err, username)
+	}
+	return nil
+}
+
+// CheckPasswdPolicy checks the plain password of the user with the policy,
+// ErrPasswdTooShort or ErrPasswdReused will be returned if failed.
+func CheckPasswdPolicy(username, realm, passwd string) error {
+	policy := GetPasswdPolicy()
+	if len(passwd) < policy.MinLen {
+		return ErrPasswdTooShort.As(username, policy.MinLen)
+	}
+
+	recent, err := recentPasswds(username, policy.History)
+	if err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return nil
+		}
+		return errors.As(err)
+	}
+	for _, old := range recent {
+		ok, err := old.match(username, realm, passwd)
+		if err != nil {
+			return errors.As(err)
+		}
+		if ok {
+			return ErrPasswdReused.As(username, policy.History)
+		}
+	}
+	return nil
+}
+
+// CheckPasswd returns true if the plain password is the current password of user,
+// false will be returned if the user not found or disabled.
+//
+// When success, the kdf hash will be made if it not exist,
+// the HA1 will be removed if the digest is disabled, or be made again if it is in other realm,
+// and the HA1 of the algorithms added later will be made for the users who have the HA1 of MD5.
+func CheckPasswd(username, realm, passwd string) (bool, error) {
+	uInfo, err := GetUser(username)
+	if err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return false, nil
+		}
+		return false, errors.As(err)
+	}
+	if uInfo.Disabled {
+		return false, nil
+	}
+	stored := &storedPasswd{
+		Passwd:           uInfo.Passwd,
+		PasswdKdf:        uInfo.PasswdKdf,
+		PasswdRealm:      uInfo.PasswdRealm,
+		PasswdSha256:     uInfo.PasswdSha256,
+		PasswdSha512_256: uInfo.PasswdSha512_256,
+	}
+	ok, err := stored.match(username, realm, passwd)
+	if err != nil || !ok {
+		return false, errors.As(err)
+	}
+
+	// upgrade the stored hashes
+	digestDisabled := DigestDisabled()
+	staleRealm := stored.hasDigest() && stored.PasswdRealm != realm
+	missingAlg := len(stored.Passwd) > 0 && (len(stored.PasswdSha256) == 0 || len(stored.PasswdSha512_256) == 0)
+	if len(stored.PasswdKdf) > 0 && !staleRealm && !missingAlg && (!digestDisabled || !stored.hasDigest()) {
+		return true, nil
+	}
+	if len(stored.PasswdKdf) == 0 {
+		kdf, err := HashKdf(passwd)
+		if err != nil {
+			return false, errors.As(err)
+		}
+		stored.PasswdKdf = kdf
+	}
+	if digestDisabled || staleRealm || missingAlg {
+		stored.setDigest(username, realm, passwd)
+	}
+	db := GetDB()
+	if _, err := db.Exec(
+		"UPDATE user_info SET passwd=?,passwd_realm=?,passwd_kdf=?,passwd_sha256=?,passwd_sha512_256=? WHERE id=?",
+		stored.Passwd, realm, stored.PasswdKdf, stored.PasswdSha256, stored.PasswdSha512_256, username,
+	); err != nil {
+		return false, errors.As(err, username)
+	}
+	DelAuthCache(username)
+	return true, nil
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gwaylib/database"
+)
+
+// return the digest hash(HA1) and the kdf hash of the plain password, the HA1 is empty when the digest is disabled.
+func makePasswd(username, realm, passwd string) (string, string, error) {
+	kdf, err := HashKdf(passwd)
+	if err != nil {
+		return "", "", err
+	}
+	if DigestDisabled() {
+		return "", kdf, nil
+	}
+	return HashPasswd(username, realm, passwd), kdf, nil
+}
+
+func TestClearDigest(t *testing.T) {
+	kdfUser := fmt.Sprintf("clear_kdf_%d", time.Now().UnixNano())
+	ha1User := fmt.Sprintf("clear_md5_%d", time.Now().UnixNano())
+	ha1, kdf, err := makePasswd(kdfUser, REALM, "passwd1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddUser(&UserInfo{ID: kdfUser, Passwd: ha1, PasswdKdf: kdf}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetPasswd(kdfUser, REALM, "passwd2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddUser(&UserInfo{ID: ha1User, Passwd: HashPasswd(ha1User, REALM, "passwd1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	DisableDigest(true)
+	defer DisableDigest(false)
+	users, err := ClearDigest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reported := map[string]bool{}
+	for _, u := range users {
+		reported[u] = true
+	}
+	if !reported[ha1User] || reported[kdfUser] {
+		t.Fatalf("expect %s reported only, but: %v", ha1User, users)
+	}
+	uInfo, err := GetUser(kdfUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uInfo.Passwd) > 0 || len(uInfo.PasswdSha256) > 0 || len(uInfo.PasswdSha512_256) > 0 || len(uInfo.PasswdKdf) == 0 {
+		t.Fatalf("expect the kdf hash only: %+v", uInfo)
+	}
+	count := -1
+	if err := database.QueryElem(GetDB(), &count, "SELECT count(*) FROM user_passwd_history WHERE user_id=? AND passwd<>''", kdfUser); err != nil || count != 0 {
+		t.Fatalf("expect no HA1 in history, but: %d %v", count, err)
+	}
+	// the history of kdf is still checked.
+	if err := CheckPasswdPolicy(kdfUser, REALM, "passwd1"); !ErrPasswdReused.Equal(err) {
+		t.Fatalf("expect ErrPasswdReused, but: %v", err)
+	}
+	// the user without kdf hash can still login, and the HA1 is removed then.
+	if uInfo, err := GetUser(ha1User); err != nil || len(uInfo.Passwd) == 0 {
+		t.Fatalf("expect the HA1 kept: %+v %v", uInfo, err)
+	}
+	if ok, err := CheckPasswd(ha1User, REALM, "passwd1"); err != nil || !ok {
+		t.Fatalf("expect passed, %v", err)
+	}
+	if uInfo, err := GetUser(ha1User); err != nil || len(uInfo.Passwd) > 0 || len(uInfo.PasswdKdf) == 0 {
+		t.Fatalf("expect the HA1 removed: %+v %v", uInfo, err)
+	}
+}
+
+func TestPasswdPolicy(t *testing.T) {
+	SetPasswdPolicy(PasswdPolicy{MinLen: 6, History: 2})
+	defer SetPasswdPolicy(PasswdPolicy{MinLen: 6, History: 3})
+
+	username := fmt.Sprintf("passwd_%d", time.Now().UnixNano())
+	if err := AddUser(&UserInfo{ID: username, Passwd: HashPasswd(username, REALM, "passwd1")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckPasswdPolicy(username, REALM, "short"); !ErrPasswdTooShort.Equal(err) {
+		t.Fatalf("expect ErrPasswdTooShort, but: %v", err)
+	}
+	if err := CheckPasswdPolicy(username, REALM, "passwd1"); !ErrPasswdReused.Equal(err) {
+		t.Fatalf("expect ErrPasswdReused, but: %v", err)
+	}
+
+	for _, passwd := range []string{"passwd2", "passwd3"} {
+		if err := CheckPasswdPolicy(username, REALM, passwd); err != nil {
+			t.Fatal(err)
+		}
+		if err := ResetPwd(username, HashPasswd(username, REALM, passwd)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, err := CheckPasswd(username, REALM, "passwd3"); err != nil || !ok {
+		t.Fatalf("expect password match, %v", err)
+	}
+	// the last 2 passwords can not be reused
+	if err := CheckPasswdPolicy(username, REALM, "passwd2"); !ErrPasswdReused.Equal(err) {
+		t.Fatalf("expect ErrPasswdReused, but: %v", err)
+	}
+	if err := CheckPasswdPolicy(username, REALM, "passwd1"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/gwaylib/errors"
+	"github.com/gwaylib/log"
+)
+
+var (
+	ErrNotProvisioned = errors.New("User not provisioned")
+	// ErrLocalUser will be returned if the user of provider is a local user with the password,
+	// so the local account can not be taken over by the same name of the provider.
+	ErrLocalUser = errors.New("User has a local password")
+)
+
+// AuthProvider authenticates the users by the password, see LocalProvider and LdapProvider.
+type AuthProvider interface {
+	// Name of the provider for the logs.
+	Name() string
+
+	// Secret returns the HA1 of the digest authentication,
+	// empty will be returned if the user not found, disabled, or the provider can not offer the HA1.
+	Secret(username, realm string) string
+
+	// CheckPasswd returns true if the plain password of the user is valid,
+	// false will be returned if the user not found or disabled.
+	CheckPasswd(username, realm, passwd string) (bool, error)
+}
+
+// AlgSecretProvider is implemented by the providers who can offer the HA1 of the digest algorithms other than MD5.
+type AlgSecretProvider interface {
+	// AlgSecret is same as AuthProvider.Secret, but returns the HA1 of the algorithm.
+	AlgSecret(username, realm, algorithm string) string
+}
+
+var (
+	authProviders   = []AuthProvider{LocalProvider{}}
+	authProvidersLk sync.Mutex
+)
+
+// SetAuthProviders sets the providers of the login, they are tried in order.
+func SetAuthProviders(providers ...AuthProvider) {
+	authProvidersLk.Lock()
+	defer authProvidersLk.Unlock()
+	authProviders = providers
+}
+
+func GetAuthProviders() []AuthProvider {
+	authProvidersLk.Lock()
+	defer authProvidersLk.Unlock()
+	return authProviders
+}
+
+// AuthSecret is the httpauth.SecretProvider of the digest authentication,
+// it returns the first HA1 offered by the providers.
+func AuthSecret(username, realm string) string {
+	for _, p := range GetAuthProviders() {
+		if secret := p.Secret(username, realm); len(secret) > 0 {
+			return secret
+		}
+	}
+	return ""
+}
+
+// AuthAlgSecret is the DigestSecretProvider of the digest authentication,
+// it returns the first HA1 of the algorithm offered by the providers.
+func AuthAlgSecret(username, realm, algorithm string) string {
+	if algorithm == DIGEST_MD5 {
+		return AuthSecret(username, realm)
+	}
+	for _, p := range GetAuthProviders() {
+		ap, ok := p.(AlgSecretProvider)
+		if !ok {
+			continue
+		}
+		if secret := ap.AlgSecret(username, realm, algorithm); len(secret) > 0 {
+			return secret
+		}
+	}
+	return ""
+}
+
+// AuthPasswd checks the plain password by the providers in order,
+// true will be returned when one of the providers passed.
+func AuthPasswd(username, realm, passwd string) (bool, error) {
+	for _, p := range GetAuthProviders() {
+		ok, err := p.CheckPasswd(username, realm, passwd)
+		if err != nil {
+			return false, errors.As(err, p.Name())
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// LocalProvider authenticates the users by the passwords stored in the db.
+type LocalProvider struct{}
+
+func (LocalProvider) Name() string {
+	return "local"
+}
+
+func (p LocalProvider) Secret(username, realm string) string {
+	return p.AlgSecret(username, realm, DIGEST_MD5)
+}
+
+func (LocalProvider) AlgSecret(username, realm, algorithm string) string {
+	cacheKey := digestCacheKey(username, algorithm)
+	if cached := authCache.Get(cacheKey); cached != nil {
+		return cached.(string)
+	}
+
+	uInfo, err := GetUser(username)
+	if err != nil {
+		if !errors.ErrNoData.Equal(err) {
+			log.Warn(errors.As(err, username, realm))
+		}
+		return ""
+	}
+	if uInfo.Disabled {
+		return ""
+	}
+	secret := uInfo.DigestPasswd(algorithm)
+	if len(secret) > 0 && uInfo.PasswdRealm != realm {
+		log.Infof("the HA1 of '%s' is in the realm '%s', need reset the password", username, uInfo.PasswdRealm)
+		return ""
+	}
+	authCache.Put(cacheKey, secret, 3600*24*_AUTH_EXPIRES_DAYS)
+	return secret
+}
+
+func (LocalProvider) CheckPasswd(username, realm, passwd string) (bool, error) {
+	return CheckPasswd(username, realm, passwd)
+}
+
+// syncExternalUser syncs the user authenticated by an external provider into the db,
+// source is the name of the provider, and is kept in the memo of the added users and groups.
+//
+// The members of the groups in groupRoles are synced with the groups of the provider,
+// the groups are added with the role when they are not found, and the nickname is cleaned by externalNickName.
+//
+// ErrNotProvisioned will be returned if the user not found and provision is false,
+// ErrLocalUser will be returned if the user has a local password,
+// ErrReject will be returned if the user is disabled.
+func syncExternalUser(source, username, nickName string, groups []string, groupRoles map[string]int, provision bool) error {
+	if err := CheckUserName(username); err != nil {
+		return errors.As(err, source)
+	}
+	nickName = externalNickName(nickName)
+	uInfo, err := GetUser(username)
+	switch {
+	case err == nil:
+		if uInfo.Disabled {
+			return ErrReject.As(username, "disabled")
+		}
+		if len(uInfo.Passwd) > 0 || len(uInfo.PasswdKdf) > 0 {
+			return ErrLocalUser.As(username, source)
+		}
+		if len(nickName) > 0 && nickName != uInfo.NickName {
+			if err := UpdateUserInfo(username, nickName, uInfo.Memo); err != nil {
+				return errors.As(err)
+			}
+		}
+	case errors.ErrNoData.Equal(err):
+		if !provision {
+			return ErrNotProvisioned.As(username)
+		}
+		// no password, the user can only login by the provider or the api token.
+		if err := AddUser(&UserInfo{ID: username, NickName: nickName, Memo: source}); err != nil {
+			return errors.As(err)
+		}
+	default:
+		return errors.As(err)
+	}
+
+	joined := map[string]bool{}
+	for _, name := range groups {
+		joined[name] = true
+	}
+	for name, role := range groupRoles {
+		if !joined[name] {
+			if err := DelGroupMember(name, username); err != nil {
+				return errors.As(err)
+			}
+			continue
+		}
+		gInfo, err := GetGroup(name)
+		switch {
+		case err == nil:
+			if gInfo.Role != role {
+				if err := UpdateGroupRole(name, role); err != nil {
+					return errors.As(err)
+				}
+			}
+		case errors.ErrNoData.Equal(err):
+			if err := AddGroup(&GroupInfo{ID: name, Role: role, Memo: source}); err != nil {
+				return errors.As(err)
+			}
+		default:
+			return errors.As(err)
+		}
+		if err := AddGroupMember(name, username); err != nil {
+			return errors.As(err)
+		}
+	}
+	return nil
+}
+
+// return the nickname of provider which passes CheckNickName,
+// the invalid and control characters are removed, and it is truncated to NICK_NAME_MAX_LEN.
+func externalNickName(nickName string) string {
+	runes := []rune{}
+	for _, r := range strings.ToValidUTF8(nickName, "") {
+		if unicode.IsControl(r) {
+			continue
+		}
+		if len(runes) == NICK_NAME_MAX_LEN {
+			break
+		}
+		runes = append(runes, r)
+	}
+	return strings.TrimSpace(string(runes))
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/gwaylib/database"
+	"github.com/gwaylib/errors"
+	"github.com/gwaylib/log"
+)
+
+const (
+	_SYS_CFG_REALM = "realm"
+)
+
+var (
+	ErrInvalidRealm = errors.New("Invalid realm")
+)
+
+var (
+	realm   = REALM
+	realmLk sync.Mutex
+)
+
+// GetRealm returns the realm of the authentication, it is REALM before InitRealm called.
+func GetRealm() string {
+	realmLk.Lock()
+	defer realmLk.Unlock()
+	return realm
+}
+
+func checkRealm(r string) error {
+	if len(r) == 0 || strings.ContainsAny(r, "\":\r\n") {
+		return ErrInvalidRealm.As(r)
+	}
+	return nil
+}
+
+// InitRealm loads the realm kept in the db, and changes it when newRealm is not empty and different,
+// the REALM is used when the db has no realm.
+//
+// The HA1 of digest is bound to the realm, so the users whose HA1 was made in other realms can not pass the digest,
+// they need to reset the password, see CountStaleUsers.
+func InitRealm(newRealm string) error {
+	realmLk.Lock()
+	defer realmLk.Unlock()
+
+	old, err := GetSysCfg(_SYS_CFG_REALM)
+	if err != nil {
+		if !errors.ErrNoData.Equal(err) {
+			return errors.As(err)
+		}
+		old = REALM
+	}
+	if len(newRealm) == 0 || newRealm == old {
+		realm = old
+		return nil
+	}
+	if err := checkRealm(newRealm); err != nil {
+		return errors.As(err)
+	}
+	if err := PutSysCfg(_SYS_CFG_REALM, newRealm); err != nil {
+		return errors.As(err)
+	}
+	realm = newRealm
+	log.Infof("realm changed from '%s' to '%s'", old, newRealm)
+	if err := AddAudit(&Audit{
+		Action: AUDIT_REALM,
+		Result: AUDIT_RESULT_OK,
+		Target: newRealm,
+		Memo:   old,
+	}); err != nil {
+		log.Warn(errors.As(err))
+	}
+	return nil
+}
+
+// CountStaleUsers returns the number of the users whose HA1 was made in other realms.
+func CountStaleUsers() (int, error) {
+	count := 0
+	db := GetDB()
+	if err := database.QueryElem(db, &count,
+		"SELECT count(*) FROM user_info WHERE passwd<>'' AND passwd_realm<>?", GetRealm(),
+	); err != nil {
+		return 0, errors.As(err)
+	}
+	return count, nil
+}
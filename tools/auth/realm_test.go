@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestInitRealm(t *testing.T) {
+	defer func() {
+		if err := InitRealm(REALM); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if err := InitRealm(""); err != nil {
+		t.Fatal(err)
+	}
+	if GetRealm() != REALM {
+		t.Fatalf("expect the default realm, but: %s", GetRealm())
+	}
+
+	suffix := time.Now().UnixNano()
+	digestUser := fmt.Sprintf("realm_digest_%d", suffix)
+	kdfUser := fmt.Sprintf("realm_kdf_%d", suffix)
+	if err := AddUser(&UserInfo{ID: digestUser, Passwd: HashPasswd(digestUser, REALM, "digestpass")}); err != nil {
+		t.Fatal(err)
+	}
+	ha1, kdf, err := makePasswd(kdfUser, REALM, "kdfpass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddUser(&UserInfo{ID: kdfUser, Passwd: ha1, PasswdKdf: kdf}); err != nil {
+		t.Fatal(err)
+	}
+	if uInfo, err := GetUser(digestUser); err != nil || uInfo.PasswdRealm != REALM {
+		t.Fatalf("expect the realm of HA1, %+v %v", uInfo, err)
+	}
+
+	if err := InitRealm("bad\"realm"); !ErrInvalidRealm.Equal(err) {
+		t.Fatalf("expect ErrInvalidRealm, but: %v", err)
+	}
+	newRealm := fmt.Sprintf("docs_%d", suffix)
+	if err := InitRealm(newRealm); err != nil {
+		t.Fatal(err)
+	}
+	if GetRealm() != newRealm {
+		t.Fatalf("expect the new realm, but: %s", GetRealm())
+	}
+	// the realm is kept in the db.
+	if err := InitRealm(""); err != nil {
+		t.Fatal(err)
+	}
+	if GetRealm() != newRealm {
+		t.Fatalf("expect the kept realm, but: %s", GetRealm())
+	}
+	stale, err := CountStaleUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale < 2 {
+		t.Fatalf("expect the stale users, but: %d", stale)
+	}
+	users, err := ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range users {
+		if (u.ID == digestUser || u.ID == kdfUser) && !u.PasswdStale {
+			t.Fatalf("expect stale: %+v", u)
+		}
+	}
+
+	// the HA1 of the old realm is not offered to the digest.
+	DelAuthCache(digestUser)
+	if len((LocalProvider{}).Secret(digestUser, newRealm)) > 0 {
+		t.Fatal("expect no secret of the old realm")
+	}
+
+	// the HA1 is made again by the login with the plain password.
+	for user, passwd := range map[string]string{digestUser: "digestpass", kdfUser: "kdfpass"} {
+		if ok, err := CheckPasswd(user, newRealm, passwd); err != nil || !ok {
+			t.Fatalf("expect %s passed, %v", user, err)
+		}
+		uInfo, err := GetUser(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uInfo.PasswdRealm != newRealm || uInfo.Passwd != HashPasswd(user, newRealm, passwd) || len(uInfo.PasswdKdf) == 0 {
+			t.Fatalf("expect HA1 of the new realm: %+v", uInfo)
+		}
+		if (LocalProvider{}).Secret(user, newRealm) != uInfo.Passwd {
+			t.Fatal("expect the secret of new realm")
+		}
+	}
+
+	// reset by the admin
+	if err := InitRealm(REALM); err != nil {
+		t.Fatal(err)
+	}
+	if err := ResetPwd(digestUser, HashPasswd(digestUser, REALM, "resetpass")); err != nil {
+		t.Fatal(err)
+	}
+	if uInfo, err := GetUser(digestUser); err != nil || uInfo.PasswdRealm != REALM {
+		t.Fatalf("expect the reset realm, %+v %v", uInfo, err)
+	}
+}
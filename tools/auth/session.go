@@ -0,0 +1,321 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	httpauth "github.com/abbot/go-http-auth"
+	"github.com/gwaylib/database"
+	"github.com/gwaylib/errors"
+	"github.com/gwaylib/log"
+)
+
+const (
+	SESSION_COOKIE_NAME = "mdoc_session"
+
+	_SYS_CFG_SESSION_SECRET = "session_secret"
+	_SESSION_PURGE_INTERVAL = time.Hour
+)
+
+type Session struct {
+	ID        string `db:"id"`
+	UserID    string `db:"user_id"`
+	Ip        string `db:"ip"`
+	ExpiredAt int64  `db:"expired_at"` // unix seconds
+}
+
+func addSession(s *Session) error {
+	db := GetDB()
+	if _, err := database.InsertStruct(db, s, "user_session"); err != nil {
+		return errors.As(err)
+	}
+	return nil
+}
+
+func getSession(id string) (*Session, error) {
+	s := &Session{}
+	db := GetDB()
+	if err := database.QueryStruct(db, s, "SELECT id,user_id,ip,expired_at FROM user_session WHERE id=?", id); err != nil {
+		return nil, errors.As(err)
+	}
+	return s, nil
+}
+
+func delSession(id string) error {
+	db := GetDB()
+	if _, err := db.Exec("DELETE FROM user_session WHERE id=?", id); err != nil {
+		return errors.As(err)
+	}
+	return nil
+}
+
+// DelUserSessions removes all the sessions of the user, it should be called when the user need login again.
+func DelUserSessions(username string) error {
+	db := GetDB()
+	if _, err := db.Exec("DELETE FROM user_session WHERE user_id=?", username); err != nil {
+		return errors.As(err, username)
+	}
+	return nil
+}
+
+// SessionItem is the session for listing, the id is not included since it is a part of the cookie.
+type SessionItem struct {
+	UserID    string `db:"user_id"`
+	Ip        string `db:"ip"`
+	ExpiredAt int64  `db:"expired_at"` // unix seconds
+}
+
+// ListSessions returns the unexpired sessions, the latest expired is the first.
+func ListSessions(now time.Time) ([]SessionItem, error) {
+	result := []SessionItem{}
+	db := GetDB()
+	if err := database.QueryStructs(db, &result,
+		"SELECT user_id,ip,expired_at FROM user_session WHERE expired_at>=? ORDER BY expired_at DESC", now.Unix(),
+	); err != nil {
+		return nil, errors.As(err)
+	}
+	return result, nil
+}
+
+func purgeSession(now time.Time) error {
+	db := GetDB()
+	if _, err := db.Exec("DELETE FROM user_session WHERE expired_at<?", now.Unix()); err != nil {
+		return errors.As(err)
+	}
+	return nil
+}
+
+// SessionAuth implements the cookie login with a form,
+// the cookie value is "session id" + "." + HMAC-SHA256(secret, session id),
+// and the session is stored in the sqlite db, so the InitDB should be called before using.
+type SessionAuth struct {
+	Realm   string
+	Expires time.Duration
+
+	secret    []byte
+	lastPurge time.Time
+	mutex     sync.Mutex
+}
+
+// The password is checked by CheckPasswd, so the password hashes are upgraded when the user login.
+func NewSessionAuth(realm string, expires time.Duration) (*SessionAuth, error) {
+	dbGlobalLk.Lock()
+	defer dbGlobalLk.Unlock()
+
+	key, err := GetSysCfg(_SYS_CFG_SESSION_SECRET)
+	if err != nil {
+		if !errors.ErrNoData.Equal(err) {
+			return nil, errors.As(err)
+		}
+		key = httpauth.RandomKey() + httpauth.RandomKey()
+		if err := PutSysCfg(_SYS_CFG_SESSION_SECRET, key); err != nil {
+			return nil, errors.As(err)
+		}
+	}
+	return &SessionAuth{
+		Realm:   realm,
+		Expires: expires,
+		secret:  []byte(key),
+	}, nil
+}
+
+func (sa *SessionAuth) sign(id string) string {
+	mac := hmac.New(sha256.New, sa.secret)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parse the session id from the signed cookie value.
+func (sa *SessionAuth) parseCookie(val string) (string, bool) {
+	idx := strings.LastIndex(val, ".")
+	if idx < 1 {
+		return "", false
+	}
+	id, sign := val[:idx], val[idx+1:]
+	if subtle.ConstantTimeCompare([]byte(sa.sign(id)), []byte(sign)) != 1 {
+		return "", false
+	}
+	return id, true
+}
+
+func (sa *SessionAuth) purge(now time.Time) {
+	sa.mutex.Lock()
+	defer sa.mutex.Unlock()
+	if now.Sub(sa.lastPurge) < _SESSION_PURGE_INTERVAL {
+		return
+	}
+	sa.lastPurge = now
+	if err := purgeSession(now); err != nil {
+		log.Warn(errors.As(err))
+	}
+}
+
+// Login checks the captcha, the password and the two-factor code of the user, and set the session cookie when success.
+// The captcha is only checked after the failures of LimitPolicy.Captcha, see NeedCaptcha.
+// ErrNeedPwd will be returned if the password not match,
+// ErrNeedOtp will be returned if the two-factor code not match,
+// ErrNeedCaptcha will be returned if the captcha is required but not match,
+// ErrReject will be returned if there are too many login failures, even if the captcha is solved.
+func (sa *SessionAuth) Login(w http.ResponseWriter, req *http.Request, username, passwd, code, captchaID, captchaDigits string) error {
+	if len(username) == 0 {
+		return ErrNeedLogin.As("need username")
+	}
+
+	// detect whether it is an attack
+	limitKey := authLimitKey(req, username)
+	errTimes, err := getAuthLimit(limitKey)
+	if err != nil {
+		return errors.As(err)
+	}
+	// the captcha slows down the guessing, it does not lift the lock.
+	if errTimes > GetLimitPolicy().Times {
+		auditReq(req, username, AUDIT_LOGIN, AUDIT_RESULT_REJECTED, "")
+		return ErrReject.As(limitKey.ID, errTimes)
+	}
+	if needCaptcha(errTimes) {
+		// the failures of captcha are not counted since the password is not checked.
+		if !verifyCaptcha(captchaID, captchaDigits) {
+			auditReq(req, username, AUDIT_LOGIN, AUDIT_RESULT_REJECTED, "")
+			return ErrNeedCaptcha.As(limitKey.ID, errTimes)
+		}
+	}
+
+	ok, err := AuthPasswd(username, sa.Realm, passwd)
+	if err != nil {
+		return errors.As(err)
+	}
+	if !ok {
+		auditReq(req, username, AUDIT_LOGIN, AUDIT_RESULT_FAILED, "")
+		if err := updateAuthLimit(limitKey, errTimes+1); err != nil {
+			return errors.As(err)
+		}
+		return ErrNeedPwd.As(username)
+	}
+	// two-factor
+	if err := checkOtp(username, code, limitKey, errTimes); err != nil {
+		auditReq(req, username, AUDIT_LOGIN, AUDIT_RESULT_FAILED, "")
+		return errors.As(err)
+	}
+	// clean the errTimes when success
+	if err := updateAuthLimit(limitKey, 0); err != nil {
+		return errors.As(err)
+	}
+	return sa.startSession(w, req, username, "")
+}
+
+// NeedCaptcha returns true if the next login of the user from the request needs the captcha.
+func (sa *SessionAuth) NeedCaptcha(req *http.Request, username string) (bool, error) {
+	if len(username) == 0 {
+		return false, nil
+	}
+	errTimes, err := getAuthLimit(authLimitKey(req, username))
+	if err != nil {
+		return false, errors.As(err)
+	}
+	return needCaptcha(errTimes), nil
+}
+
+// startSession makes a new session of the user who passed the authentication, and set the session cookie.
+func (sa *SessionAuth) startSession(w http.ResponseWriter, req *http.Request, username, memo string) error {
+	now := time.Now()
+	sa.purge(now)
+	s := &Session{
+		ID:        httpauth.RandomKey() + httpauth.RandomKey(),
+		UserID:    username,
+		Ip:        ClientIp(req),
+		ExpiredAt: now.Add(sa.Expires).Unix(),
+	}
+	if err := addSession(s); err != nil {
+		return errors.As(err)
+	}
+	if err := AddAudit(&Audit{
+		UserID: username,
+		Ip:     s.Ip,
+		Action: AUDIT_LOGIN,
+		Result: AUDIT_RESULT_OK,
+		Memo:   memo,
+	}); err != nil {
+		log.Warn(errors.As(err))
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE_NAME,
+		Value:    s.ID + "." + sa.sign(s.ID),
+		Path:     "/",
+		Expires:  time.Unix(s.ExpiredAt, 0),
+		Secure:   req.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// CheckAuth returns the username of the session cookie,
+// ErrNeedLogin will be returned if the session not found or expired.
+func (sa *SessionAuth) CheckAuth(req *http.Request) (string, error) {
+	cookie, err := req.Cookie(SESSION_COOKIE_NAME)
+	if err != nil {
+		return "", ErrNeedLogin.As("need cookie")
+	}
+	id, ok := sa.parseCookie(cookie.Value)
+	if !ok {
+		return "", ErrNeedLogin.As("invalid cookie")
+	}
+	s, err := getSession(id)
+	if err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return "", ErrNeedLogin.As("session not found")
+		}
+		return "", errors.As(err)
+	}
+	if s.ExpiredAt < time.Now().Unix() {
+		if err := delSession(id); err != nil {
+			log.Warn(errors.As(err))
+		}
+		return "", ErrNeedLogin.As("session expired")
+	}
+	return s.UserID, nil
+}
+
+// ResetSessions removes all the sessions of the user, e.g. after the password changed,
+// and a new session is started if the request was logged in by the session of the user, so the caller keeps the login.
+func (sa *SessionAuth) ResetSessions(w http.ResponseWriter, req *http.Request, username string) error {
+	current, err := sa.CheckAuth(req)
+	if err != nil && !ErrNeedLogin.Equal(err) {
+		return errors.As(err)
+	}
+	if err := DelUserSessions(username); err != nil {
+		return errors.As(err)
+	}
+	if current != username {
+		return nil
+	}
+	return sa.startSession(w, req, username, "sessions reset")
+}
+
+// Logout removes the session of request and clean the cookie.
+func (sa *SessionAuth) Logout(w http.ResponseWriter, req *http.Request) error {
+	if cookie, err := req.Cookie(SESSION_COOKIE_NAME); err == nil {
+		if id, ok := sa.parseCookie(cookie.Value); ok {
+			if s, err := getSession(id); err == nil {
+				auditReq(req, s.UserID, AUDIT_LOGOUT, AUDIT_RESULT_OK, "")
+			}
+			if err := delSession(id); err != nil {
+				return errors.As(err)
+			}
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE_NAME,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	return nil
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dchest/captcha"
+)
+
+func TestSessionAuth(t *testing.T) {
+	if err := AddUser(&UserInfo{ID: "session_test", Passwd: HashPasswd("session_test", REALM, "hello")}); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := NewSessionAuth(REALM, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	if err := sa.Login(w, httptest.NewRequest("POST", "/login", nil), "session_test", "bad", "", "", ""); !ErrNeedPwd.Equal(err) {
+		t.Fatalf("expect ErrNeedPwd, but: %v", err)
+	}
+	if err := sa.Login(w, httptest.NewRequest("POST", "/login", nil), "session_test", "hello", "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	cookie := w.Header().Get("Set-Cookie")
+	if !strings.HasPrefix(cookie, SESSION_COOKIE_NAME+"=") {
+		t.Fatalf("unexpect cookie: %s", cookie)
+	}
+
+	req := httptest.NewRequest("GET", "/markdown/README.md", nil)
+	req.Header.Set("Cookie", strings.Split(cookie, ";")[0])
+	username, err := sa.CheckAuth(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "session_test" {
+		t.Fatalf("expect session_test, but: %s", username)
+	}
+
+	// the secret is kept in db
+	sa, err = NewSessionAuth(REALM, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sa.CheckAuth(req); err != nil {
+		t.Fatal(err)
+	}
+
+	// tampered cookie
+	badReq := httptest.NewRequest("GET", "/markdown/README.md", nil)
+	badReq.Header.Set("Cookie", strings.Split(cookie, ";")[0]+"x")
+	if _, err := sa.CheckAuth(badReq); !ErrNeedLogin.Equal(err) {
+		t.Fatalf("expect ErrNeedLogin, but: %v", err)
+	}
+
+	if err := sa.Logout(httptest.NewRecorder(), req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sa.CheckAuth(req); !ErrNeedLogin.Equal(err) {
+		t.Fatalf("expect ErrNeedLogin, but: %v", err)
+	}
+}
+
+func TestSessionReset(t *testing.T) {
+	username := fmt.Sprintf("session_reset_%d", time.Now().UnixNano())
+	if err := AddUser(&UserInfo{ID: username, Passwd: HashPasswd(username, REALM, "hello")}); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := NewSessionAuth(REALM, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// login and return the request with the session cookie.
+	login := func() *http.Request {
+		t.Helper()
+		w := httptest.NewRecorder()
+		if err := sa.Login(w, httptest.NewRequest("POST", "/login", nil), username, "hello", "", "", ""); err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("GET", "/markdown/README.md", nil)
+		req.Header.Set("Cookie", strings.Split(w.Header().Get("Set-Cookie"), ";")[0])
+		return req
+	}
+	current, other := login(), login()
+
+	w := httptest.NewRecorder()
+	if err := sa.ResetSessions(w, current, username); err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range []*http.Request{current, other} {
+		if _, err := sa.CheckAuth(req); !ErrNeedLogin.Equal(err) {
+			t.Fatalf("expect ErrNeedLogin, but: %v", err)
+		}
+	}
+	// the caller keeps the login by the new session.
+	renewed := httptest.NewRequest("GET", "/markdown/README.md", nil)
+	renewed.Header.Set("Cookie", strings.Split(w.Header().Get("Set-Cookie"), ";")[0])
+	if name, err := sa.CheckAuth(renewed); err != nil || name != username {
+		t.Fatalf("expect %s, but: %s %v", username, name, err)
+	}
+
+	// no new session for the request without the session of user.
+	w = httptest.NewRecorder()
+	if err := sa.ResetSessions(w, httptest.NewRequest("POST", "/user/passwd", nil), username); err != nil {
+		t.Fatal(err)
+	}
+	if cookie := w.Header().Get("Set-Cookie"); len(cookie) > 0 {
+		t.Fatalf("expect no cookie, but: %s", cookie)
+	}
+	if _, err := sa.CheckAuth(renewed); !ErrNeedLogin.Equal(err) {
+		t.Fatalf("expect ErrNeedLogin, but: %v", err)
+	}
+}
+
+func TestSessionCaptcha(t *testing.T) {
+	old := GetLimitPolicy()
+	defer SetLimitPolicy(old)
+	SetLimitPolicy(LimitPolicy{Times: 2, Backoff: time.Hour, Captcha: 1})
+	store := captcha.NewMemoryStore(captcha.CollectNum, time.Minute)
+	captcha.SetCustomStore(store)
+	// the digits of the captcha in the store
+	digits := func(id string) string {
+		d := []byte{}
+		for _, b := range store.Get(id, false) {
+			d = append(d, '0'+b)
+		}
+		return string(d)
+	}
+
+	username := fmt.Sprintf("captcha_%d", time.Now().UnixNano())
+	if err := AddUser(&UserInfo{ID: username, Passwd: HashPasswd(username, REALM, "hello")}); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := NewSessionAuth(REALM, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := func(passwd, captchaID, captchaDigits string) error {
+		return sa.Login(httptest.NewRecorder(), httptest.NewRequest("POST", "/login", nil), username, passwd, "", captchaID, captchaDigits)
+	}
+	needCaptcha := func(expect bool) {
+		t.Helper()
+		need, err := sa.NeedCaptcha(httptest.NewRequest("POST", "/login", nil), username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if need != expect {
+			t.Fatalf("expect need captcha %v, but: %v", expect, need)
+		}
+	}
+
+	needCaptcha(false)
+	if err := login("bad", "", ""); !ErrNeedPwd.Equal(err) {
+		t.Fatalf("expect ErrNeedPwd, but: %v", err)
+	}
+	needCaptcha(true)
+	// the password is not checked without the captcha
+	if err := login("hello", "", ""); !ErrNeedCaptcha.Equal(err) {
+		t.Fatalf("expect ErrNeedCaptcha, but: %v", err)
+	}
+	id := NewCaptcha()
+	if err := login("hello", id, "x"); !ErrNeedCaptcha.Equal(err) {
+		t.Fatalf("expect ErrNeedCaptcha, but: %v", err)
+	}
+	id = NewCaptcha()
+	d := digits(id)
+	if err := login("hello", id, d); err != nil {
+		t.Fatal(err)
+	}
+	needCaptcha(false)
+	// the captcha can only be used once
+	if verifyCaptcha(id, d) {
+		t.Fatal("expect the captcha is used")
+	}
+
+	// the failures with the solved captcha are counted, and the login is locked after the limit times.
+	if err := login("bad", "", ""); !ErrNeedPwd.Equal(err) {
+		t.Fatalf("expect ErrNeedPwd, but: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		id = NewCaptcha()
+		if err := login("bad", id, digits(id)); !ErrNeedPwd.Equal(err) {
+			t.Fatalf("expect ErrNeedPwd, but: %v", err)
+		}
+	}
+	needCaptcha(true)
+	for _, solved := range []bool{false, true} {
+		id = NewCaptcha()
+		d = "x"
+		if solved {
+			d = digits(id)
+		}
+		if err := login("hello", id, d); !ErrReject.Equal(err) {
+			t.Fatalf("expect ErrReject of the solved %v, but: %v", solved, err)
+		}
+	}
+	if _, err := UnlockAuth(username, ""); err != nil {
+		t.Fatal(err)
+	}
+	needCaptcha(false)
+	if err := login("hello", "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// the session login is locked when the captcha is disabled.
+	SetLimitPolicy(LimitPolicy{Times: 0, Backoff: time.Hour})
+	if err := login("bad", "", ""); !ErrNeedPwd.Equal(err) {
+		t.Fatalf("expect ErrNeedPwd, but: %v", err)
+	}
+	if err := login("hello", "", ""); !ErrReject.Equal(err) {
+		t.Fatalf("expect ErrReject, but: %v", err)
+	}
+	if _, err := UnlockAuth(username, ""); err != nil {
+		t.Fatal(err)
+	}
+}
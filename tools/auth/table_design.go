@@ -6,9 +6,177 @@ CREATE TABLE IF NOT EXISTS user_info (
 	id TEXT NOT NULL PRIMARY KEY,
 	created_at DATETIME NOT NULL DEFAULT (datetime('now', 'localtime')),
 	updated_at DATETIME NOT NULL DEFAULT (datetime('now', 'localtime')),
-	passwd TEXT NOT NULL,
+	passwd TEXT NOT NULL, -- HA1 of digest
+	passwd_realm TEXT NOT NULL DEFAULT 'mdoc', -- the realm of HA1
+	passwd_kdf TEXT NOT NULL DEFAULT '', -- argon2id
+	passwd_sha256 TEXT NOT NULL DEFAULT '', -- HA1 of digest with SHA-256
+	passwd_sha512_256 TEXT NOT NULL DEFAULT '', -- HA1 of digest with SHA-512-256
 	nick_name TEXT NOT NULL DEFAULT '',
 	kind INT NOT NULL DEFAULT 2, -- 1, admin; 2, users.
+	memo TEXT NOT NULL DEFAULT '',
+	disabled INT NOT NULL DEFAULT 0 -- 0, enabled; 1, disabled.
+);`
+
+	tb_sys_cfg_sql = `
+CREATE TABLE IF NOT EXISTS sys_cfg (
+	id TEXT NOT NULL PRIMARY KEY,
+	updated_at DATETIME NOT NULL DEFAULT (datetime('now', 'localtime')),
+	value TEXT NOT NULL DEFAULT ''
+);`
+
+	tb_digest_nonce_sql = `
+CREATE TABLE IF NOT EXISTS digest_nonce (
+	nonce TEXT NOT NULL PRIMARY KEY,
+	nc INT NOT NULL DEFAULT 0,
+	last_seen INT NOT NULL DEFAULT 0 -- unix nano
+);
+CREATE INDEX IF NOT EXISTS digest_nonce_idx0 ON digest_nonce(last_seen);
+`
+
+	tb_user_session_sql = `
+CREATE TABLE IF NOT EXISTS user_session (
+	id TEXT NOT NULL PRIMARY KEY,
+	created_at DATETIME NOT NULL DEFAULT (datetime('now', 'localtime')),
+	user_id TEXT NOT NULL,
+	ip TEXT NOT NULL DEFAULT '',
+	expired_at INT NOT NULL DEFAULT 0 -- unix seconds
+);
+CREATE INDEX IF NOT EXISTS user_session_idx0 ON user_session(user_id);
+CREATE INDEX IF NOT EXISTS user_session_idx1 ON user_session(expired_at);
+`
+
+	tb_group_info_sql = `
+CREATE TABLE IF NOT EXISTS group_info (
+	id TEXT NOT NULL PRIMARY KEY,
+	created_at DATETIME NOT NULL DEFAULT (datetime('now', 'localtime')),
+	updated_at DATETIME NOT NULL DEFAULT (datetime('now', 'localtime')),
+	role INT NOT NULL DEFAULT 1, -- 1, viewer; 2, editor; 3, admin.
 	memo TEXT NOT NULL DEFAULT ''
 );`
+
+	tb_group_member_sql = `
+CREATE TABLE IF NOT EXISTS group_member (
+	group_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT (datetime('now', 'localtime')),
+	PRIMARY KEY (group_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS group_member_idx0 ON group_member(user_id);
+`
+
+	tb_user_passwd_history_sql = `
+CREATE TABLE IF NOT EXISTS user_passwd_history (
+	user_id TEXT NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT (datetime('now', 'localtime')),
+	passwd TEXT NOT NULL,
+	passwd_kdf TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS user_passwd_history_idx0 ON user_passwd_history(user_id);
+`
+
+	tb_user_totp_sql = `
+CREATE TABLE IF NOT EXISTS user_totp (
+	user_id TEXT NOT NULL PRIMARY KEY,
+	created_at DATETIME NOT NULL DEFAULT (datetime('now', 'localtime')),
+	secret TEXT NOT NULL, -- base32
+	enabled INT NOT NULL DEFAULT 0, -- 0, enrolled; 1, confirmed.
+	last_step INT NOT NULL DEFAULT 0 -- the last used time step, for preventing replay.
+);`
+
+	tb_user_recovery_code_sql = `
+CREATE TABLE IF NOT EXISTS user_recovery_code (
+	user_id TEXT NOT NULL,
+	code TEXT NOT NULL, -- sha256 of the code
+	used INT NOT NULL DEFAULT 0,
+	PRIMARY KEY (user_id, code)
+);`
+
+	tb_user_token_sql = `
+CREATE TABLE IF NOT EXISTS user_token (
+	id TEXT NOT NULL PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	name TEXT NOT NULL DEFAULT '',
+	scope TEXT NOT NULL DEFAULT '', -- read,write,admin
+	secret TEXT NOT NULL, -- sha256 of the secret
+	created_at INT NOT NULL DEFAULT 0, -- unix seconds
+	expired_at INT NOT NULL DEFAULT 0, -- unix seconds, 0 for never expired
+	last_used_at INT NOT NULL DEFAULT 0, -- unix seconds
+	revoked INT NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS user_token_idx0 ON user_token(user_id);
+`
+
+	tb_user_invite_sql = `
+CREATE TABLE IF NOT EXISTS user_invite (
+	id TEXT NOT NULL PRIMARY KEY, -- uuid
+	secret TEXT NOT NULL, -- sha256 of the token
+	user_id TEXT NOT NULL DEFAULT '', -- the bound username, empty for choosing by the invitee
+	email TEXT NOT NULL DEFAULT '', -- the bound email
+	created_by TEXT NOT NULL DEFAULT '',
+	created_at INT NOT NULL DEFAULT 0, -- unix seconds
+	expired_at INT NOT NULL DEFAULT 0, -- unix seconds
+	used_at INT NOT NULL DEFAULT 0, -- unix seconds, 0 for unused
+	used_by TEXT NOT NULL DEFAULT '', -- the username made by the invite
+	revoked INT NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX IF NOT EXISTS user_invite_idx0 ON user_invite(secret);
+`
+
+	tb_auth_limit_sql = `
+CREATE TABLE IF NOT EXISTS auth_limit (
+	id TEXT NOT NULL PRIMARY KEY, -- username and ip
+	user_id TEXT NOT NULL,
+	ip TEXT NOT NULL DEFAULT '',
+	times INT NOT NULL DEFAULT 0, -- login failures
+	updated_at INT NOT NULL DEFAULT 0, -- unix seconds
+	expired_at INT NOT NULL DEFAULT 0 -- unix seconds
+);
+CREATE INDEX IF NOT EXISTS auth_limit_idx0 ON auth_limit(user_id);
+CREATE INDEX IF NOT EXISTS auth_limit_idx1 ON auth_limit(expired_at);
+`
+
+	tb_auth_audit_sql = `
+CREATE TABLE IF NOT EXISTS auth_audit (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at INT NOT NULL DEFAULT 0, -- unix seconds
+	user_id TEXT NOT NULL DEFAULT '', -- the operator
+	ip TEXT NOT NULL DEFAULT '',
+	action TEXT NOT NULL,
+	result TEXT NOT NULL,
+	target TEXT NOT NULL DEFAULT '', -- the target user or group
+	memo TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS auth_audit_idx0 ON auth_audit(created_at);
+CREATE INDEX IF NOT EXISTS auth_audit_idx1 ON auth_audit(user_id);
+-- the records are append only, only the expired records can be deleted.
+CREATE TRIGGER IF NOT EXISTS auth_audit_no_update BEFORE UPDATE ON auth_audit
+BEGIN
+	SELECT RAISE(ABORT, 'auth_audit is append only');
+END;
+`
+
+	tb_doc_read_sql = `
+CREATE TABLE IF NOT EXISTS doc_read (
+	user_id TEXT NOT NULL,
+	path TEXT NOT NULL, -- uri of the page
+	day TEXT NOT NULL, -- local day of 2006-01-02
+	first_at INT NOT NULL DEFAULT 0, -- unix seconds
+	last_at INT NOT NULL DEFAULT 0, -- unix seconds
+	times INT NOT NULL DEFAULT 0,
+	PRIMARY KEY (user_id, path, day)
+);
+CREATE INDEX IF NOT EXISTS doc_read_idx0 ON doc_read(path, day);
+`
 )
+
+// columns added after the table created, [table, column, define]
+var tb_columns_upgrade = [][3]string{
+	{"user_info", "disabled", "INT NOT NULL DEFAULT 0"},
+	{"user_info", "passwd_kdf", "TEXT NOT NULL DEFAULT ''"},
+	{"user_passwd_history", "passwd_kdf", "TEXT NOT NULL DEFAULT ''"},
+	// the HA1 made before the realm configurable is in the 'mdoc' realm.
+	{"user_info", "passwd_realm", "TEXT NOT NULL DEFAULT 'mdoc'"},
+	// the HA1 of the RFC 7616 algorithms, made when the user login with the plain password.
+	{"user_info", "passwd_sha256", "TEXT NOT NULL DEFAULT ''"},
+	{"user_info", "passwd_sha512_256", "TEXT NOT NULL DEFAULT ''"},
+}
@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gwaylib/database"
+	"github.com/gwaylib/errors"
+)
+
+const (
+	TOKEN_SCOPE_READ  = "read"  // read the documents
+	TOKEN_SCOPE_WRITE = "write" // read and write the documents
+	TOKEN_SCOPE_ADMIN = "admin" // administer the users, need the user is admin
+
+	_TOKEN_PREFIX       = "mdoc_"
+	_TOKEN_ID_SIZE      = 8
+	_TOKEN_SECRET_SIZE  = 24
+	_TOKEN_USE_INTERVAL = 60 // seconds, the interval to record the last used time
+)
+
+var (
+	ErrTokenInvalid = errors.New("Invalid token")
+)
+
+type UserToken struct {
+	ID         string `db:"id"`
+	UserID     string `db:"user_id"`
+	Name       string `db:"name"`
+	Scope      string `db:"scope"` // comma separated scopes
+	CreatedAt  int64  `db:"created_at"`
+	ExpiredAt  int64  `db:"expired_at"` // unix seconds, zero for never expired
+	LastUsedAt int64  `db:"last_used_at"`
+	Revoked    bool   `db:"revoked"`
+}
+
+func (t *UserToken) HasScope(scope string) bool {
+	for _, s := range strings.Split(t.Scope, ",") {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Perm returns the acl permissions granted by the scopes.
+func (t *UserToken) Perm() int {
+	perm := 0
+	if t.HasScope(TOKEN_SCOPE_READ) {
+		perm |= ACL_PERM_READ
+	}
+	if t.HasScope(TOKEN_SCOPE_WRITE) || t.HasScope(TOKEN_SCOPE_ADMIN) {
+		perm |= ACL_PERM_READ | ACL_PERM_WRITE
+	}
+	return perm
+}
+
+// ParseTokenScope returns the normalized scope, error if there is unknow scope.
+func ParseTokenScope(scope string) (string, error) {
+	result := []string{}
+	for _, s := range strings.Split(scope, ",") {
+		s = strings.TrimSpace(s)
+		switch s {
+		case TOKEN_SCOPE_READ, TOKEN_SCOPE_WRITE, TOKEN_SCOPE_ADMIN:
+			result = append(result, s)
+		case "":
+		default:
+			return "", errors.New("unknow scope").As(s)
+		}
+	}
+	if len(result) == 0 {
+		return "", errors.New("need scope")
+	}
+	return strings.Join(result, ","), nil
+}
+
+func randHex(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.As(err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken makes a new token for the user, the token is "mdoc_<id>_<secret>",
+// only the hash of secret is stored, so the token can only be got once.
+func CreateToken(username, name, scope string, expires time.Duration) (string, *UserToken, error) {
+	id, err := randHex(_TOKEN_ID_SIZE)
+	if err != nil {
+		return "", nil, errors.As(err)
+	}
+	secret, err := randHex(_TOKEN_SECRET_SIZE)
+	if err != nil {
+		return "", nil, errors.As(err)
+	}
+	now := time.Now()
+	t := &UserToken{
+		ID:        id,
+		UserID:    username,
+		Name:      name,
+		Scope:     scope,
+		CreatedAt: now.Unix(),
+	}
+	if expires > 0 {
+		t.ExpiredAt = now.Add(expires).Unix()
+	}
+	db := GetDB()
+	if _, err := db.Exec(
+		"INSERT INTO user_token(id,user_id,name,scope,secret,created_at,expired_at)VALUES(?,?,?,?,?,?,?)",
+		t.ID, t.UserID, t.Name, t.Scope, hashToken(secret), t.CreatedAt, t.ExpiredAt,
+	); err != nil {
+		return "", nil, errors.As(err, username, name)
+	}
+	return _TOKEN_PREFIX + id + "_" + secret, t, nil
+}
+
+func ListTokens(username string) ([]UserToken, error) {
+	result := []UserToken{}
+	db := GetDB()
+	if err := database.QueryStructs(db, &result,
+		"SELECT id,user_id,name,scope,created_at,expired_at,last_used_at,revoked FROM user_token WHERE user_id=? ORDER BY created_at",
+		username,
+	); err != nil {
+		return nil, errors.As(err, username)
+	}
+	return result, nil
+}
+
+// RevokeToken revokes the token of user, errors.ErrNoData will be returned if the token not found.
+func RevokeToken(username, id string) error {
+	db := GetDB()
+	result, err := db.Exec("UPDATE user_token SET revoked=1 WHERE user_id=? AND id=?", username, id)
+	if err != nil {
+		return errors.As(err, username, id)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return errors.As(err)
+	}
+	if n == 0 {
+		return errors.ErrNoData.As(username, id)
+	}
+	return nil
+}
+
+// BearerToken returns the token of "Authorization: Bearer <token>", empty if not found.
+func BearerToken(req *http.Request) string {
+	authorization := req.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(authorization[7:])
+}
+
+// CheckToken returns the token info of the bearer token, the last used time will be recorded,
+// ErrTokenInvalid will be returned if the token not found, expired, revoked or the user is disabled.
+func CheckToken(token string) (*UserToken, error) {
+	if !strings.HasPrefix(token, _TOKEN_PREFIX) {
+		return nil, ErrTokenInvalid.As("prefix")
+	}
+	parts := strings.SplitN(token[len(_TOKEN_PREFIX):], "_", 2)
+	if len(parts) != 2 {
+		return nil, ErrTokenInvalid.As("format")
+	}
+
+	t := &UserToken{}
+	secret := ""
+	db := GetDB()
+	row := db.QueryRow(
+		"SELECT id,user_id,name,scope,secret,created_at,expired_at,last_used_at,revoked FROM user_token WHERE id=?",
+		parts[0],
+	)
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &secret, &t.CreatedAt, &t.ExpiredAt, &t.LastUsedAt, &t.Revoked); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTokenInvalid.As("not found")
+		}
+		return nil, errors.As(err)
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(hashToken(parts[1]))) != 1 {
+		return nil, ErrTokenInvalid.As(t.ID, "secret")
+	}
+	now := time.Now().Unix()
+	if t.Revoked {
+		return nil, ErrTokenInvalid.As(t.ID, "revoked")
+	}
+	if t.ExpiredAt > 0 && t.ExpiredAt < now {
+		return nil, ErrTokenInvalid.As(t.ID, "expired")
+	}
+	uInfo, err := GetUser(t.UserID)
+	if err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return nil, ErrTokenInvalid.As(t.ID, "user not found")
+		}
+		return nil, errors.As(err)
+	}
+	if uInfo.Disabled {
+		return nil, ErrTokenInvalid.As(t.ID, "user disabled")
+	}
+
+	if now-t.LastUsedAt > _TOKEN_USE_INTERVAL {
+		if _, err := db.Exec("UPDATE user_token SET last_used_at=? WHERE id=?", now, t.ID); err != nil {
+			return nil, errors.As(err)
+		}
+		t.LastUsedAt = now
+	}
+	return t, nil
+}
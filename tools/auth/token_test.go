@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gwaylib/errors"
+)
+
+func TestParseTokenScope(t *testing.T) {
+	scope, err := ParseTokenScope(" read, write ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scope != "read,write" {
+		t.Fatalf("unexpect scope: %s", scope)
+	}
+	if _, err := ParseTokenScope("root"); err == nil {
+		t.Fatal("expect error of unknow scope")
+	}
+	if _, err := ParseTokenScope(""); err == nil {
+		t.Fatal("expect error of empty scope")
+	}
+
+	tk := &UserToken{Scope: "read"}
+	if tk.Perm() != ACL_PERM_READ {
+		t.Fatalf("unexpect perm: %d", tk.Perm())
+	}
+	tk.Scope = "admin"
+	if tk.Perm() != ACL_PERM_READ|ACL_PERM_WRITE || !tk.HasScope(TOKEN_SCOPE_ADMIN) {
+		t.Fatalf("unexpect perm: %d", tk.Perm())
+	}
+}
+
+func TestToken(t *testing.T) {
+	username := fmt.Sprintf("token_%d", time.Now().UnixNano())
+	if err := AddUser(&UserInfo{ID: username, Passwd: HashPasswd(username, REALM, "hello")}); err != nil {
+		t.Fatal(err)
+	}
+	token, info, err := CreateToken(username, "ci", TOKEN_SCOPE_READ, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if BearerToken(req) != token {
+		t.Fatalf("unexpect bearer token: %s", BearerToken(req))
+	}
+	checked, err := CheckToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked.UserID != username || checked.ID != info.ID || checked.LastUsedAt == 0 {
+		t.Fatalf("unexpect token: %+v", checked)
+	}
+	if _, err := CheckToken(token + "x"); !ErrTokenInvalid.Equal(err) {
+		t.Fatalf("expect ErrTokenInvalid, but: %v", err)
+	}
+
+	// the disabled user can not use the token.
+	if err := DisableUser(username, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckToken(token); !ErrTokenInvalid.Equal(err) {
+		t.Fatalf("expect ErrTokenInvalid, but: %v", err)
+	}
+	if err := DisableUser(username, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := ListTokens(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].Name != "ci" {
+		t.Fatalf("unexpect tokens: %+v", tokens)
+	}
+	if err := RevokeToken(username, "unknow"); !errors.ErrNoData.Equal(err) {
+		t.Fatalf("expect ErrNoData, but: %v", err)
+	}
+	if err := RevokeToken(username, info.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckToken(token); !ErrTokenInvalid.Equal(err) {
+		t.Fatalf("expect ErrTokenInvalid, but: %v", err)
+	}
+
+	// expired token
+	expired, _, err := CreateToken(username, "old", TOKEN_SCOPE_READ, time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetDB().Exec("UPDATE user_token SET expired_at=1 WHERE user_id=? AND name='old'", username); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckToken(expired); !ErrTokenInvalid.Equal(err) {
+		t.Fatalf("expect ErrTokenInvalid, but: %v", err)
+	}
+
+	if err := DelUser(username); err != nil {
+		t.Fatal(err)
+	}
+	if tokens, err := ListTokens(username); err != nil || len(tokens) != 0 {
+		t.Fatalf("expect tokens deleted, %v %v", tokens, err)
+	}
+}
@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gwaylib/database"
+	"github.com/gwaylib/errors"
+)
+
+const (
+	// the header to carry the two-factor code with the digest authentication.
+	OTP_HEADER = "X-Mdoc-Otp"
+
+	_TOTP_PERIOD        = 30 // seconds
+	_TOTP_DIGITS        = 6
+	_TOTP_SKEW          = 1 // accept the codes of the adjacent periods for the clock drift
+	_TOTP_SECRET_LEN    = 20
+	_TOTP_RECOVERY_NUM  = 10
+	_TOTP_RECOVERY_SIZE = 10
+)
+
+var (
+	ErrNeedOtp     = errors.New("Need two-factor code")
+	ErrTotpEnabled = errors.New("Two-factor already enabled")
+)
+
+var b32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTotpSecret returns a random base32 secret for the TOTP.
+func NewTotpSecret() (string, error) {
+	key := make([]byte, _TOTP_SECRET_LEN)
+	if _, err := rand.Read(key); err != nil {
+		return "", errors.As(err)
+	}
+	return b32NoPadding.EncodeToString(key), nil
+}
+
+// TotpCode returns the code of RFC 6238 with HMAC-SHA1, 30 seconds and 6 digits.
+func TotpCode(secret string, t time.Time) (string, error) {
+	return totpCode(secret, uint64(t.Unix()/_TOTP_PERIOD))
+}
+
+func totpCode(secret string, step uint64) (string, error) {
+	key, err := b32NoPadding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", errors.As(err)
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, step)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation of RFC 4226
+	offset := sum[len(sum)-1] & 0xf
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", _TOTP_DIGITS, code%1000000), nil
+}
+
+// TotpURI returns the otpauth uri for the authenticator apps.
+func TotpURI(issuer, username, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprintf("%d", _TOTP_DIGITS))
+	v.Set("period", fmt.Sprintf("%d", _TOTP_PERIOD))
+	return fmt.Sprintf("otpauth://totp/%s:%s?%s", url.PathEscape(issuer), url.PathEscape(username), v.Encode())
+}
+
+type userTotp struct {
+	UserID   string `db:"user_id"`
+	Secret   string `db:"secret"`
+	Enabled  bool   `db:"enabled"`
+	LastStep int64  `db:"last_step"`
+}
+
+func getTotp(username string) (*userTotp, error) {
+	t := &userTotp{}
+	db := GetDB()
+	if err := database.QueryStruct(db, t, "SELECT user_id,secret,enabled,last_step FROM user_totp WHERE user_id=?", username); err != nil {
+		return nil, errors.As(err, username)
+	}
+	return t, nil
+}
+
+func TotpEnabled(username string) (bool, error) {
+	t, err := getTotp(username)
+	if err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return false, nil
+		}
+		return false, errors.As(err)
+	}
+	return t.Enabled, nil
+}
+
+// EnrollTotp makes a new secret for the user, it need be confirmed by ConfirmTotp,
+// ErrTotpEnabled will be returned if the two-factor has been enabled.
+func EnrollTotp(username string) (string, error) {
+	enabled, err := TotpEnabled(username)
+	if err != nil {
+		return "", errors.As(err)
+	}
+	if enabled {
+		return "", ErrTotpEnabled.As(username)
+	}
+	secret, err := NewTotpSecret()
+	if err != nil {
+		return "", errors.As(err)
+	}
+	db := GetDB()
+	if _, err := db.Exec(
+		"INSERT INTO user_totp(user_id,secret,enabled,last_step)VALUES(?,?,0,0) ON CONFLICT(user_id) DO UPDATE SET secret=excluded.secret,enabled=0,last_step=0",
+		username, secret,
+	); err != nil {
+		return "", errors.As(err, username)
+	}
+	return secret, nil
+}
+
+// return the matched step of the code, zero if not match.
+func matchTotp(secret, code string, now time.Time) (int64, error) {
+	step := now.Unix() / _TOTP_PERIOD
+	for i := -_TOTP_SKEW; i <= _TOTP_SKEW; i++ {
+		expect, err := totpCode(secret, uint64(step+int64(i)))
+		if err != nil {
+			return 0, errors.As(err)
+		}
+		if subtle.ConstantTimeCompare([]byte(expect), []byte(code)) == 1 {
+			return step + int64(i), nil
+		}
+	}
+	return 0, nil
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToUpper(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// ConfirmTotp enables the two-factor of user with the code of the enrolled secret,
+// and returns the recovery codes which can be used once instead of the code.
+func ConfirmTotp(username, code string) ([]string, error) {
+	t, err := getTotp(username)
+	if err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return nil, ErrNeedOtp.As(username, "not enrolled")
+		}
+		return nil, errors.As(err)
+	}
+	if t.Enabled {
+		return nil, ErrTotpEnabled.As(username)
+	}
+	step, err := matchTotp(t.Secret, code, time.Now())
+	if err != nil {
+		return nil, errors.As(err)
+	}
+	if step == 0 {
+		return nil, ErrNeedOtp.As(username)
+	}
+
+	codes := []string{}
+	db := GetDB()
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, errors.As(err)
+	}
+	if _, err := tx.Exec("DELETE FROM user_recovery_code WHERE user_id=?", username); err != nil {
+		database.Rollback(tx)
+		return nil, errors.As(err, username)
+	}
+	for i := 0; i < _TOTP_RECOVERY_NUM; i++ {
+		key := make([]byte, _TOTP_RECOVERY_SIZE)
+		if _, err := rand.Read(key); err != nil {
+			database.Rollback(tx)
+			return nil, errors.As(err)
+		}
+		code := b32NoPadding.EncodeToString(key)[:_TOTP_RECOVERY_SIZE]
+		if _, err := tx.Exec("INSERT INTO user_recovery_code(user_id,code)VALUES(?,?)", username, hashRecoveryCode(code)); err != nil {
+			database.Rollback(tx)
+			return nil, errors.As(err, username)
+		}
+		codes = append(codes, code)
+	}
+	if _, err := tx.Exec("UPDATE user_totp SET enabled=1,last_step=? WHERE user_id=?", step, username); err != nil {
+		database.Rollback(tx)
+		return nil, errors.As(err, username)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errors.As(err)
+	}
+	return codes, nil
+}
+
+// ResetTotp removes the two-factor of user.
+func ResetTotp(username string) error {
+	db := GetDB()
+	if _, err := db.Exec("DELETE FROM user_recovery_code WHERE user_id=?", username); err != nil {
+		return errors.As(err, username)
+	}
+	if _, err := db.Exec("DELETE FROM user_totp WHERE user_id=?", username); err != nil {
+		return errors.As(err, username)
+	}
+	return nil
+}
+
+// verify the code of the enabled two-factor, the code of TOTP can only be used once,
+// and the recovery code will be used up.
+func verifyOtp(t *userTotp, code string) (bool, error) {
+	db := GetDB()
+	step, err := matchTotp(t.Secret, code, time.Now())
+	if err != nil {
+		return false, errors.As(err)
+	}
+	if step > 0 {
+		if step <= t.LastStep {
+			return false, nil // replay
+		}
+		result, err := db.Exec("UPDATE user_totp SET last_step=? WHERE user_id=? AND last_step<?", step, t.UserID, step)
+		if err != nil {
+			return false, errors.As(err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return false, errors.As(err)
+		}
+		return n > 0, nil
+	}
+
+	if len(code) == 0 {
+		return false, nil
+	}
+	result, err := db.Exec("UPDATE user_recovery_code SET used=1 WHERE user_id=? AND code=? AND used=0", t.UserID, hashRecoveryCode(code))
+	if err != nil {
+		return false, errors.As(err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, errors.As(err)
+	}
+	return n > 0, nil
+}
+
+// check the two-factor code if the user enabled it,
+// the failures are counted with the limit key of the password.
+func checkOtp(username, code string, limitKey *AuthLimit, errTimes int) error {
+	t, err := getTotp(username)
+	if err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return nil
+		}
+		return errors.As(err)
+	}
+	if !t.Enabled {
+		return nil
+	}
+	if len(code) == 0 {
+		return ErrNeedOtp.As(username)
+	}
+	ok, err := verifyOtp(t, code)
+	if err != nil {
+		return errors.As(err)
+	}
+	if !ok {
+		if err := updateAuthLimit(limitKey, errTimes+1); err != nil {
+			return errors.As(err)
+		}
+		return ErrNeedOtp.As(username)
+	}
+	return nil
+}
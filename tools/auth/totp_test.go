@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTotpCode(t *testing.T) {
+	// test vectors of RFC 6238 with SHA1, the secret is "12345678901234567890".
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for ts, expect := range cases {
+		code, err := TotpCode(secret, time.Unix(ts, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expect {
+			t.Fatalf("time %d expect %s, but: %s", ts, expect, code)
+		}
+	}
+}
+
+func TestTotpLogin(t *testing.T) {
+	username := fmt.Sprintf("totp_%d", time.Now().UnixNano())
+	if err := AddUser(&UserInfo{ID: username, Passwd: HashPasswd(username, REALM, "hello")}); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := EnrollTotp(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enabled, err := TotpEnabled(username); err != nil || enabled {
+		t.Fatalf("expect not enabled before confirm, %v", err)
+	}
+	if _, err := ConfirmTotp(username, "000000x"); !ErrNeedOtp.Equal(err) {
+		t.Fatalf("expect ErrNeedOtp, but: %v", err)
+	}
+
+	// confirm with the code of last period, so the current code can be used to login.
+	code, err := TotpCode(secret, time.Now().Add(-_TOTP_PERIOD*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovery, err := ConfirmTotp(username, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovery) != _TOTP_RECOVERY_NUM {
+		t.Fatalf("unexpect recovery codes: %v", recovery)
+	}
+	if _, err := EnrollTotp(username); !ErrTotpEnabled.Equal(err) {
+		t.Fatalf("expect ErrTotpEnabled, but: %v", err)
+	}
+
+	sa, err := NewSessionAuth(REALM, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := func(code string) error {
+		return sa.Login(httptest.NewRecorder(), httptest.NewRequest("POST", "/login", nil), username, "hello", code, "", "")
+	}
+	if err := login(""); !ErrNeedOtp.Equal(err) {
+		t.Fatalf("expect ErrNeedOtp, but: %v", err)
+	}
+	code, err = TotpCode(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := login(code); err != nil {
+		t.Fatal(err)
+	}
+	// the code can not be replayed
+	if err := login(code); !ErrNeedOtp.Equal(err) {
+		t.Fatalf("expect ErrNeedOtp, but: %v", err)
+	}
+	// the recovery code can be used once
+	if err := login(recovery[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := login(recovery[0]); !ErrNeedOtp.Equal(err) {
+		t.Fatalf("expect ErrNeedOtp, but: %v", err)
+	}
+
+	if err := ResetTotp(username); err != nil {
+		t.Fatal(err)
+	}
+	if err := login(""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTotpDigestNonce(t *testing.T) {
+	username := fmt.Sprintf("totp_digest_%d", time.Now().UnixNano())
+	if err := AddUser(&UserInfo{ID: username, Passwd: HashPasswd(username, REALM, "hello")}); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := EnrollTotp(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := TotpCode(secret, time.Now().Add(-_TOTP_PERIOD*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConfirmTotp(username, code); err != nil {
+		t.Fatal(err)
+	}
+	if code, err = TotpCode(secret, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	da := NewDigestAuth(REALM, false, func(user, realm string) string {
+		return HashPasswd(user, realm, "hello")
+	}, NewMemNonceStore())
+	newChallenge := func() string {
+		w := httptest.NewRecorder()
+		da.RequireAuth(w, httptest.NewRequest("GET", "/markdown/README.md", nil))
+		return w.Header().Get("WWW-Authenticate")
+	}
+	check := func(challenge string, nc int, otp string) error {
+		req := httptest.NewRequest("GET", "/markdown/README.md", nil)
+		req.Header.Set("Authorization", digestAuthorization(challenge, "GET", "/markdown/README.md", username, "hello", nc))
+		if len(otp) > 0 {
+			req.Header.Set(OTP_HEADER, otp)
+		}
+		_, err := da.CheckAuth(req)
+		return err
+	}
+
+	challenge := newChallenge()
+	// the nonce is not taken by the failure of two-factor, so the following requests need the code too.
+	for nc := 1; nc <= 2; nc++ {
+		if err := check(challenge, nc, ""); !ErrNeedOtp.Equal(err) {
+			t.Fatalf("expect ErrNeedOtp of nc %d, but: %v", nc, err)
+		}
+	}
+	// the code is bound to the nonce, the following requests of the nonce pass with the same code or without it.
+	if err := check(challenge, 3, code); err != nil {
+		t.Fatal(err)
+	}
+	if err := check(challenge, 4, code); err != nil {
+		t.Fatal(err)
+	}
+	if err := check(challenge, 5, ""); err != nil {
+		t.Fatal(err)
+	}
+	// the code can not be replayed with a new nonce
+	if err := check(newChallenge(), 1, code); !ErrNeedOtp.Equal(err) {
+		t.Fatalf("expect ErrNeedOtp, but: %v", err)
+	}
+	if _, err := UnlockAuth(username, ""); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,6 +2,8 @@ package auth
 
 import (
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gwaylib/database"
 	"github.com/gwaylib/errors"
@@ -10,13 +12,122 @@ import (
 const (
 	USER_KIND_ADMIN  = 1
 	USER_KIND_COMMON = 2
+
+	USER_NAME_MAX_LEN = 64
+	NICK_NAME_MAX_LEN = 64
+)
+
+var (
+	ErrUserName = errors.New("Invalid username")
+	ErrNickName = errors.New("Invalid nickname")
 )
 
+// KindName returns "admin" or "common" of the user kind.
+func KindName(kind int) string {
+	if kind == USER_KIND_ADMIN {
+		return "admin"
+	}
+	return "common"
+}
+
+// ParseKind returns the user kind of the name, the empty name is the common user.
+func ParseKind(name string) (int, bool) {
+	switch name {
+	case "admin":
+		return USER_KIND_ADMIN, true
+	case "common", "":
+		return USER_KIND_COMMON, true
+	}
+	return 0, false
+}
+
+// CheckUserName returns ErrUserName if the username is not the letters, digits and "._@+-",
+// or not started with a letter or digit, so it can not be confused with the '@group' and '*' of acl,
+// or break the line of htdigest.
+func CheckUserName(username string) error {
+	if len(username) == 0 || len(username) > USER_NAME_MAX_LEN {
+		return ErrUserName.As(username, "length")
+	}
+	for i, r := range username {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case i > 0 && (r == '.' || r == '_' || r == '@' || r == '+' || r == '-'):
+		default:
+			return ErrUserName.As(username, "character")
+		}
+	}
+	return nil
+}
+
+// CheckNickName returns ErrNickName if the nickname is too long or has the control characters,
+// the empty nickname is allowed.
+func CheckNickName(nickName string) error {
+	if !utf8.ValidString(nickName) || utf8.RuneCountInString(nickName) > NICK_NAME_MAX_LEN {
+		return ErrNickName.As(nickName, "length")
+	}
+	for _, r := range nickName {
+		if unicode.IsControl(r) {
+			return ErrNickName.As(nickName, "character")
+		}
+	}
+	return nil
+}
+
 type UserInfo struct {
-	ID       string `db:"id"`
-	Passwd   string `db:"passwd"`
-	NickName string `db:"nick_name"`
-	Kind     int    `db:"kind"`
+	ID          string `db:"id"`
+	Passwd      string `db:"passwd"`       // HA1 of digest, empty when the digest is disabled
+	PasswdRealm string `db:"passwd_realm"` // the realm of HA1, the HA1 of other realms needs to be reset
+	PasswdKdf   string `db:"passwd_kdf"`   // see HashKdf
+	// HA1 of the digest algorithms of RFC 7616, in the realm of PasswdRealm.
+	PasswdSha256     string `db:"passwd_sha256"`
+	PasswdSha512_256 string `db:"passwd_sha512_256"`
+	NickName         string `db:"nick_name"`
+	Kind             int    `db:"kind"`
+	Memo             string `db:"memo"`
+	Disabled         bool   `db:"disabled"`
+}
+
+// DigestPasswd returns the HA1 of the digest algorithm, empty if the user has no HA1 of the algorithm.
+func (uInfo *UserInfo) DigestPasswd(algorithm string) string {
+	switch algorithm {
+	case DIGEST_MD5:
+		return uInfo.Passwd
+	case DIGEST_SHA256:
+		return uInfo.PasswdSha256
+	case DIGEST_SHA512_256:
+		return uInfo.PasswdSha512_256
+	}
+	return ""
+}
+
+// SetPlainPasswd sets the kdf hash and the HA1 of all the digest algorithms with the plain password,
+// it is used before AddUser, the HA1 is not set when the digest is disabled.
+func (uInfo *UserInfo) SetPlainPasswd(realm, passwd string) error {
+	kdf, err := HashKdf(passwd)
+	if err != nil {
+		return errors.As(err)
+	}
+	stored := &storedPasswd{PasswdKdf: kdf}
+	stored.setDigest(uInfo.ID, realm, passwd)
+	uInfo.Passwd = stored.Passwd
+	uInfo.PasswdSha256 = stored.PasswdSha256
+	uInfo.PasswdSha512_256 = stored.PasswdSha512_256
+	uInfo.PasswdKdf = stored.PasswdKdf
+	uInfo.PasswdRealm = realm
+	return nil
+}
+
+// UserItem is the user info for listing, the password is not included.
+type UserItem struct {
+	ID        string    `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+	NickName  string    `db:"nick_name"`
+	Kind      int       `db:"kind"`
+	Memo      string    `db:"memo"`
+	Disabled  bool      `db:"disabled"`
+	// the HA1 was made in other realm, the user needs to reset the password.
+	PasswdStale bool `db:"passwd_stale"`
 }
 
 func AddUser(uInfo *UserInfo) error {
@@ -24,6 +135,9 @@ func AddUser(uInfo *UserInfo) error {
 	if uInfo.Kind == 0 {
 		uInfo.Kind = USER_KIND_COMMON // if the kind not set, fix to common user.
 	}
+	if len(uInfo.PasswdRealm) == 0 {
+		uInfo.PasswdRealm = GetRealm()
+	}
 	if _, err := database.InsertStruct(db, uInfo, "user_info"); err != nil {
 		return errors.As(err)
 	}
@@ -39,10 +153,171 @@ func GetUser(username string) (*UserInfo, error) {
 	return uInfo, nil
 }
 
+// ResetPwd changes the password with the digest hash(HA1) of the current realm, the kdf hash
+// and the HA1 of other algorithms will be removed, and the old password will be kept in the history for the PasswdPolicy.
 func ResetPwd(username, passwd string) error {
+	return resetPasswd(username, GetRealm(), &storedPasswd{Passwd: passwd})
+}
+
+func resetPasswd(username, passwdRealm string, passwd *storedPasswd) error {
+	uInfo, err := GetUser(username)
+	if err != nil {
+		return errors.As(err, username)
+	}
+
+	db := GetDB()
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.As(err, username)
+	}
+	if err := addPasswdHistory(tx, username, &storedPasswd{Passwd: uInfo.Passwd, PasswdKdf: uInfo.PasswdKdf}); err != nil {
+		database.Rollback(tx)
+		return errors.As(err, username)
+	}
+	if _, err := tx.Exec(
+		"UPDATE user_info set passwd=?,passwd_realm=?,passwd_kdf=?,passwd_sha256=?,passwd_sha512_256=?,updated_at=? WHERE id=?",
+		passwd.Passwd, passwdRealm, passwd.PasswdKdf, passwd.PasswdSha256, passwd.PasswdSha512_256, time.Now(), username,
+	); err != nil {
+		database.Rollback(tx)
+		return errors.As(err, username)
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.As(err, username)
+	}
+	return nil
+}
+
+// CountAdmin returns the number of the enabled admins.
+func CountAdmin() (int, error) {
+	count := 0
+	db := GetDB()
+	if err := database.QueryElem(db, &count, "SELECT count(*) FROM user_info WHERE kind=? AND disabled=0", USER_KIND_ADMIN); err != nil {
+		return 0, errors.As(err)
+	}
+	return count, nil
+}
+
+func UpdateUserKind(username string, kind int) error {
+	db := GetDB()
+	if _, err := db.Exec("UPDATE user_info set kind=?,updated_at=? WHERE id=?", kind, time.Now(), username); err != nil {
+		return errors.As(err, username, kind)
+	}
+	return nil
+}
+
+const _USER_ITEM_SQL = "SELECT id,created_at,updated_at,nick_name,kind,memo,disabled,(passwd<>'' AND passwd_realm<>?) AS passwd_stale FROM user_info"
+
+func ListUsers() ([]UserItem, error) {
+	return ListUsersPage(0, 0)
+}
+
+// ListUsersPage returns the users in the order of created, zero limit for no limit.
+func ListUsersPage(limit, offset int) ([]UserItem, error) {
+	qsql := _USER_ITEM_SQL + " ORDER BY created_at,id"
+	args := []interface{}{GetRealm()}
+	if limit > 0 {
+		qsql += " LIMIT ? OFFSET ?"
+		args = append(args, limit, offset)
+	}
+	result := []UserItem{}
+	db := GetDB()
+	if err := database.QueryStructs(db, &result, qsql, args...); err != nil {
+		return nil, errors.As(err, limit, offset)
+	}
+	return result, nil
+}
+
+// GetUserItem returns the user for listing, errors.ErrNoData if not found.
+func GetUserItem(username string) (*UserItem, error) {
+	item := &UserItem{}
+	db := GetDB()
+	if err := database.QueryStruct(db, item, _USER_ITEM_SQL+" WHERE id=?", GetRealm(), username); err != nil {
+		return nil, errors.As(err, username)
+	}
+	return item, nil
+}
+
+func CountUsers() (int, error) {
+	count := 0
+	db := GetDB()
+	if err := database.QueryElem(db, &count, "SELECT count(*) FROM user_info"); err != nil {
+		return 0, errors.As(err)
+	}
+	return count, nil
+}
+
+// DelUser removes the user with the groups, passwords, two-factor, tokens, sessions and login failures of the user,
+// the audits and the page reads are kept for the history.
+func DelUser(username string) error {
+	db := GetDB()
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.As(err, username)
+	}
+	for _, execSql := range []string{
+		"DELETE FROM group_member WHERE user_id=?",
+		"DELETE FROM user_passwd_history WHERE user_id=?",
+		"DELETE FROM user_recovery_code WHERE user_id=?",
+		"DELETE FROM user_totp WHERE user_id=?",
+		"DELETE FROM user_token WHERE user_id=?",
+		"DELETE FROM user_session WHERE user_id=?",
+		"DELETE FROM auth_limit WHERE user_id=?",
+		"DELETE FROM user_info WHERE id=?",
+	} {
+		if _, err := tx.Exec(execSql, username); err != nil {
+			database.Rollback(tx)
+			return errors.As(err, username, execSql)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.As(err, username)
+	}
+	return nil
+}
+
+func DisableUser(username string, disabled bool) error {
+	db := GetDB()
+	if _, err := db.Exec("UPDATE user_info set disabled=?,updated_at=? WHERE id=?", disabled, time.Now(), username); err != nil {
+		return errors.As(err, username, disabled)
+	}
+	return nil
+}
+
+func UpdateUserInfo(username, nickName, memo string) error {
 	db := GetDB()
-	if _, err := db.Exec("UPDATE user_info set passwd=?,updated_at=? WHERE id=?", passwd, time.Now(), username); err != nil {
+	if _, err := db.Exec("UPDATE user_info set nick_name=?,memo=?,updated_at=? WHERE id=?", nickName, memo, time.Now(), username); err != nil {
 		return errors.As(err, username)
 	}
 	return nil
 }
+
+const (
+	// the built-in group of admins for the acl.
+	USER_GROUP_ADMIN = "admin"
+)
+
+// UserGroups returns the groups of user for the acl,
+// only the groups whose role grants the permission are returned.
+func UserGroups(username string, perm int) ([]string, error) {
+	uInfo, err := GetUser(username)
+	if err != nil {
+		if errors.ErrNoData.Equal(err) {
+			return []string{}, nil
+		}
+		return nil, errors.As(err)
+	}
+	groups := []string{}
+	if uInfo.Kind == USER_KIND_ADMIN {
+		groups = append(groups, USER_GROUP_ADMIN)
+	}
+	roleGroups, err := UserRoleGroups(username)
+	if err != nil {
+		return nil, errors.As(err)
+	}
+	for name, role := range roleGroups {
+		if RolePerm(role)&perm == perm {
+			groups = append(groups, name)
+		}
+	}
+	return groups, nil
+}
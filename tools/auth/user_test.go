@@ -2,7 +2,9 @@ package auth
 
 import (
 	"fmt"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -30,3 +32,135 @@ func TestUserInfo(t *testing.T) {
 		t.Fatalf("expect %+v, but: %+v\n", input, output)
 	}
 }
+
+func TestUserLifecycle(t *testing.T) {
+	username := fmt.Sprintf("lifecycle_%d", time.Now().UnixNano())
+	if err := AddUser(&UserInfo{ID: username, Passwd: "testing", NickName: "testing"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := UpdateUserInfo(username, "nick", "memo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateUserKind(username, USER_KIND_ADMIN); err != nil {
+		t.Fatal(err)
+	}
+	if err := DisableUser(username, true); err != nil {
+		t.Fatal(err)
+	}
+	output, err := GetUser(username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.NickName != "nick" || output.Memo != "memo" || output.Kind != USER_KIND_ADMIN || !output.Disabled {
+		t.Fatalf("unexpect user: %+v", output)
+	}
+
+	users, err := ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, u := range users {
+		if u.ID == username {
+			found = true
+			if u.CreatedAt.IsZero() || u.UpdatedAt.IsZero() {
+				t.Fatalf("unexpect time: %+v", u)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("%s not found in list", username)
+	}
+
+	// the sessions and the login failures are removed with the user.
+	if err := addSession(&Session{ID: username, UserID: username, ExpiredAt: time.Now().Add(time.Hour).Unix()}); err != nil {
+		t.Fatal(err)
+	}
+	limitKey := authLimitKey(httptest.NewRequest("GET", "/", nil), username)
+	if err := updateAuthLimit(limitKey, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := DelUser(username); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetUser(username); !errors.ErrNoData.Equal(err) {
+		t.Fatal("need data not exist, but: ", err)
+	}
+	if _, err := getSession(username); !errors.ErrNoData.Equal(err) {
+		t.Fatal("need session not exist, but: ", err)
+	}
+	if times, err := getAuthLimit(limitKey); err != nil || times != 0 {
+		t.Fatalf("need no failure, but: %d %v", times, err)
+	}
+}
+
+func TestUserNameCheck(t *testing.T) {
+	for _, name := range []string{"a", "newone", "new.one_2", "new-one+doc@example.com", strings.Repeat("a", USER_NAME_MAX_LEN)} {
+		if err := CheckUserName(name); err != nil {
+			t.Fatalf("expect %q passed, but: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "@admin", "*", ".one", "new one", "new:one", "新", strings.Repeat("a", USER_NAME_MAX_LEN+1)} {
+		if err := CheckUserName(name); !ErrUserName.Equal(err) {
+			t.Fatalf("expect ErrUserName of %q, but: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "New One", "新用户", strings.Repeat("新", NICK_NAME_MAX_LEN)} {
+		if err := CheckNickName(name); err != nil {
+			t.Fatalf("expect %q passed, but: %v", name, err)
+		}
+	}
+	for _, name := range []string{"new\none", "new\tone", "\xff", strings.Repeat("a", NICK_NAME_MAX_LEN+1)} {
+		if err := CheckNickName(name); !ErrNickName.Equal(err) {
+			t.Fatalf("expect ErrNickName of %q, but: %v", name, err)
+		}
+	}
+}
+
+func TestUserPage(t *testing.T) {
+	prefix := fmt.Sprintf("page_%d_", time.Now().UnixNano())
+	for i := 0; i < 3; i++ {
+		if err := AddUser(&UserInfo{ID: fmt.Sprintf("%s%d", prefix, i), Passwd: "testing"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	total, err := CountUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != len(all) || total < 3 {
+		t.Fatalf("expect the count %d of all, but: %d", len(all), total)
+	}
+	// the users of other tests may be created in the same second and sorted after them.
+	offset := -1
+	for i, u := range all {
+		if u.ID == prefix+"0" {
+			offset = i
+		}
+	}
+	if offset < 0 {
+		t.Fatalf("expect %s0 in all", prefix)
+	}
+	page, err := ListUsersPage(2, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 || page[0].ID != prefix+"0" || page[1].ID != prefix+"1" {
+		t.Fatalf("unexpect page: %+v", page)
+	}
+	item, err := GetUserItem(prefix + "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Kind != USER_KIND_COMMON || item.CreatedAt.IsZero() {
+		t.Fatalf("unexpect user: %+v", item)
+	}
+	if _, err := GetUserItem(prefix + "none"); !errors.ErrNoData.Equal(err) {
+		t.Fatalf("expect ErrNoData, but: %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gwaylib/database"
+	"github.com/gwaylib/errors"
+)
+
+var (
+	ErrImportFormat = errors.New("Invalid import format")
+)
+
+// ImportUser is a user read from the htdigest or csv file.
+type ImportUser struct {
+	ID          string
+	Passwd      string // HA1 of digest
+	Realm       string // the realm of HA1
+	PlainPasswd string
+	NickName    string
+	Memo        string
+	Kind        int
+	Disabled    bool
+}
+
+// UserExport is the user info for exporting, the Passwd is only set when the secrets are exported.
+type UserExport struct {
+	ID        string    `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+	NickName  string    `db:"nick_name"`
+	Kind      int       `db:"kind"`
+	Memo      string    `db:"memo"`
+	Disabled  bool      `db:"disabled"`
+	Passwd    string    `db:"passwd" json:",omitempty"` // HA1 of digest
+	// the realm of HA1
+	PasswdRealm string `db:"passwd_realm" json:",omitempty"`
+}
+
+func ExportUsers(secrets bool) ([]UserExport, error) {
+	result := []UserExport{}
+	db := GetDB()
+	if err := database.QueryStructs(db, &result,
+		"SELECT id,created_at,updated_at,nick_name,kind,memo,disabled,passwd,passwd_realm FROM user_info ORDER BY created_at,id",
+	); err != nil {
+		return nil, errors.As(err)
+	}
+	if !secrets {
+		for i := range result {
+			result[i].Passwd = ""
+			result[i].PasswdRealm = ""
+		}
+	}
+	return result, nil
+}
+
+// ParseHtdigest reads the users of the Apache htdigest file, the line is 'user:realm:HA1'.
+// The HA1 is bound to the realm, so the lines of other realms are rejected if the realm is not empty.
+func ParseHtdigest(r io.Reader, realm string) ([]ImportUser, error) {
+	users := []ImportUser{}
+	names := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, ":")
+		if len(fields) != 3 || len(fields[0]) == 0 || len(fields[2]) != 32 {
+			return nil, ErrImportFormat.As(line, "need user:realm:HA1")
+		}
+		if len(realm) > 0 && fields[1] != realm {
+			return nil, ErrImportFormat.As(line, "realm not match", fields[1], realm)
+		}
+		if names[fields[0]] {
+			return nil, ErrImportFormat.As(line, "duplicate user", fields[0])
+		}
+		names[fields[0]] = true
+		users = append(users, ImportUser{
+			ID:     fields[0],
+			Passwd: strings.ToLower(fields[2]),
+			Realm:  fields[1],
+			Kind:   USER_KIND_COMMON,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.As(err)
+	}
+	return users, nil
+}
+
+// ParseUserCSV reads the users of the csv file, the first line is the header.
+// The 'username' column is required, and one of the 'passwd'(plain) or 'ha1' column is required for each user,
+// the 'realm' of ha1, 'nickname', 'memo', 'kind'(admin|common) and 'disabled' columns are optional, other columns are ignored.
+func ParseUserCSV(r io.Reader) ([]ImportUser, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, ErrImportFormat.As("need header")
+		}
+		return nil, ErrImportFormat.As(err)
+	}
+	cols := map[string]int{}
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := cols["username"]; !ok {
+		return nil, ErrImportFormat.As("need username column")
+	}
+	reader.FieldsPerRecord = len(header)
+
+	users := []ImportUser{}
+	names := map[string]bool{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ErrImportFormat.As(err)
+		}
+		get := func(name string) string {
+			if i, ok := cols[name]; ok {
+				return record[i]
+			}
+			return ""
+		}
+		u := ImportUser{
+			ID:          strings.TrimSpace(get("username")),
+			Passwd:      strings.ToLower(strings.TrimSpace(get("ha1"))),
+			Realm:       strings.TrimSpace(get("realm")),
+			PlainPasswd: get("passwd"),
+			NickName:    get("nickname"),
+			Memo:        get("memo"),
+		}
+		if len(u.ID) == 0 {
+			return nil, ErrImportFormat.As(line, "need username")
+		}
+		if names[u.ID] {
+			return nil, ErrImportFormat.As(line, "duplicate user", u.ID)
+		}
+		names[u.ID] = true
+		if len(u.PlainPasswd) == 0 && len(u.Passwd) != 32 {
+			return nil, ErrImportFormat.As(line, "need passwd or ha1", u.ID)
+		}
+		kind, ok := ParseKind(strings.TrimSpace(get("kind")))
+		if !ok {
+			return nil, ErrImportFormat.As(line, "unknow kind", get("kind"))
+		}
+		u.Kind = kind
+		if disabled := strings.TrimSpace(get("disabled")); len(disabled) > 0 {
+			u.Disabled, err = strconv.ParseBool(disabled)
+			if err != nil {
+				return nil, ErrImportFormat.As(line, "invalid disabled", disabled)
+			}
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// WriteUserCSV writes the users in the format of ParseUserCSV,
+// the 'ha1' and 'realm' columns are written when secrets is true.
+func WriteUserCSV(w io.Writer, users []UserExport, secrets bool) error {
+	writer := csv.NewWriter(w)
+	header := []string{"username", "nickname", "kind", "disabled", "created_at", "updated_at", "memo"}
+	if secrets {
+		header = append(header, "ha1", "realm")
+	}
+	if err := writer.Write(header); err != nil {
+		return errors.As(err)
+	}
+	for _, u := range users {
+		record := []string{
+			u.ID, u.NickName, KindName(u.Kind), strconv.FormatBool(u.Disabled),
+			u.CreatedAt.Format("2006-01-02 15:04:05"), u.UpdatedAt.Format("2006-01-02 15:04:05"),
+			u.Memo,
+		}
+		if secrets {
+			record = append(record, u.Passwd, u.PasswdRealm)
+		}
+		if err := writer.Write(record); err != nil {
+			return errors.As(err)
+		}
+	}
+	writer.Flush()
+	return errors.As(writer.Error())
+}
+
+// WriteHtdigest writes the users in the htdigest format, the realm is used when the user has no PasswdRealm,
+// the users without HA1 are skipped and returned.
+func WriteHtdigest(w io.Writer, users []UserExport, realm string) ([]string, error) {
+	skipped := []string{}
+	for _, u := range users {
+		if len(u.Passwd) == 0 {
+			skipped = append(skipped, u.ID)
+			continue
+		}
+		userRealm := u.PasswdRealm
+		if len(userRealm) == 0 {
+			userRealm = realm
+		}
+		if _, err := fmt.Fprintf(w, "%s:%s:%s\n", u.ID, userRealm, u.Passwd); err != nil {
+			return nil, errors.As(err)
+		}
+	}
+	return skipped, nil
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHtdigest(t *testing.T) {
+	ha1 := HashPasswd("alice", REALM, "alicepass")
+	data := fmt.Sprintf("# comment\n\nalice:%s:%s\nbob:%s:%s\n", REALM, strings.ToUpper(ha1), REALM, HashPasswd("bob", REALM, "b"))
+	users, err := ParseHtdigest(strings.NewReader(data), REALM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].ID != "alice" || users[0].Passwd != ha1 || users[0].Kind != USER_KIND_COMMON {
+		t.Fatalf("unexpect users: %+v", users)
+	}
+
+	cases := []string{
+		"alice:other:" + ha1,        // realm not match
+		"alice:" + REALM,            // missing HA1
+		"alice:" + REALM + ":short", // invalid HA1
+		":" + REALM + ":" + ha1,     // missing user
+		"alice:" + REALM + ":" + ha1 + "\nalice:" + REALM + ":" + ha1, // duplicate
+	}
+	for i, c := range cases {
+		if _, err := ParseHtdigest(strings.NewReader(c), REALM); !ErrImportFormat.Equal(err) {
+			t.Fatalf("case %d expect ErrImportFormat, but: %v", i, err)
+		}
+	}
+}
+
+func TestParseUserCSV(t *testing.T) {
+	ha1 := HashPasswd("bob", REALM, "bobpass")
+	data := "Username, passwd, ha1, nickname, memo, kind, disabled, created_at\n" +
+		"alice,alicepass,,\"Alice, A\",from csv,admin,,2024-01-01\n" +
+		"bob,,\"" + ha1 + "\",,,common,true,\n"
+	users, err := ParseUserCSV(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("unexpect users: %+v", users)
+	}
+	alice, bob := users[0], users[1]
+	if alice.ID != "alice" || alice.PlainPasswd != "alicepass" || alice.NickName != "Alice, A" || alice.Memo != "from csv" || alice.Kind != USER_KIND_ADMIN || alice.Disabled {
+		t.Fatalf("unexpect alice: %+v", alice)
+	}
+	if bob.ID != "bob" || bob.Passwd != ha1 || len(bob.PlainPasswd) > 0 || bob.Kind != USER_KIND_COMMON || !bob.Disabled {
+		t.Fatalf("unexpect bob: %+v", bob)
+	}
+
+	cases := []string{
+		"",                                   // need header
+		"name,passwd\nalice,pass",            // need username column
+		"username,passwd\nalice,",            // need password
+		"username,passwd\n,pass",             // need username
+		"username,passwd\nalice,a\nalice,b",  // duplicate
+		"username,passwd,kind\nalice,a,root", // unknow kind
+		"username,passwd,disabled\nalice,a,maybe",
+		"username,passwd\nalice,a,extra",
+	}
+	for i, c := range cases {
+		if _, err := ParseUserCSV(strings.NewReader(c)); !ErrImportFormat.Equal(err) {
+			t.Fatalf("case %d expect ErrImportFormat, but: %v", i, err)
+		}
+	}
+}
+
+func TestExportUsers(t *testing.T) {
+	username := fmt.Sprintf("export_%d", time.Now().UnixNano())
+	ha1 := HashPasswd(username, REALM, "exportpass")
+	if err := AddUser(&UserInfo{ID: username, Passwd: ha1, NickName: "Export", Memo: "memo", Kind: USER_KIND_ADMIN}); err != nil {
+		t.Fatal(err)
+	}
+	find := func(users []UserExport) *UserExport {
+		for i := range users {
+			if users[i].ID == username {
+				return &users[i]
+			}
+		}
+		t.Fatalf("user not found: %s", username)
+		return nil
+	}
+
+	users, err := ExportUsers(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := find(users); len(u.Passwd) > 0 || u.NickName != "Export" || u.Kind != USER_KIND_ADMIN {
+		t.Fatalf("unexpect user: %+v", u)
+	}
+	buf := &bytes.Buffer{}
+	if err := WriteUserCSV(buf, users, false); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), ha1) || strings.Contains(buf.String(), "ha1") {
+		t.Fatalf("unexpect secrets: %s", buf.String())
+	}
+
+	users, err = ExportUsers(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := find(users); u.Passwd != ha1 {
+		t.Fatalf("expect the secret: %+v", u)
+	}
+
+	// the exported csv can be imported again.
+	buf.Reset()
+	if err := WriteUserCSV(buf, []UserExport{*find(users)}, true); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := ParseUserCSV(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != 1 || imported[0].ID != username || imported[0].Passwd != ha1 || imported[0].Memo != "memo" || imported[0].Kind != USER_KIND_ADMIN {
+		t.Fatalf("unexpect imported: %+v", imported)
+	}
+
+	// htdigest
+	buf.Reset()
+	skipped, err := WriteHtdigest(buf, []UserExport{*find(users), {ID: "nohash"}}, REALM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 1 || skipped[0] != "nohash" {
+		t.Fatalf("unexpect skipped: %+v", skipped)
+	}
+	imported, err = ParseHtdigest(buf, REALM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != 1 || imported[0].Passwd != ha1 {
+		t.Fatalf("unexpect imported: %+v", imported)
+	}
+}